@@ -2,10 +2,13 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"time"
 
@@ -15,16 +18,55 @@ import (
 )
 
 var clientname string
-var serverURL = "ws://localhost" + fmt.Sprintf(":"+setting.HTTPPort) + "/ws?name="
+var password string
+var register bool
+var httpURL = "http://localhost" + fmt.Sprintf(":"+setting.HTTPPort)
+var serverURL = "ws://localhost" + fmt.Sprintf(":"+setting.HTTPPort) + "/ws"
+
+// post sends the credentials to the endpoint and decodes the json response
+func post(path string, res interface{}) error {
+	body, _ := json.Marshal(map[string]string{"name": clientname, "password": password})
+	resp, err := http.Post(httpURL+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var errRes map[string]string
+		json.NewDecoder(resp.Body).Decode(&errRes)
+		return fmt.Errorf("%s: %s", resp.Status, errRes["error"])
+	}
+
+	return json.NewDecoder(resp.Body).Decode(res)
+}
 
 func main() {
 	flag.StringVar(&clientname, "name", "Alice", "the chatroom login name")
+	flag.StringVar(&password, "password", "", "the chatroom password")
+	flag.BoolVar(&register, "register", false, "register the account before login")
 	flag.Parse()
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	conn, _, err := websocket.Dial(ctx, serverURL+clientname, nil)
+	if register {
+		var account map[string]interface{}
+		if err := post("/register", &account); err != nil {
+			log.Fatalf("Failed to register: %v", err)
+		}
+	}
+
+	var session struct {
+		Token string `json:"token"`
+	}
+	if err := post("/login", &session); err != nil {
+		log.Fatalf("Failed to login: %v", err)
+	}
+
+	conn, _, err := websocket.Dial(ctx, serverURL, &websocket.DialOptions{
+		HTTPHeader: http.Header{"Authorization": []string{"Bearer " + session.Token}},
+	})
 	if err != nil {
 		log.Fatalf("Failed to connect to WebSocket server: %v", err)
 	}
@@ -60,7 +102,11 @@ func main() {
 			break
 		}
 
-		err := wsjson.Write(ctx, conn, text)
+		err := wsjson.Write(ctx, conn, map[string]interface{}{
+			"v":       1,
+			"type":    "send",
+			"payload": map[string]string{"content": text},
+		})
 		if err != nil {
 			log.Printf("Error sending message: %v", err)
 			cancel()
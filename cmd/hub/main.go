@@ -0,0 +1,16 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/fyerfyer/chatroom/pkg/hub"
+)
+
+func main() {
+	addr := flag.String("addr", ":9000", "address the hub listens on")
+	flag.Parse()
+
+	log.Printf("hub listening on %s", *addr)
+	log.Fatal(hub.NewServer().ListenAndServe(*addr))
+}
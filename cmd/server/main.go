@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os/signal"
+	"syscall"
 
+	"github.com/fyerfyer/chatroom/models"
 	"github.com/fyerfyer/chatroom/pkg/setting"
 	"github.com/fyerfyer/chatroom/routers"
 
@@ -19,7 +24,29 @@ func main() {
 		Handler: routers.InitRouter(),
 	}
 
-	log.Printf("serving on port: %v", setting.HTTPPort)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	log.Fatal(srv.ListenAndServe())
+	go func() {
+		log.Printf("serving on port: %v", setting.HTTPPort)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	log.Printf("shutting down, waiting up to %v", setting.ShutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), setting.ShutdownTimeout)
+	defer cancel()
+
+	// the chat connections are hijacked, the http server does not see them
+	if err := models.Broadcaster.Shutdown(shutdownCtx); err != nil {
+		log.Printf("broadcaster shutdown: %v", err)
+	}
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("http server shutdown: %v", err)
+	}
+	routers.Close(shutdownCtx)
 }
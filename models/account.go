@@ -0,0 +1,191 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fyerfyer/chatroom/pkg/auth"
+	"github.com/fyerfyer/chatroom/pkg/setting"
+	"github.com/fyerfyer/chatroom/pkg/utils"
+)
+
+const minPasswordLength = 6
+
+var (
+	ErrAccountExists    = errors.New("account already exists")
+	ErrWrongPassword    = errors.New("wrong name or password")
+	ErrPasswordTooShort = errors.New("password should have at least 6 characters")
+	ErrAccountNotExists = errors.New("account does not exist")
+)
+
+type Account struct {
+	Name         string    `json:"name"`
+	PasswordHash string    `json:"password_hash"`
+	Role         string    `json:"role,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// accountStore keeps the accounts in memory and,
+// once loaded from a file, writes every change back to it
+type accountStore struct {
+	mu       sync.Mutex
+	path     string
+	accounts map[string]*Account
+}
+
+var Accounts = &accountStore{
+	accounts: make(map[string]*Account),
+}
+
+// Load reads the accounts from the json file at path,
+// a missing file is created on the first registration
+func (s *accountStore) Load(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.path = path
+	s.accounts = make(map[string]*Account)
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var accounts []*Account
+	if err := json.Unmarshal(data, &accounts); err != nil {
+		return err
+	}
+
+	for _, account := range accounts {
+		s.accounts[account.Name] = account
+	}
+	return nil
+}
+
+func (s *accountStore) Register(name, password string) (*Account, error) {
+	if len(password) < minPasswordLength {
+		return nil, ErrPasswordTooShort
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.accounts[name]; ok {
+		return nil, ErrAccountExists
+	}
+
+	account := &Account{
+		Name:         name,
+		PasswordHash: hash,
+		CreatedAt:    time.Now(),
+	}
+	s.accounts[name] = account
+
+	if err := s.save(); err != nil {
+		delete(s.accounts, name)
+		return nil, err
+	}
+	return account, nil
+}
+
+// dummyHash is checked for the names without an account,
+// so they take as long to refuse as a wrong password
+var dummyHash = sync.OnceValue(func() string {
+	hash, _ := auth.HashPassword("no account has this password")
+	return hash
+})
+
+// Authenticate checks the password of the account
+func (s *accountStore) Authenticate(name, password string) (*Account, error) {
+	s.mu.Lock()
+	account, ok := s.accounts[name]
+	s.mu.Unlock()
+
+	if !ok {
+		auth.CheckPassword(dummyHash(), password)
+		return nil, ErrWrongPassword
+	}
+
+	if err := auth.CheckPassword(account.PasswordHash, password); err != nil {
+		return nil, ErrWrongPassword
+	}
+	return account, nil
+}
+
+func (s *accountStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	accounts := make([]*Account, 0, len(s.accounts))
+	for _, account := range s.accounts {
+		accounts = append(accounts, account)
+	}
+
+	data, err := json.MarshalIndent(accounts, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return utils.WriteFileAtomic(s.path, data, 0600)
+}
+
+// Exists reports whether an account has the name
+func (s *accountStore) Exists(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.accounts[name]
+	return ok
+}
+
+// Role returns the role of the named user, the admins from the config
+// are admins whatever their account says
+func (s *accountStore) Role(name string) string {
+	for _, admin := range setting.Admins {
+		if admin == name {
+			return RoleAdmin
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if account, ok := s.accounts[name]; ok && validRole(account.Role) {
+		return account.Role
+	}
+	return RoleMember
+}
+
+func (s *accountStore) SetRole(name, role string) error {
+	if !validRole(role) {
+		return ErrInvalidRole
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, ok := s.accounts[name]
+	if !ok {
+		return ErrAccountNotExists
+	}
+
+	old := account.Role
+	account.Role = role
+	if err := s.save(); err != nil {
+		account.Role = old
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+package models
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fyerfyer/chatroom/pkg/blob"
+	"github.com/fyerfyer/chatroom/pkg/setting"
+	"github.com/fyerfyer/chatroom/pkg/utils"
+)
+
+// the bytes the type of an upload is sniffed from
+const sniffLength = 512
+
+// the longest file name kept for an upload
+const maxAttachmentName = 255
+
+var (
+	ErrAttachmentNotFound = errors.New("attachment not found")
+	ErrAttachmentTooLarge = errors.New("attachment too large")
+	ErrAttachmentType     = errors.New("attachment type not allowed")
+	ErrAttachmentUsed     = errors.New("attachment already sent")
+)
+
+// Attachment describes an uploaded file sent with a message
+type Attachment struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	Type string `json:"type"`
+	// Checksum is the hex SHA-256 of the content
+	Checksum string `json:"checksum"`
+	URL      string `json:"url"`
+}
+
+// attachmentRecord remembers who uploaded a file and the message it was sent with,
+// the message decides who may download it
+type attachmentRecord struct {
+	Attachment
+	Owner     string    `json:"owner"`
+	CreatedAt time.Time `json:"created_at"`
+
+	Message string `json:"message,omitempty"`
+	Room    string `json:"room,omitempty"`
+	To      string `json:"to,omitempty"`
+}
+
+// attachmentStore keeps the uploads in a blob store
+// and their records in a json file written on every change
+type attachmentStore struct {
+	mu      sync.Mutex
+	blobs   blob.Store
+	path    string
+	records map[string]*attachmentRecord
+}
+
+var Attachments = &attachmentStore{
+	records: make(map[string]*attachmentRecord),
+}
+
+// Load keeps the uploads in blobs and reads their records from the json file at path
+func (s *attachmentStore) Load(blobs blob.Store, path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.blobs = blobs
+	s.path = path
+	s.records = make(map[string]*attachmentRecord)
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var records []*attachmentRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		s.records[record.ID] = record
+	}
+	return nil
+}
+
+// allowedType reports whether uploads of the sniffed type are accepted
+func allowedType(contentType string) bool {
+	if len(setting.AttachmentTypes) == 0 {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && slices.Contains(setting.AttachmentTypes, mediaType)
+}
+
+// Upload stores the content for the owner, to be sent with one of its messages.
+// The type is sniffed from the content, whatever the client says it is.
+func (s *attachmentStore) Upload(owner, name string, r io.Reader) (*Attachment, error) {
+	br := bufio.NewReaderSize(r, sniffLength)
+	head, err := br.Peek(sniffLength)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	contentType := http.DetectContentType(head)
+	if !allowedType(contentType) {
+		return nil, ErrAttachmentType
+	}
+
+	name = filepath.Base(filepath.Clean("/" + name))
+	if name == "/" {
+		name = "file"
+	}
+	if len(name) > maxAttachmentName {
+		name = name[len(name)-maxAttachmentName:]
+	}
+
+	id := newID()
+	hash := sha256.New()
+	limited := io.LimitReader(io.TeeReader(br, hash), setting.MaxAttachmentSize+1)
+	size, err := s.blobs.Put(id, limited)
+	if err != nil {
+		return nil, err
+	}
+	if size > setting.MaxAttachmentSize {
+		s.blobs.Delete(id)
+		return nil, ErrAttachmentTooLarge
+	}
+
+	record := &attachmentRecord{
+		Attachment: Attachment{
+			ID:       id,
+			Name:     name,
+			Size:     size,
+			Type:     contentType,
+			Checksum: hex.EncodeToString(hash.Sum(nil)),
+			URL:      "/attachments/" + id,
+		},
+		Owner:     owner,
+		CreatedAt: time.Now(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records[id] = record
+	if err := s.save(); err != nil {
+		delete(s.records, id)
+		s.blobs.Delete(id)
+		return nil, err
+	}
+
+	attachment := record.Attachment
+	return &attachment, nil
+}
+
+// claim fills in the attachments of a message from their uploads, every upload
+// must belong to the account of the sender and is sent once, with the first
+// message naming it. A private message gives them to the account to.
+func (s *attachmentStore) claim(msg *Message, to string) error {
+	if len(msg.Attachments) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := make([]*attachmentRecord, 0, len(msg.Attachments))
+	for _, attachment := range msg.Attachments {
+		record, ok := s.records[attachment.ID]
+		if !ok || record.Owner != msg.User.account() {
+			return ErrAttachmentNotFound
+		}
+		if record.Message != "" || slices.Contains(records, record) {
+			return ErrAttachmentUsed
+		}
+		records = append(records, record)
+	}
+
+	attachments := make([]Attachment, len(records))
+	for i, record := range records {
+		record.Message = msg.ID
+		record.Room = msg.Room
+		record.To = to
+		attachments[i] = record.Attachment
+	}
+	msg.Attachments = attachments
+
+	if err := s.save(); err != nil {
+		for _, record := range records {
+			record.Message, record.Room, record.To = "", "", ""
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *attachmentStore) get(id string) (attachmentRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[id]
+	if !ok {
+		return attachmentRecord{}, false
+	}
+	return *record, true
+}
+
+// Open returns the attachment for the account if its user could see the
+// message it was sent with, an upload not sent yet is only seen by its owner
+func (s *attachmentStore) Open(account, id string) (*Attachment, io.ReadSeekCloser, error) {
+	record, ok := s.get(id)
+	if !ok || !Broadcaster.canSeeAttachment(account, &record) {
+		return nil, nil, ErrAttachmentNotFound
+	}
+
+	f, err := s.blobs.Open(id)
+	if errors.Is(err, blob.ErrNotFound) {
+		return nil, nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return &record.Attachment, f, nil
+}
+
+// canSeeAttachment reports whether the user of the account got, or would get,
+// the message of the attachment
+func (b *broadcast) canSeeAttachment(account string, record *attachmentRecord) bool {
+	switch {
+	case record.Owner == account:
+		return true
+	case record.Message == "":
+		return false
+	case record.To != "":
+		return record.To == account
+	}
+
+	if msg, err := b.store.Get(record.Message); err == nil && msg.Deleted {
+		return false
+	}
+	return b.InRoom(account, record.Room)
+}
+
+func (s *attachmentStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	records := make([]*attachmentRecord, 0, len(s.records))
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return utils.WriteFileAtomic(s.path, data, 0600)
+}
+
+// withAttachments names the uploads a message is sent with, the broadcaster
+// fills in the rest once it has checked they are the sender's
+func withAttachments(msg *Message, ids []string) *Message {
+	for _, id := range ids {
+		msg.Attachments = append(msg.Attachments, Attachment{ID: id})
+	}
+	return msg
+}
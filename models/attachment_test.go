@@ -0,0 +1,174 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fyerfyer/chatroom/pkg/blob"
+	"github.com/fyerfyer/chatroom/pkg/setting"
+)
+
+const testingPNG = "\x89PNG\r\n\x1a\nnot really an image"
+
+func loadAttachmentsForTesting(t *testing.T) {
+	dir := t.TempDir()
+	blobs, err := blob.NewDisk(filepath.Join(dir, "blobs"))
+	if err != nil {
+		t.Fatalf("failed to open blob storage: %v", err)
+	}
+	if err := Attachments.Load(blobs, filepath.Join(dir, "attachments.json")); err != nil {
+		t.Fatalf("failed to load attachments: %v", err)
+	}
+}
+
+func TestUpload(t *testing.T) {
+	loadAttachmentsForTesting(t)
+
+	attachment, err := Attachments.Upload("alice", "../../cat.png", strings.NewReader(testingPNG))
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	sum := sha256.Sum256([]byte(testingPNG))
+	if attachment.Type != "image/png" || attachment.Name != "cat.png" ||
+		attachment.Size != int64(len(testingPNG)) || attachment.Checksum != hex.EncodeToString(sum[:]) {
+		t.Errorf("wrong attachment metadata: %+v", attachment)
+	}
+
+	// the type comes from the content, not from the name
+	if _, err := Attachments.Upload("alice", "cat.png", strings.NewReader("MZ\x90\x00\x03\x00\x00\x00")); err != ErrAttachmentType {
+		t.Errorf("wanted %v for a program, but got %v", ErrAttachmentType, err)
+	}
+
+	defer func(size int64) { setting.MaxAttachmentSize = size }(setting.MaxAttachmentSize)
+	setting.MaxAttachmentSize = 8
+	if _, err := Attachments.Upload("alice", "big.txt", strings.NewReader("far too long")); err != ErrAttachmentTooLarge {
+		t.Errorf("wanted %v for a big file, but got %v", ErrAttachmentTooLarge, err)
+	}
+
+	// the records survive a restart
+	path := Attachments.path
+	Attachments.Load(Attachments.blobs, path)
+	if _, ok := Attachments.get(attachment.ID); !ok {
+		t.Error("the upload should have been loaded back")
+	}
+}
+
+func TestAttachmentAccess(t *testing.T) {
+	defer clearUserListForTesting()
+	defer ClearUserMsgProcessorForTesting()
+	ClearUserMsgProcessorForTesting()
+	loadAttachmentsForTesting(t)
+
+	alice := &User{ID: 8001, Name: "testing_alice", Room: "files_room", MessageChannel: make(chan *Message, 32)}
+	bob := &User{ID: 8002, Name: "testing_bob", Room: "files_room", MessageChannel: make(chan *Message, 32)}
+	carol := &User{ID: 8003, Name: "testing_carol", Room: "other_room", MessageChannel: make(chan *Message, 32)}
+	for _, user := range []*User{alice, bob, carol} {
+		Broadcaster.UserLogin(user)
+	}
+
+	canOpen := func(name, id string) bool {
+		_, f, err := Attachments.Open(name, id)
+		if err != nil {
+			if !errors.Is(err, ErrAttachmentNotFound) {
+				t.Fatalf("failed to open the attachment: %v", err)
+			}
+			return false
+		}
+		defer f.Close()
+		data, _ := io.ReadAll(f)
+		return string(data) == testingPNG
+	}
+
+	upload, _ := Attachments.Upload(alice.Name, "cat.png", strings.NewReader(testingPNG))
+	if !canOpen(alice.Name, upload.ID) || canOpen(bob.Name, upload.ID) {
+		t.Error("an upload not sent yet should only be seen by its owner")
+	}
+
+	// bob cannot send what alice uploaded
+	bob.Say("", "mine now", upload.ID)
+	time.Sleep(50 * time.Millisecond)
+	if got := lastOfType(drainMessages(bob), MsgTypeError); got != ErrAttachmentNotFound.Error() {
+		t.Errorf("wanted %q for someone else's upload, but got %q", ErrAttachmentNotFound, got)
+	}
+
+	msg := alice.Say("", "look", upload.ID)
+	time.Sleep(50 * time.Millisecond)
+	var got *Message
+	for _, m := range drainMessages(bob) {
+		if m.ID == msg.ID {
+			got = m
+		}
+	}
+	if got == nil || len(got.Attachments) != 1 || got.Attachments[0].Type != "image/png" ||
+		got.Attachments[0].URL != "/attachments/"+upload.ID {
+		t.Fatalf("bob should have got the message with the attachment, but got %+v", got)
+	}
+	if !canOpen(bob.Name, upload.ID) || canOpen(carol.Name, upload.ID) {
+		t.Error("only the users of the room should open the attachment")
+	}
+
+	alice.Say("", "again", upload.ID)
+	time.Sleep(50 * time.Millisecond)
+	if got := lastOfType(drainMessages(alice), MsgTypeError); got != ErrAttachmentUsed.Error() {
+		t.Errorf("wanted %q for an upload sent twice, but got %q", ErrAttachmentUsed, got)
+	}
+
+	private, _ := Attachments.Upload(alice.Name, "secret.png", strings.NewReader(testingPNG))
+	alice.Whisper(carol.Name, "", private.ID)
+	time.Sleep(50 * time.Millisecond)
+	if !canOpen(carol.Name, private.ID) || canOpen(bob.Name, private.ID) {
+		t.Error("only the recipient of a private message should open its attachment")
+	}
+
+	if err := Broadcaster.DeleteMessage(alice, msg.ID); err != nil {
+		t.Fatalf("failed to delete the message: %v", err)
+	}
+	if canOpen(bob.Name, upload.ID) || !canOpen(alice.Name, upload.ID) {
+		t.Error("the attachment of a deleted message should only be seen by its owner")
+	}
+}
+
+func TestAttachmentAfterRename(t *testing.T) {
+	defer clearUserListForTesting()
+	defer ClearUserMsgProcessorForTesting()
+	ClearUserMsgProcessorForTesting()
+	loadAttachmentsForTesting(t)
+
+	// the tokens name the accounts, the users may go by other names
+	alice := &User{ID: 8004, Name: "testing_alice", Account: "testing_alice", Room: "files_room", MessageChannel: make(chan *Message, 32)}
+	bob := &User{ID: 8005, Name: "testing_bob", Account: "testing_bob", Room: "files_room", MessageChannel: make(chan *Message, 32)}
+	Broadcaster.UserLogin(alice)
+	Broadcaster.UserLogin(bob)
+	if err := Broadcaster.Rename(alice, "testing_ally"); err != nil {
+		t.Fatalf("failed to rename: %v", err)
+	}
+	if err := Broadcaster.Rename(bob, "testing_bobby"); err != nil {
+		t.Fatalf("failed to rename: %v", err)
+	}
+
+	upload, _ := Attachments.Upload(alice.Account, "cat.png", strings.NewReader(testingPNG))
+	private, _ := Attachments.Upload(alice.Account, "secret.png", strings.NewReader(testingPNG))
+	alice.Say("", "look", upload.ID)
+	alice.Whisper(bob.Name, "", private.ID)
+	time.Sleep(50 * time.Millisecond)
+	if got := lastOfType(drainMessages(alice), MsgTypeError); got != "" {
+		t.Fatalf("alice should send her own uploads under any name, but got %q", got)
+	}
+
+	for _, id := range []string{upload.ID, private.ID} {
+		if _, f, err := Attachments.Open(bob.Account, id); err != nil {
+			t.Errorf("bob should open %v by his account, but got %v", id, err)
+		} else {
+			f.Close()
+		}
+		if _, _, err := Attachments.Open(bob.Name, id); err == nil {
+			t.Errorf("the name bob goes by is no account and should not open %v", id)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package models
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/fyerfyer/chatroom/pkg/hub"
+)
+
+// the events the nodes of a cluster exchange
+const (
+	EventMessage  = "message"
+	EventLogin    = "login"
+	EventLogout   = "logout"
+	EventHello    = "hello"
+	EventNodeGone = hub.TypeNodeGone
+	EventKick     = "kick"
+	EventMute     = "mute"
+	EventUnmute   = "unmute"
+	EventPresence = "presence"
+
+	// EventEdit, EventDelete, EventReact and EventUnreact only go to the listeners,
+	// the nodes get the messages of the changes instead
+	EventEdit    = "edit"
+	EventDelete  = "delete"
+	EventReact   = "react"
+	EventUnreact = "unreact"
+
+	// EventBan only goes to the listeners, the bans are not shared by the nodes
+	EventBan = "ban"
+
+	// EventRead only goes to the listeners, the read markers are kept by each node
+	EventRead = "read"
+
+	// EventFlag only goes to the listeners, a filter let the message through but flagged it
+	EventFlag = "flag"
+
+	// EventConnected is never sent to other nodes, the backplane hands it to its own
+	// node whenever it (re)connects so the node announces itself and its users
+	EventConnected = "connected"
+)
+
+// how many events wait for the broadcaster before new ones are dropped
+const backplaneQueueLength = 1024
+
+type Event struct {
+	Type     string   `json:"type"`
+	Node     string   `json:"node"`
+	User     string   `json:"user,omitempty"`
+	Account  string   `json:"account,omitempty"`
+	Room     string   `json:"room,omitempty"`
+	Bot      bool     `json:"bot,omitempty"`
+	Presence string   `json:"presence,omitempty"`
+	Message  *Message `json:"message,omitempty"`
+
+	// moderation, a kick names either a user or an address
+	Addr   string    `json:"address,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+	Until  time.Time `json:"until,omitempty"`
+}
+
+// Backplane fans the messages and presence changes of a node out to the other nodes.
+// Publish must never block the broadcaster.
+type Backplane interface {
+	Publish(event *Event) error
+	Events() <-chan *Event
+	Close() error
+}
+
+// LocalHub connects the broadcasters of a single process,
+// every backplane joined to it sees the events of the others
+type LocalHub struct {
+	mu    sync.Mutex
+	nodes map[*localBackplane]struct{}
+}
+
+type localBackplane struct {
+	hub    *LocalHub
+	node   string
+	events chan *Event
+	once   sync.Once
+}
+
+func NewLocalHub() *LocalHub {
+	return &LocalHub{
+		nodes: make(map[*localBackplane]struct{}),
+	}
+}
+
+// Join returns a new backplane attached to the hub
+func (h *LocalHub) Join() Backplane {
+	bp := &localBackplane{
+		hub:    h,
+		events: make(chan *Event, backplaneQueueLength),
+	}
+	bp.events <- &Event{Type: EventConnected}
+
+	h.mu.Lock()
+	h.nodes[bp] = struct{}{}
+	h.mu.Unlock()
+	return bp
+}
+
+// relay hands every other node a copy of the event and its message,
+// like the ones decoded from the wire, as the nodes change them
+func (h *LocalHub) relay(from *localBackplane, event *Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for bp := range h.nodes {
+		if bp == from {
+			continue
+		}
+
+		e := *event
+		if e.Message != nil {
+			msg := *e.Message
+			e.Message = &msg
+		}
+		select {
+		case bp.events <- &e:
+		default:
+			log.Printf("backplane: dropping event for slow node %s", bp.node)
+		}
+	}
+}
+
+func (bp *localBackplane) Publish(event *Event) error {
+	bp.node = event.Node
+	bp.hub.relay(bp, event)
+	return nil
+}
+
+func (bp *localBackplane) Events() <-chan *Event {
+	return bp.events
+}
+
+// Close detaches the backplane, the other nodes forget its users
+func (bp *localBackplane) Close() error {
+	bp.once.Do(func() {
+		bp.hub.mu.Lock()
+		delete(bp.hub.nodes, bp)
+		bp.hub.mu.Unlock()
+
+		if bp.node != "" {
+			bp.hub.relay(bp, &Event{Type: EventNodeGone, Node: bp.node})
+		}
+		close(bp.events)
+	})
+	return nil
+}
@@ -0,0 +1,147 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// startNodeForTesting runs a broadcaster of its own attached to the backplane
+func startNodeForTesting(t *testing.T, backplane Backplane, node string) *broadcast {
+	b := newBroadcast()
+	b.SetStore(newUserMessageProcessor())
+	b.SetBackplane(backplane, node)
+	go b.Start()
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		b.Shutdown(ctx)
+	})
+	return b
+}
+
+func waitForTesting(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func checkClusterForTesting(t *testing.T, nodeA, nodeB *broadcast) {
+	alice := &User{ID: 1, Name: "testing_alice", MessageChannel: make(chan *Message, 32)}
+	bob := &User{ID: 1, Name: "testing_bob", MessageChannel: make(chan *Message, 32)}
+	nodeA.UserLogin(alice)
+	nodeB.UserLogin(bob)
+
+	waitForTesting(t, "users of both nodes", func() bool {
+		return len(nodeA.GetUserList()) == 2 && len(nodeB.GetUserList()) == 2
+	})
+
+	if nodeB.CheckUserCanLogin(alice.Name) {
+		t.Errorf("%v is logged in on another node and should not log in again", alice.Name)
+	}
+
+	drainMessages(bob)
+	nodeA.Broadcast(NewMessage(alice, MsgTypeNormal, "hello from a"))
+	nodeA.Broadcast(NewPrivateMsg(alice, bob.Name, "psst"))
+
+	var got []*Message
+	waitForTesting(t, "messages from the other node", func() bool {
+		got = append(got, drainMessages(bob)...)
+		return len(got) >= 2
+	})
+	if got[0].Content != "hello from a" || got[1].Content != "psst" {
+		t.Errorf("%v should have received the messages of %v, but got %v",
+			bob.Name, alice.Name, contents(got))
+	}
+
+	// alice and bob have the same ID on their own nodes
+	for _, msg := range drainMessages(alice) {
+		if msg.Type == MsgTypeNormal && msg.User.Name == bob.Name {
+			t.Errorf("%v should not get messages %v sent to someone else", alice.Name, bob.Name)
+		}
+	}
+
+	nodeA.UserLogout(alice)
+	waitForTesting(t, "the logout on the other node", func() bool {
+		return nodeB.CheckUserCanLogin(alice.Name)
+	})
+}
+
+func TestLocalBackplane(t *testing.T) {
+	hub := NewLocalHub()
+	nodeA := startNodeForTesting(t, hub.Join(), "a")
+	nodeB := startNodeForTesting(t, hub.Join(), "b")
+
+	checkClusterForTesting(t, nodeA, nodeB)
+}
+
+func TestNodeGone(t *testing.T) {
+	hub := NewLocalHub()
+	nodeA := startNodeForTesting(t, hub.Join(), "a")
+	nodeB := startNodeForTesting(t, hub.Join(), "b")
+
+	alice := &User{ID: 1, Name: "testing_alice", MessageChannel: make(chan *Message, 32)}
+	nodeA.UserLogin(alice)
+	waitForTesting(t, "the login on the other node", func() bool {
+		return !nodeB.CheckUserCanLogin(alice.Name)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	nodeA.Shutdown(ctx)
+
+	waitForTesting(t, "the node to be forgotten", func() bool {
+		return nodeB.CheckUserCanLogin(alice.Name)
+	})
+}
+
+// the nodes share the process, run it with -race as well
+func TestRemoteInboxKeptOnce(t *testing.T) {
+	hub := NewLocalHub()
+	nodeA := startNodeForTesting(t, hub.Join(), "a")
+	nodeB := startNodeForTesting(t, hub.Join(), "b")
+	nodeC := startNodeForTesting(t, hub.Join(), "c")
+
+	alice := &User{ID: 1, Name: "testing_alice", MessageChannel: make(chan *Message, 32)}
+	bob := &User{ID: 1, Name: "testing_bob", MessageChannel: make(chan *Message, 32)}
+	nodeA.UserLogin(alice)
+	nodeB.UserLogin(bob)
+	waitForTesting(t, "the login on the other nodes", func() bool {
+		return !nodeA.CheckUserCanLogin(bob.Name) && !nodeC.CheckUserCanLogin(bob.Name)
+	})
+
+	// bob lost the connection to b and may come back there
+	nodeB.UserDetach(bob)
+	nodeA.Broadcast(NewPrivateMsg(alice, bob.Name, "psst"))
+
+	// the other nodes store a room message under their own sequence numbers
+	for _, node := range []*broadcast{nodeB, nodeC} {
+		node.store.Append(NewMessage(bob, MsgTypeNormal, "earlier"))
+	}
+	said := NewMessage(alice, MsgTypeNormal, "hello")
+	said.Room = roomName(alice.Room)
+	nodeA.Broadcast(said)
+
+	var kept []*Message
+	waitForTesting(t, "the message kept on b", func() bool {
+		msgs, _ := nodeB.store.PopInbox(bob.Name)
+		kept = append(kept, msgs...)
+		return len(kept) > 0
+	})
+	time.Sleep(50 * time.Millisecond)
+	if msgs, _ := nodeC.store.PopInbox(bob.Name); len(msgs) != 0 {
+		t.Errorf("only the node bob was on should keep the message, but c has %v", contents(msgs))
+	}
+
+	stored, err := nodeA.store.Get(said.ID)
+	if err != nil || stored.Seq != 1 {
+		t.Errorf("the other nodes should not change the message stored on a, but got %+v %v", stored, err)
+	}
+}
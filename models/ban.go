@@ -0,0 +1,184 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fyerfyer/chatroom/pkg/utils"
+)
+
+var (
+	ErrBanNotFound    = errors.New("ban does not exist")
+	ErrInvalidBan     = errors.New("a ban needs either a name or an address")
+	ErrInvalidAddress = errors.New("invalid ip address")
+	ErrBannedName     = errors.New("the name is banned")
+	ErrBannedAddress  = errors.New("the address is banned")
+)
+
+// Ban keeps a name or an IP address out until it expires,
+// a zero ExpiresAt never expires
+type Ban struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name,omitempty"`
+	Addr      string    `json:"address,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	By        string    `json:"by"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+func (ban *Ban) expired(now time.Time) bool {
+	return !ban.ExpiresAt.IsZero() && !now.Before(ban.ExpiresAt)
+}
+
+// banStore keeps the bans in memory and,
+// once loaded from a file, writes every change back to it
+type banStore struct {
+	mu   sync.Mutex
+	path string
+	bans map[string]*Ban
+}
+
+var Bans = &banStore{
+	bans: make(map[string]*Ban),
+}
+
+// Load reads the bans from the json file at path,
+// a missing file is created on the first ban
+func (s *banStore) Load(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.path = path
+	s.bans = make(map[string]*Ban)
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var bans []*Ban
+	if err := json.Unmarshal(data, &bans); err != nil {
+		return err
+	}
+
+	for _, ban := range bans {
+		s.bans[ban.ID] = ban
+	}
+	return nil
+}
+
+// Add bans the name or the address of the ban for d, or forever if d is 0
+func (s *banStore) Add(ban *Ban, d time.Duration) (*Ban, error) {
+	if (ban.Name == "") == (ban.Addr == "") {
+		return nil, ErrInvalidBan
+	}
+	if ban.Addr != "" && net.ParseIP(ban.Addr) == nil {
+		return nil, ErrInvalidAddress
+	}
+
+	ban.ID = newID()
+	ban.CreatedAt = time.Now()
+	if d > 0 {
+		ban.ExpiresAt = ban.CreatedAt.Add(d)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bans[ban.ID] = ban
+	if err := s.save(); err != nil {
+		delete(s.bans, ban.ID)
+		return nil, err
+	}
+	return ban, nil
+}
+
+// List returns the bans in force, the oldest first
+func (s *banStore) List() []*Ban {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	bans := make([]*Ban, 0, len(s.bans))
+	for _, ban := range s.bans {
+		if !ban.expired(now) {
+			bans = append(bans, ban)
+		}
+	}
+
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].CreatedAt.Before(bans[j].CreatedAt)
+	})
+	return bans
+}
+
+func (s *banStore) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ban, ok := s.bans[id]
+	if !ok {
+		return ErrBanNotFound
+	}
+
+	delete(s.bans, id)
+	if err := s.save(); err != nil {
+		s.bans[id] = ban
+		return err
+	}
+	return nil
+}
+
+// Check returns an error if the name or the address is banned
+func (s *banStore) Check(name, addr string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, ban := range s.bans {
+		if ban.expired(now) {
+			continue
+		}
+
+		if ban.Name != "" && ban.Name == name {
+			return ErrBannedName
+		}
+		if ban.Addr != "" && ban.Addr == addr {
+			return ErrBannedAddress
+		}
+	}
+	return nil
+}
+
+// save writes the bans in force, the expired ones are dropped on the way
+func (s *banStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	now := time.Now()
+	bans := make([]*Ban, 0, len(s.bans))
+	for id, ban := range s.bans {
+		if ban.expired(now) {
+			delete(s.bans, id)
+			continue
+		}
+		bans = append(bans, ban)
+	}
+
+	data, err := json.MarshalIndent(bans, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return utils.WriteFileAtomic(s.path, data, 0600)
+}
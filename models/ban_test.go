@@ -0,0 +1,65 @@
+package models
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBans(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bans.json")
+	if err := Bans.Load(path); err != nil {
+		t.Fatalf("failed to load bans: %v", err)
+	}
+	defer Bans.Load("")
+
+	if _, err := Bans.Add(&Ban{Name: "testing_user1", Addr: "10.0.0.1"}, 0); err != ErrInvalidBan {
+		t.Errorf("a ban of both a name and an address should fail, but got %v", err)
+	}
+	if _, err := Bans.Add(&Ban{Addr: "not an ip"}, 0); err != ErrInvalidAddress {
+		t.Errorf("a ban of a bad address should fail, but got %v", err)
+	}
+
+	byName, err := Bans.Add(&Ban{Name: "testing_user1", By: "testing_admin"}, 0)
+	if err != nil {
+		t.Fatalf("failed to ban a name: %v", err)
+	}
+	if _, err := Bans.Add(&Ban{Addr: "10.0.0.1"}, time.Hour); err != nil {
+		t.Fatalf("failed to ban an address: %v", err)
+	}
+	if _, err := Bans.Add(&Ban{Name: "testing_user2"}, time.Millisecond); err != nil {
+		t.Fatalf("failed to ban a name: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	if err := Bans.Check("testing_user1", "10.0.0.2"); err != ErrBannedName {
+		t.Errorf("the name should be banned, but got %v", err)
+	}
+	if err := Bans.Check("testing_user3", "10.0.0.1"); err != ErrBannedAddress {
+		t.Errorf("the address should be banned, but got %v", err)
+	}
+	if err := Bans.Check("testing_user2", "10.0.0.2"); err != nil {
+		t.Errorf("an expired ban should not apply, but got %v", err)
+	}
+	if bans := Bans.List(); len(bans) != 2 {
+		t.Errorf("wanted the 2 bans in force, but got %v", len(bans))
+	}
+
+	// the bans survive a restart
+	if err := Bans.Load(path); err != nil {
+		t.Fatalf("failed to load bans again: %v", err)
+	}
+	if err := Bans.Check("testing_user1", ""); err != ErrBannedName {
+		t.Errorf("the ban should have been kept, but got %v", err)
+	}
+
+	if err := Bans.Revoke(byName.ID); err != nil {
+		t.Fatalf("failed to revoke the ban: %v", err)
+	}
+	if err := Bans.Revoke(byName.ID); err != ErrBanNotFound {
+		t.Errorf("a revoked ban should be gone, but got %v", err)
+	}
+	if err := Bans.Check("testing_user1", ""); err != nil {
+		t.Errorf("a revoked ban should not apply, but got %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package models
+
+import (
+	"log"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"github.com/fyerfyer/chatroom/pkg/setting"
+	"github.com/fyerfyer/chatroom/pkg/utils"
+)
+
+// Bot lives in the chat without a connection. It gets the messages of its rooms
+// and the private messages sent to it, the logins and logouts of the rooms included.
+type Bot interface {
+	Name() string
+
+	// Rooms returns the rooms the bot joins, the first one is its own room
+	Rooms() []string
+
+	// Handle is called for every message but the bot's own ones, one at a time.
+	// The bot talks back through self, like self.Say(msg.Room, "hi").
+	Handle(self *User, msg *Message)
+}
+
+// RegisterBot logs the bot in as a user of its own,
+// logging that user out stops the bot
+func (b *broadcast) RegisterBot(bot Bot) (*User, error) {
+	name := bot.Name()
+	if err := utils.ValidateName(name); err != nil {
+		return nil, err
+	}
+
+	rooms := bot.Rooms()
+	for _, room := range rooms {
+		if err := utils.ValidateRoomName(room); err != nil {
+			return nil, err
+		}
+	}
+
+	if !b.CheckUserCanLogin(name) {
+		return nil, ErrNameTaken
+	}
+
+	user := &User{
+		ID:             int(atomic.AddUint32(&globalUserID, 1)),
+		Name:           name,
+		Account:        name,
+		CreatedAt:      time.Now(),
+		Room:           setting.DefaultRoom,
+		Role:           RoleMember,
+		IsBot:          true,
+		MessageChannel: make(chan *Message, setting.UserMessageQueueLength),
+	}
+	if len(rooms) > 0 {
+		user.Room = rooms[0]
+	}
+
+	go runBot(bot, user)
+
+	b.UserLogin(user)
+	for _, room := range rooms {
+		if room != user.Room {
+			b.JoinRoom(user, room)
+		}
+	}
+
+	log.Printf("bot %s has entered the chatroom", name)
+	return user, nil
+}
+
+func runBot(bot Bot, self *User) {
+	for msg := range self.MessageChannel {
+		if msg.User != nil && msg.User.Name == self.Name {
+			continue
+		}
+
+		handleBotMessage(bot, self, msg)
+	}
+}
+
+// handleBotMessage keeps a panicking bot from taking the server down
+func handleBotMessage(bot Bot, self *User, msg *Message) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("bot %s panicked: %v\n%s", self.Name, r, debug.Stack())
+		}
+	}()
+
+	bot.Handle(self, msg)
+}
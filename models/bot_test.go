@@ -0,0 +1,106 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+// recordingBot answers "ping" with "pong" and keeps what it got
+type recordingBot struct {
+	name  string
+	rooms []string
+	got   chan *Message
+}
+
+func newRecordingBot(name string, rooms ...string) *recordingBot {
+	return &recordingBot{name: name, rooms: rooms, got: make(chan *Message, 32)}
+}
+
+func (r *recordingBot) Name() string { return r.name }
+
+func (r *recordingBot) Rooms() []string { return r.rooms }
+
+func (r *recordingBot) Handle(self *User, msg *Message) {
+	r.got <- msg
+	switch msg.Content {
+	case "ping":
+		self.Say(msg.Room, "pong")
+	case "panic":
+		panic("bad bot")
+	}
+}
+
+// waitBotMessage waits for the bot to get a message of the given type
+func (r *recordingBot) waitBotMessage(msgType int) *Message {
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case msg := <-r.got:
+			if msg.Type == msgType {
+				return msg
+			}
+		case <-timeout:
+			return nil
+		}
+	}
+}
+
+func TestRegisterBot(t *testing.T) {
+	defer clearUserListForTesting()
+
+	bot := newRecordingBot("testing_bot", "bot_room")
+	self, err := Broadcaster.RegisterBot(bot)
+	if err != nil {
+		t.Fatalf("failed to register the bot: %v", err)
+	}
+
+	for _, user := range Broadcaster.GetUserList() {
+		if user.Name == bot.Name() && !user.IsBot {
+			t.Error("the bot should be flagged in the user list")
+		}
+	}
+	if !hasUser(Broadcaster.GetUserList(), self) {
+		t.Fatal("the bot should be in the user list")
+	}
+
+	if _, err := Broadcaster.RegisterBot(newRecordingBot("testing_bot")); err != ErrNameTaken {
+		t.Errorf("wanted %v for a second bot of the same name, but got %v", ErrNameTaken, err)
+	}
+	if _, err := Broadcaster.RegisterBot(newRecordingBot("")); err == nil {
+		t.Error("a bot without a name should not be registered")
+	}
+
+	user := &User{ID: 2001, Name: "testing_user1", Room: "bot_room", MessageChannel: make(chan *Message, 32)}
+	Broadcaster.UserLogin(user)
+	if msg := bot.waitBotMessage(MsgTypeUserLogin); msg == nil || msg.User.Name != user.Name {
+		t.Errorf("the bot should see %v entering, but got %v", user.Name, msg)
+	}
+
+	user.Say("", "ping")
+	if msg := bot.waitBotMessage(MsgTypeNormal); msg == nil || msg.Content != "ping" {
+		t.Fatalf("the bot should get the message, but got %v", msg)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	var answered bool
+	for _, msg := range drainMessages(user) {
+		if msg.Type == MsgTypeNormal && msg.Content == "pong" && msg.User.Name == bot.Name() {
+			answered = true
+		}
+	}
+	if !answered {
+		t.Error("the bot should have answered in the room")
+	}
+
+	// a panicking bot goes on with the next message
+	user.Say("", "panic")
+	user.Whisper(bot.Name(), "ping")
+	if msg := bot.waitBotMessage(MsgTypePrivate); msg == nil || msg.Content != "ping" {
+		t.Errorf("the bot should survive a panic and get the private message, but got %v", msg)
+	}
+
+	Broadcaster.UserLogout(self)
+	if !Broadcaster.CheckUserCanLogin(bot.Name()) {
+		t.Error("a logged out bot should leave the user list")
+	}
+}
@@ -1,22 +1,74 @@
 package models
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"sort"
+	"sync/atomic"
+	"time"
 
+	"github.com/fyerfyer/chatroom/pkg/search"
 	"github.com/fyerfyer/chatroom/pkg/setting"
+	"github.com/fyerfyer/chatroom/pkg/utils"
+)
+
+var (
+	ErrUserOffline   = errors.New("user is not online")
+	ErrNotInRoom     = errors.New("user is not in the room")
+	ErrRoomNotExists = errors.New("room does not exist")
+	ErrNameTaken     = errors.New("the name is taken")
 )
 
 type broadcast struct {
 	users map[string]*User
+	rooms map[string]*Room
+
+	// sessions of lost connections that can still be resumed
+	detached map[string]*detachedSession
+
 	ops   chan broadcastOp
+	store MessageStore
+	index *search.Index
 
 	messageChannel chan *Message
+	overflow       overflowStats
+
+	// only one loop may own the users and rooms
+	running atomic.Bool
+
+	// listeners are told about the events of this node
+	listeners []func(event *Event)
+
+	// every chat message of a user goes through the filters in order
+	filters []namedFilter
+
+	// the users whose frames are rejected until the time
+	mutes map[string]time.Time
+
+	// the other nodes of the cluster and the users logged in there
+	backplane Backplane
+	node      string
+	remote    map[string]map[string]*User
+
+	// closing is set once the server shuts down, done is closed when the loop ends
+	closing atomic.Bool
+	done    chan struct{}
 }
 
 type broadcastOp struct {
-	typ   string
-	user  *User
-	reply chan interface{}
+	typ      string
+	user     *User
+	room     string
+	addr     string
+	reason   string
+	presence string
+	name     string
+	id       string
+	content  string
+	until    time.Time
+	seq      uint64
+	reply    chan interface{}
 }
 
 const (
@@ -25,94 +77,636 @@ const (
 	OpCheckLogin  = "checklogin"
 	OpCheckLogout = "checklogout"
 	OpGetList     = "getList"
+	OpJoinRoom    = "joinRoom"
+	OpLeaveRoom   = "leaveRoom"
+	OpGetRooms    = "getRooms"
+	OpDetach      = "detach"
+	OpExpire      = "expire"
+	OpCheckResume = "checkResume"
+	OpShutdown    = "shutdown"
+	OpKick        = "kick"
+	OpKickAddr    = "kickAddr"
+	OpMute        = "mute"
+	OpUnmute      = "unmute"
+	OpAccountOf   = "accountOf"
+	OpRename      = "rename"
+	OpSetTopic    = "setTopic"
+	OpSetPresence = "setPresence"
+	OpCheckIdle   = "checkIdle"
+
+	OpEditMessage   = "editMessage"
+	OpDeleteMessage = "deleteMessage"
+	OpReact         = "react"
+	OpUnreact       = "unreact"
+	OpFollow        = "follow"
+	OpUnfollow      = "unfollow"
+	OpCheckRoom     = "checkRoom"
+	OpGetUserRooms  = "getUserRooms"
+	OpMarkRead      = "markRead"
+
+	OpQueueHighWater = "queueHighWater"
 )
 
-var Broadcaster = &broadcast{
-	users:          make(map[string]*User),
-	ops:            make(chan broadcastOp),
-	messageChannel: make(chan *Message, setting.MessageQueueLength),
+var Broadcaster = newBroadcast()
+
+func newBroadcast() *broadcast {
+	return &broadcast{
+		users:          make(map[string]*User),
+		rooms:          make(map[string]*Room),
+		detached:       make(map[string]*detachedSession),
+		remote:         make(map[string]map[string]*User),
+		mutes:          make(map[string]time.Time),
+		ops:            make(chan broadcastOp),
+		store:          UserMessageProcessor,
+		index:          search.New(),
+		messageChannel: make(chan *Message, setting.MessageQueueLength),
+		done:           make(chan struct{}),
+	}
 }
 
 func (b *broadcast) Start() {
+	if !b.running.CompareAndSwap(false, true) {
+		return
+	}
+	defer b.running.Store(false)
+
+	var events <-chan *Event
+	if b.backplane != nil {
+		events = b.backplane.Events()
+	}
+
+	go b.watchIdle()
+
 	for {
 		select {
 		case op := <-b.ops:
 			switch op.typ {
 			case OpLogin:
-				b.users[op.user.Name] = op.user
-				UserMessageProcessor.Send(op.user)
-				b.Broadcast(NewLoginMsg(op.user))
+				if session, ok := b.resumable(op.user); ok {
+					b.resume(op.user, session)
+				} else {
+					b.login(op.user)
+				}
+				op.reply <- nil
 
 			case OpLogout:
-				delete(b.users, op.user.Name)
-				op.user.CloseChannel()
-				op.user.IsOnline = false
-				b.Broadcast(NewLogoutMsg(op.user))
+				b.logout(op.user)
+				op.reply <- nil
+
+			case OpDetach:
+				b.detach(op.user)
+				op.reply <- nil
+
+			case OpExpire:
+				b.expire(op.user)
+				op.reply <- nil
+
+			case OpCheckResume:
+				_, ok := b.resumable(op.user)
+				op.reply <- ok
+
+			case OpKick:
+				if b.kick(op.user.account(), op.reason) {
+					op.reply <- nil
+					break
+				}
+				if _, ok := b.remoteAccount(op.user.account()); !ok {
+					op.reply <- ErrUserOffline
+					break
+				}
+				b.publish(&Event{Type: EventKick, User: op.user.account(), Reason: op.reason})
+				op.reply <- nil
+
+			case OpKickAddr:
+				b.kickAddr(op.addr, op.reason)
+				b.publish(&Event{Type: EventKick, Addr: op.addr, Reason: op.reason})
+				op.reply <- nil
+
+			case OpMute:
+				b.mute(op.user.account(), op.until)
+				b.emit(&Event{Type: EventMute, User: op.user.account(), Until: op.until})
+				b.publish(&Event{Type: EventMute, User: op.user.account(), Until: op.until})
+				op.reply <- nil
+
+			case OpUnmute:
+				b.unmute(op.user.account())
+				b.emit(&Event{Type: EventUnmute, User: op.user.account()})
+				b.publish(&Event{Type: EventUnmute, User: op.user.account()})
+				op.reply <- nil
+
+			case OpQueueHighWater:
+				var highWater int64
+				for _, user := range b.users {
+					highWater = max(highWater, user.queueHighWater.Load())
+				}
+				op.reply <- highWater
+
+			case OpAccountOf:
+				op.reply <- b.accountOf(op.user.Name)
+
+			case OpRename:
+				op.reply <- b.rename(op.user, op.name)
+
+			case OpSetTopic:
+				op.reply <- b.setTopic(op.user, op.room, op.content)
+
+			case OpSetPresence:
+				if _, ok := b.users[op.user.Name]; !ok {
+					op.reply <- ErrUserOffline
+					break
+				}
+				op.user.chosenPresence = op.presence
+				b.updatePresence(op.user, time.Now())
+				op.reply <- nil
+
+			case OpCheckIdle:
+				if op.user == nil {
+					b.checkIdle()
+				} else if _, ok := b.users[op.user.Name]; ok {
+					b.updatePresence(op.user, time.Now())
+				}
+				op.reply <- nil
+
+			case OpEditMessage:
+				op.reply <- b.editMessage(op.user, op.id, op.content)
+
+			case OpDeleteMessage:
+				op.reply <- b.deleteMessage(op.user, op.id)
+
+			case OpReact:
+				op.reply <- b.reactMessage(op.user, op.id, op.content, true)
+
+			case OpUnreact:
+				op.reply <- b.reactMessage(op.user, op.id, op.content, false)
+
+			case OpFollow:
+				op.reply <- b.followThread(op.user, op.id, true)
+
+			case OpUnfollow:
+				op.reply <- b.followThread(op.user, op.id, false)
+
+			case OpShutdown:
+				op.reply <- b.stop()
+				close(b.done)
+				return
 
 			case OpCheckLogin:
 				_, exists := b.users[op.user.Name]
-				op.reply <- !exists
+				op.reply <- !exists && !b.remoteUser(op.user.Name)
+
+			case OpMarkRead:
+				op.reply <- b.markRead(op.user, op.room, op.seq)
+
+			case OpCheckRoom:
+				user, ok := b.userOf(op.user.account())
+				op.reply <- ok && b.inRoom(user.Name, op.room)
+
+			case OpGetUserRooms:
+				var names []string
+				if user, ok := b.userOf(op.user.account()); ok {
+					for _, room := range b.roomsOf(user.Name) {
+						names = append(names, room.Name)
+					}
+				}
+				op.reply <- names
 
 			case OpCheckLogout:
 				_, exists := b.users[op.user.Name]
 				op.reply <- exists
 
 			case OpGetList:
+				// the invisible users are left out, the others are copied with their presence
 				usersList := make([]*User, 0, len(b.users))
 				for _, user := range b.users {
-					usersList = append(usersList, user)
+					if user.Presence != PresenceInvisible {
+						usersList = append(usersList, user.withPresence())
+					}
+				}
+				for _, users := range b.remote {
+					for _, user := range users {
+						if user.Presence != PresenceOffline {
+							usersList = append(usersList, user.withPresence())
+						}
+					}
 				}
 				op.reply <- usersList
+
+			case OpJoinRoom:
+				if _, ok := b.users[op.user.Name]; !ok {
+					op.reply <- ErrUserOffline
+					break
+				}
+				b.joinRoom(op.user, op.room)
+				op.reply <- nil
+
+			case OpLeaveRoom:
+				room, ok := b.rooms[op.room]
+				if !ok {
+					op.reply <- ErrRoomNotExists
+					break
+				}
+				if _, ok := room.members[op.user.Name]; !ok {
+					op.reply <- ErrNotInRoom
+					break
+				}
+				b.leaveRoom(op.user, room)
+				op.reply <- nil
+
+			case OpGetRooms:
+				roomList := make([]*Room, 0, len(b.rooms))
+				for _, room := range b.rooms {
+					roomList = append(roomList, room.snapshot())
+				}
+				sort.Slice(roomList, func(i, j int) bool {
+					return roomList[i].Name < roomList[j].Name
+				})
+				op.reply <- roomList
 			}
 
 		case msg := <-b.messageChannel:
-			for _, user := range b.users {
-				// log.Println(user.Name)
-				if user.ID == msg.User.ID && msg.Type != MsgTypeNormal {
-					continue
-				}
-				// log.Println("sending msg to user channel!")
-				// log.Printf("msg to channel:%v", msg)
-				user.MessageChannel <- msg
+			b.dispatch(msg)
+
+		case event, ok := <-events:
+			if !ok {
+				events = nil
+				break
 			}
-			UserMessageProcessor.Save(msg)
+			b.handleEvent(event)
+		}
+	}
+}
+
+// dispatch persists the message and hands it to its recipients
+func (b *broadcast) dispatch(msg *Message) {
+	messagesBroadcast.Inc(msgTypeName(msg.Type))
+
+	if filtered(msg) {
+		if err := b.filter(msg); err != nil {
+			b.reply(msg.User, NewErrorMsg(err.Error()))
+			return
+		}
+	}
+
+	if msg.To != "" {
+		b.sendTo(msg)
+		return
+	}
+
+	// a reply goes to the room of its thread
+	if msg.Type == MsgTypeNormal && msg.ParentID != "" {
+		root, err := b.threadRoot(msg.ParentID)
+		if err != nil {
+			b.reply(msg.User, NewErrorMsg(err.Error()))
+			return
+		}
+		msg.ParentID = root.ID
+		msg.Room = root.Room
+	}
+
+	// a chat message can only be sent to a room the sender is in
+	if (msg.Type == MsgTypeNormal || msg.Type == MsgTypeTyping) && !b.inRoom(msg.User.Name, msg.Room) {
+		b.reply(msg.User, NewErrorMsg(ErrNotInRoom.Error()))
+		return
+	}
+
+	if err := Attachments.claim(msg, ""); err != nil {
+		b.reply(msg.User, NewErrorMsg(err.Error()))
+		return
+	}
+
+	// persist first so the message is delivered with its sequence number
+	if err := saveMessage(b.store, msg); err != nil {
+		log.Printf("failed to save message: %v", err)
+	} else {
+		b.indexMessage(msg)
+	}
+
+	if msg.Type == MsgTypeNormal {
+		b.emit(&Event{Type: EventMessage, Room: msg.Room, Message: msg})
+	}
+
+	for _, user := range b.recipients(msg) {
+		// log.Println(user.Name)
+		if user.ID == msg.User.ID && msg.Type != MsgTypeNormal {
+			continue
 		}
+		// log.Println("sending msg to user channel!")
+		// log.Printf("msg to channel:%v", msg)
+		b.deliver(user, msg)
+	}
+	if msg.ParentID != "" {
+		b.addReply(msg, true)
+	}
+	b.publishMessage(msg)
+}
+
+func (b *broadcast) login(user *User) {
+	b.users[user.Name] = user
+	user.IsOnline = true
+	user.Presence = PresenceOnline
+	b.applyMute(user)
+	onlineUsers.Set(float64(len(b.users)))
+	msgs, err := pendingMessages(b.store, user)
+	if err != nil {
+		log.Printf("failed to replay messages to %s: %v", user.Name, err)
+	}
+	for _, msg := range msgs {
+		b.deliver(user, msg)
+	}
+	if unread, err := UnreadCounts(b.store, user.account(), roomName(user.Room)); err != nil {
+		log.Printf("failed to count unread messages of %s: %v", user.Name, err)
+	} else {
+		b.deliver(user, NewUnreadMsg(unread))
+	}
+	b.joinRoom(user, roomName(user.Room))
+	b.issueResumeToken(user)
+	b.emit(&Event{Type: EventLogin, User: user.Name, Room: user.Room})
+	b.publish(&Event{Type: EventLogin, User: user.Name, Account: user.account(), Room: user.Room, Bot: user.IsBot})
+}
+
+func (b *broadcast) logout(user *User) {
+	// the user may have been kicked already
+	if _, ok := b.users[user.Name]; !ok {
+		return
+	}
+
+	for _, room := range b.roomsOf(user.Name) {
+		b.leaveRoom(user, room)
+	}
+	delete(b.users, user.Name)
+	onlineUsers.Set(float64(len(b.users)))
+	b.emit(&Event{Type: EventLogout, User: user.Name})
+	b.publish(&Event{Type: EventLogout, User: user.Name})
+
+	// a detached session has closed its channel already
+	if !user.detached {
+		user.CloseChannel()
+	}
+	user.IsOnline = false
+	user.Presence = PresenceOffline
+}
+
+// rename moves the user and everything kept under its name to the new name
+func (b *broadcast) rename(user *User, name string) error {
+	if err := utils.ValidateName(name); err != nil {
+		return err
+	}
+	if _, ok := b.users[user.Name]; !ok {
+		return ErrUserOffline
+	}
+	if name == user.Name {
+		return nil
+	}
+
+	// nobody may take the name of someone else, online or registered
+	_, online := b.users[name]
+	_, detached := b.detached[name]
+	if online || detached || b.remoteUser(name) || Accounts.Exists(name) {
+		return ErrNameTaken
+	}
+
+	// whatever the user goes by, it stays the account it logged in with
+	old := user.Name
+	if user.Account == "" {
+		user.Account = old
+	}
+	rooms := b.roomsOf(old)
+	delete(b.users, old)
+	user.Name = name
+	b.users[name] = user
+
+	ThreadFollowers.rename(old, name)
+
+	for _, room := range rooms {
+		delete(room.members, old)
+		room.members[name] = user
+
+		msg := NewSystemMsg(fmt.Sprintf("%s is now known as %s", old, name))
+		msg.Room = room.Name
+		b.Broadcast(msg)
+	}
+
+	b.publish(&Event{Type: EventLogout, User: old})
+	b.publish(&Event{Type: EventLogin, User: name, Account: user.account(), Room: user.Room, Bot: user.IsBot})
+	return nil
+}
+
+// joinRoom creates the room on first join and announces the user to it
+func (b *broadcast) joinRoom(user *User, name string) {
+	room, ok := b.rooms[name]
+	if !ok {
+		room = newRoom(name)
+		b.rooms[name] = room
+	}
+
+	if _, ok := room.members[user.Name]; ok {
+		return
+	}
+	room.members[user.Name] = user
+	b.Broadcast(NewLoginMsg(user, name))
+}
+
+// leaveRoom announces the user's leave and tears the room down once it is empty
+func (b *broadcast) leaveRoom(user *User, room *Room) {
+	delete(room.members, user.Name)
+	if len(room.members) == 0 {
+		delete(b.rooms, room.Name)
+	}
+	b.Broadcast(NewLogoutMsg(user, room.Name))
+}
+
+func (b *broadcast) roomsOf(name string) []*Room {
+	var rooms []*Room
+	for _, room := range b.rooms {
+		if _, ok := room.members[name]; ok {
+			rooms = append(rooms, room)
+		}
+	}
+
+	return rooms
+}
+
+// userOf returns the user of this node logged in with the account, whatever it is called now
+func (b *broadcast) userOf(account string) (*User, bool) {
+	if user, ok := b.users[account]; ok && user.account() == account {
+		return user, true
+	}
+	for _, user := range b.users {
+		if user.account() == account {
+			return user, true
+		}
+	}
+	return nil, false
+}
+
+func (b *broadcast) inRoom(name, roomName string) bool {
+	room, ok := b.rooms[roomName]
+	if !ok {
+		return false
+	}
+	_, ok = room.members[name]
+	return ok
+}
+
+// recipients returns the users a message is delivered to,
+// a message without room goes to everyone online
+func (b *broadcast) recipients(msg *Message) map[string]*User {
+	if msg.Room == "" {
+		return b.users
+	}
+
+	if room, ok := b.rooms[msg.Room]; ok {
+		return room.members
+	}
+	return nil
+}
+
+// sendTo delivers a message addressed to a single user, private messages
+// are echoed back to the sender and kept for an offline recipient
+func (b *broadcast) sendTo(msg *Message) {
+	// the attachments are for the account of the recipient, not the name it goes by now
+	user, online := b.users[msg.To]
+	to := msg.To
+	if online {
+		to = user.account()
+	}
+	if err := Attachments.claim(msg, to); err != nil {
+		b.reply(msg.User, NewErrorMsg(err.Error()))
+		return
+	}
+
+	online = online && !user.detached
+	remote := !online && b.remoteUser(msg.To)
+	if online {
+		b.deliver(user, msg)
+	} else if remote {
+		b.publishMessage(msg)
+	}
+
+	if msg.Type != MsgTypePrivate {
+		return
+	}
+	b.emit(&Event{Type: EventMessage, User: msg.To, Message: msg})
+
+	if !online && !remote {
+		if err := b.store.PushInbox(msg.To, msg); err != nil {
+			log.Printf("failed to keep message for %s: %v", msg.To, err)
+		}
+	}
+	if msg.User.Name != msg.To {
+		b.reply(msg.User, msg)
+	}
+}
+
+// reply sends a message to a single online user
+func (b *broadcast) reply(user *User, msg *Message) {
+	if u, ok := b.users[user.Name]; ok {
+		b.deliver(u, msg)
+	}
+}
+
+// SetStore replaces the message store and empties the search index,
+// it must be called before Start
+func (b *broadcast) SetStore(store MessageStore) {
+	b.store = store
+	b.index = search.New()
+}
+
+// Store returns the message store the broadcaster persists to
+func (b *broadcast) Store() MessageStore {
+	return b.store
+}
+
+// do hands the operation to the broadcaster and waits until it is done,
+// once the broadcaster has stopped it returns nil right away
+func (b *broadcast) do(op broadcastOp) interface{} {
+	op.reply = make(chan interface{}, 1)
+	select {
+	case b.ops <- op:
+		return <-op.reply
+	case <-b.done:
+		return nil
 	}
 }
 
 func (b *broadcast) UserLogin(user *User) {
-	b.ops <- broadcastOp{typ: OpLogin, user: user}
+	b.do(broadcastOp{typ: OpLogin, user: user})
 }
 
 func (b *broadcast) UserLogout(user *User) {
-	b.ops <- broadcastOp{typ: OpLogout, user: user}
+	b.do(broadcastOp{typ: OpLogout, user: user})
 }
 
 // we use channel to ensure concurrent safety
 func (b *broadcast) CheckUserCanLogin(name string) bool {
-	reply := make(chan interface{})
-	b.ops <- broadcastOp{typ: OpCheckLogin, user: &User{Name: name}, reply: reply}
-	boolReply, _ := (<-reply).(bool)
+	boolReply, _ := b.do(broadcastOp{typ: OpCheckLogin, user: &User{Name: name}}).(bool)
 	return boolReply
 }
 
 func (b *broadcast) CheckUserCanLogout(name string) bool {
-	reply := make(chan interface{})
-	b.ops <- broadcastOp{typ: OpCheckLogout, user: &User{Name: name}, reply: reply}
-	boolReply, _ := (<-reply).(bool)
+	boolReply, _ := b.do(broadcastOp{typ: OpCheckLogout, user: &User{Name: name}}).(bool)
 	return boolReply
 }
 
 func (b *broadcast) GetUserList() []*User {
-	reply := make(chan interface{})
-	b.ops <- broadcastOp{typ: OpGetList, reply: reply}
-	usersReply, _ := (<-reply).([]*User)
+	usersReply, _ := b.do(broadcastOp{typ: OpGetList}).([]*User)
 	return usersReply
 }
 
+func (b *broadcast) JoinRoom(user *User, room string) error {
+	err, _ := b.do(broadcastOp{typ: OpJoinRoom, user: user, room: room}).(error)
+	return err
+}
+
+func (b *broadcast) LeaveRoom(user *User, room string) error {
+	err, _ := b.do(broadcastOp{typ: OpLeaveRoom, user: user, room: room}).(error)
+	return err
+}
+
+// Rename gives the online user a new name
+func (b *broadcast) Rename(user *User, name string) error {
+	err, _ := b.do(broadcastOp{typ: OpRename, user: user, name: name}).(error)
+	return err
+}
+
+// SetTopic sets the topic of a room the user is in, an empty topic clears it
+func (b *broadcast) SetTopic(user *User, room, topic string) error {
+	err, _ := b.do(broadcastOp{typ: OpSetTopic, user: user, room: room, content: topic}).(error)
+	return err
+}
+
+// InRoom reports whether the user logged in with the account is in the room
+func (b *broadcast) InRoom(account, room string) bool {
+	boolReply, _ := b.do(broadcastOp{typ: OpCheckRoom, user: &User{Account: account}, room: room}).(bool)
+	return boolReply
+}
+
+// RoomsOf returns the names of the rooms the user logged in with the account is in
+func (b *broadcast) RoomsOf(account string) []string {
+	namesReply, _ := b.do(broadcastOp{typ: OpGetUserRooms, user: &User{Account: account}}).([]string)
+	return namesReply
+}
+
+func (b *broadcast) GetRoomList() []*Room {
+	roomsReply, _ := b.do(broadcastOp{typ: OpGetRooms}).([]*Room)
+	return roomsReply
+}
+
 func (b *broadcast) Broadcast(msg *Message) {
+	// nobody reads the queue once the broadcaster has stopped
+	select {
+	case <-b.done:
+		return
+	default:
+	}
+
+	if msg.Type == MsgTypeNormal {
+		msg.Room = roomName(msg.Room)
+	}
+
 	if len(b.messageChannel) >= setting.MessageQueueLength {
 		log.Println("the broadcast queue has been full")
+		broadcastDropped.Inc()
 	} else {
 		// log.Println("broadcast successfully!")
 		b.messageChannel <- msg
@@ -9,10 +9,19 @@ import (
 
 func clearUserListForTesting() {
 	Broadcaster.users = make(map[string]*User)
+	Broadcaster.rooms = make(map[string]*Room)
+	Broadcaster.detached = make(map[string]*detachedSession)
+	Broadcaster.mutes = make(map[string]time.Time)
 }
 
 func loginUserWithoutSendingMessage(user *User) {
 	Broadcaster.users[user.Name] = user
+
+	name := roomName(user.Room)
+	if _, ok := Broadcaster.rooms[name]; !ok {
+		Broadcaster.rooms[name] = newRoom(name)
+	}
+	Broadcaster.rooms[name].members[user.Name] = user
 }
 
 func init() {
@@ -276,3 +285,60 @@ func TestLogoutConcurrency(t *testing.T) {
 		return
 	}
 }
+
+func TestPrivateMessage(t *testing.T) {
+	defer clearUserListForTesting()
+
+	var users []*User
+	for i := 0; i < 3; i++ {
+		user := &User{
+			ID:             i,
+			Name:           "testing_user" + strconv.Itoa(i),
+			MessageChannel: make(chan *Message, 32),
+		}
+		users = append(users, user)
+		loginUserWithoutSendingMessage(user)
+	}
+
+	Broadcaster.Broadcast(NewPrivateMsg(users[0], users[1].Name, "psst"))
+	time.Sleep(50 * time.Millisecond)
+
+	for i, wanted := range []int{1, 1, 0} {
+		if got := len(users[i].MessageChannel); got != wanted {
+			t.Errorf("user%v should have received %v message, but got %v", i, wanted, got)
+			continue
+		}
+		for len(users[i].MessageChannel) > 0 {
+			if msg := <-users[i].MessageChannel; msg.Type != MsgTypePrivate {
+				t.Errorf("the type of message should be Private, but got %v", msg.Type)
+			}
+		}
+	}
+}
+
+func TestPrivateMessageToOfflineUser(t *testing.T) {
+	defer clearUserListForTesting()
+	defer ClearUserMsgProcessorForTesting()
+
+	sender := &User{ID: 1, Name: "testing_user1", MessageChannel: make(chan *Message, 32)}
+	loginUserWithoutSendingMessage(sender)
+
+	Broadcaster.Broadcast(NewPrivateMsg(sender, "testing_user2", "see you later"))
+	time.Sleep(50 * time.Millisecond)
+
+	if len(sender.MessageChannel) != 1 {
+		t.Error("sender should have received the echo of the private message")
+		return
+	}
+
+	recipient := &User{ID: 2, Name: "testing_user2", MessageChannel: make(chan *Message, 32)}
+	Broadcaster.UserLogin(recipient)
+
+	for len(recipient.MessageChannel) > 0 {
+		msg := <-recipient.MessageChannel
+		if msg.Type == MsgTypePrivate && msg.Content == "see you later" {
+			return
+		}
+	}
+	t.Error("offline recipient should receive the private message on login")
+}
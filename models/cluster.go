@@ -0,0 +1,204 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+)
+
+// SetBackplane connects the broadcaster to the other nodes of the cluster,
+// it must be called before Start. A random node ID is used if node is empty.
+func (b *broadcast) SetBackplane(backplane Backplane, node string) {
+	if node == "" {
+		node = newID()
+	}
+
+	b.backplane = backplane
+	b.node = node
+}
+
+func newID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		log.Fatalf("Failed to generate ID: %v", err)
+	}
+
+	return hex.EncodeToString(buf)
+}
+
+// Node returns the ID the broadcaster uses in the cluster
+func (b *broadcast) Node() string {
+	return b.node
+}
+
+// AddListener has fn called with the message, login, logout and moderation
+// events of this node, it must be called before Start and fn must not block
+func (b *broadcast) AddListener(fn func(event *Event)) {
+	b.listeners = append(b.listeners, fn)
+}
+
+func (b *broadcast) emit(event *Event) {
+	event.Node = b.node
+	for _, fn := range b.listeners {
+		fn(event)
+	}
+}
+
+func (b *broadcast) publish(event *Event) {
+	if b.backplane == nil {
+		return
+	}
+
+	event.Node = b.node
+	if err := b.backplane.Publish(event); err != nil {
+		log.Printf("failed to publish %s event: %v", event.Type, err)
+	}
+}
+
+// publishMessage sends a message of a local user to the other nodes,
+// the messages that only concern this node are kept here
+func (b *broadcast) publishMessage(msg *Message) {
+	switch msg.Type {
+	case MsgTypeNormal, MsgTypeUserLogin, MsgTypeUserLogout, MsgTypePrivate, MsgTypeTyping,
+		MsgTypeEdit, MsgTypeDelete, MsgTypeReact, MsgTypeUnreact:
+		b.publish(&Event{Type: EventMessage, Message: msg})
+	}
+}
+
+// announce tells the other nodes about every user logged in here
+func (b *broadcast) announce() {
+	for _, user := range b.users {
+		b.publish(&Event{Type: EventLogin, User: user.Name, Account: user.account(), Room: user.Room, Bot: user.IsBot,
+			Presence: shownPresence(user.Presence)})
+	}
+}
+
+// presenceOr returns the presence of an event, nodes that do not send one have online users
+func presenceOr(presence string) string {
+	if presence == "" {
+		return PresenceOnline
+	}
+	return presence
+}
+
+// remoteAccount returns the user of another node logged in with the account
+func (b *broadcast) remoteAccount(account string) (*User, bool) {
+	for _, users := range b.remote {
+		for _, user := range users {
+			if user.account() == account {
+				return user, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func (b *broadcast) remoteUser(name string) bool {
+	for _, users := range b.remote {
+		if _, ok := users[name]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (b *broadcast) handleEvent(event *Event) {
+	if event.Node == b.node && event.Type != EventConnected {
+		return
+	}
+
+	switch event.Type {
+	case EventConnected:
+		b.publish(&Event{Type: EventHello})
+		b.announce()
+
+	case EventHello:
+		b.announce()
+
+	case EventLogin:
+		users, ok := b.remote[event.Node]
+		if !ok {
+			users = make(map[string]*User)
+			b.remote[event.Node] = users
+		}
+		users[event.User] = &User{Name: event.User, Account: event.Account, Room: event.Room, IsBot: event.Bot,
+			Presence: presenceOr(event.Presence)}
+
+	case EventLogout:
+		delete(b.remote[event.Node], event.User)
+
+	case EventNodeGone:
+		delete(b.remote, event.Node)
+
+	case EventPresence:
+		user, ok := b.remote[event.Node][event.User]
+		if !ok {
+			break
+		}
+		user.Presence = presenceOr(event.Presence)
+		msg := NewPresenceMsg(user, user.Presence)
+		for _, u := range b.users {
+			b.deliver(u, msg)
+		}
+
+	case EventKick:
+		if event.Addr != "" {
+			b.kickAddr(event.Addr, event.Reason)
+		} else {
+			b.kick(event.User, event.Reason)
+		}
+
+	case EventMute:
+		b.mute(event.User, event.Until)
+
+	case EventUnmute:
+		b.unmute(event.User)
+
+	case EventMessage:
+		if event.Message != nil {
+			b.dispatchRemote(event.Message)
+		}
+	}
+}
+
+// dispatchRemote hands a message sent on another node to the users of this one,
+// it is stored here as well so history and replay cover the whole cluster
+func (b *broadcast) dispatchRemote(msg *Message) {
+	msg.Seq = 0
+
+	if msg.To != "" {
+		user, online := b.users[msg.To]
+		if online && !user.detached {
+			b.deliver(user, msg)
+			return
+		}
+
+		// only the node the recipient was last on keeps the message,
+		// every other node would hand it out again at the next login there
+		if msg.Type == MsgTypePrivate && online {
+			if err := b.store.PushInbox(msg.To, msg); err != nil {
+				log.Printf("failed to keep message for %s: %v", msg.To, err)
+			}
+		}
+		return
+	}
+
+	switch msg.Type {
+	case MsgTypeEdit, MsgTypeDelete, MsgTypeReact, MsgTypeUnreact:
+		b.applyRemote(msg)
+	}
+
+	if err := saveMessage(b.store, msg); err != nil {
+		log.Printf("failed to save message: %v", err)
+	} else {
+		b.indexMessage(msg)
+	}
+
+	for _, user := range b.recipients(msg) {
+		b.deliver(user, msg)
+	}
+	if msg.Type == MsgTypeNormal && msg.ParentID != "" {
+		b.addReply(msg, false)
+	}
+}
@@ -0,0 +1,188 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+var (
+	ErrUnknownCommand = errors.New("unknown command, see /help")
+	ErrCommandExists  = errors.New("command already registered")
+	ErrInvalidCommand = errors.New("invalid command")
+)
+
+var commandNameRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]{0,19}$`)
+
+// Command is run instead of broadcasting a text starting with a slash
+type Command struct {
+	// Name is the command without the slash, like "nick"
+	Name string
+	// Usage describes the arguments, like "<user> <text>"
+	Usage string
+	Help  string
+
+	// Role is the least role allowed to run the command, anyone may if empty
+	Role string
+
+	// MinArgs and MaxArgs bound the number of arguments,
+	// the last one takes the rest of the line
+	MinArgs int
+	MaxArgs int
+
+	// Run does the work, an error is sent back to the caller
+	Run func(ctx *CommandContext) error
+}
+
+// CommandContext is what a command runs with
+type CommandContext struct {
+	User *User
+	// Room is the room the command was typed in
+	Room string
+	Args []string
+}
+
+// Reply sends the text to the caller only
+func (c *CommandContext) Reply(content string) {
+	c.User.Notify(NewSystemMsg(content))
+}
+
+// Arg returns the i-th argument, or the fallback if it was not given
+func (c *CommandContext) Arg(i int, fallback string) string {
+	if i < len(c.Args) {
+		return c.Args[i]
+	}
+	return fallback
+}
+
+func (cmd *Command) usage() string {
+	if cmd.Usage == "" {
+		return "/" + cmd.Name
+	}
+	return "/" + cmd.Name + " " + cmd.Usage
+}
+
+type commandRegistry struct {
+	mu       sync.RWMutex
+	commands map[string]*Command
+}
+
+// Commands holds the commands users can run, the built-in ones included
+var Commands = &commandRegistry{
+	commands: make(map[string]*Command),
+}
+
+// Register adds a command, a name can only be registered once
+func (r *commandRegistry) Register(cmd *Command) error {
+	if !commandNameRegexp.MatchString(cmd.Name) || cmd.Run == nil ||
+		cmd.MinArgs < 0 || cmd.MaxArgs < cmd.MinArgs {
+		return ErrInvalidCommand
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.commands[cmd.Name]; ok {
+		return ErrCommandExists
+	}
+	r.commands[cmd.Name] = cmd
+	return nil
+}
+
+func (r *commandRegistry) Lookup(name string) (*Command, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	cmd, ok := r.commands[name]
+	return cmd, ok
+}
+
+// List returns the commands sorted by name
+func (r *commandRegistry) List() []*Command {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := make([]*Command, 0, len(r.commands))
+	for _, cmd := range r.commands {
+		list = append(list, cmd)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Run runs the command line typed by the user in the room,
+// any error is sent back to the user
+func (r *commandRegistry) Run(user *User, room, line string) {
+	if err := r.run(user, room, line); err != nil {
+		user.Notify(NewErrorMsg(err.Error()))
+	}
+}
+
+func (r *commandRegistry) run(user *User, room, line string) error {
+	name, rest := cutSpace(strings.TrimPrefix(line, "/"))
+	cmd, ok := r.Lookup(strings.ToLower(name))
+	if !ok {
+		return ErrUnknownCommand
+	}
+
+	if !HasRole(user.Role, cmd.Role) {
+		return ErrPermissionDenied
+	}
+
+	args := splitArgs(rest, cmd.MaxArgs)
+	if len(args) < cmd.MinArgs || len(args) > cmd.MaxArgs {
+		return fmt.Errorf("usage: %s", cmd.usage())
+	}
+
+	if room == "" {
+		room = user.Room
+	}
+	log.Printf("%s runs /%s", user.Name, cmd.Name)
+	return cmd.Run(&CommandContext{User: user, Room: roomName(room), Args: args})
+}
+
+// splitArgs splits the line on spaces into at most max arguments,
+// the last one keeps the rest of the line, spaces included
+func splitArgs(line string, max int) []string {
+	var args []string
+	line = strings.TrimSpace(line)
+	for line != "" {
+		if len(args) == max-1 {
+			return append(args, line)
+		}
+
+		var arg string
+		arg, line = cutSpace(line)
+		args = append(args, arg)
+	}
+	return args
+}
+
+// cutSpace cuts the first word off the line
+func cutSpace(line string) (word, rest string) {
+	i := strings.IndexFunc(line, unicode.IsSpace)
+	if i < 0 {
+		return line, ""
+	}
+	return line[:i], strings.TrimSpace(line[i:])
+}
+
+// Send runs the content as a command if it starts with a slash, or says it in the room.
+// A double slash sends the text with one slash less.
+func (u *User) Send(room, content string) {
+	switch {
+	case strings.HasPrefix(content, "//"):
+		u.Say(room, content[1:])
+	case strings.HasPrefix(content, "/"):
+		Commands.Run(u, room, content)
+	default:
+		u.Say(room, content)
+	}
+}
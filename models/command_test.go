@@ -0,0 +1,239 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lastOfType returns the content of the last message of the type
+func lastOfType(msgs []*Message, msgType int) string {
+	var content string
+	for _, msg := range msgs {
+		if msg.Type == msgType {
+			content = msg.Content
+		}
+	}
+	return content
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line string
+		max  int
+		want []string
+	}{
+		{"", 2, nil},
+		{"  bob  ", 1, []string{"bob"}},
+		{"bob  see you  later", 2, []string{"bob", "see you  later"}},
+		{"a b c", 0, []string{"a", "b", "c"}},
+	}
+
+	for _, test := range tests {
+		if got := splitArgs(test.line, test.max); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitArgs(%q, %v) = %q, wanted %q", test.line, test.max, got, test.want)
+		}
+	}
+}
+
+func TestRegisterCommand(t *testing.T) {
+	run := func(ctx *CommandContext) error { return nil }
+
+	if err := Commands.Register(&Command{Name: "nick", Run: run}); err != ErrCommandExists {
+		t.Errorf("wanted %v for a built-in name, but got %v", ErrCommandExists, err)
+	}
+	for _, cmd := range []*Command{
+		{Name: "Bad Name", Run: run},
+		{Name: "norun"},
+		{Name: "args", MinArgs: 2, MaxArgs: 1, Run: run},
+	} {
+		if err := Commands.Register(cmd); err != ErrInvalidCommand {
+			t.Errorf("wanted %v for %+v, but got %v", ErrInvalidCommand, cmd, err)
+		}
+	}
+}
+
+func TestCommands(t *testing.T) {
+	defer clearUserListForTesting()
+
+	alice := &User{ID: 3001, Name: "testing_alice", Room: "cmd_room", MessageChannel: make(chan *Message, 64)}
+	bob := &User{ID: 3002, Name: "testing_bob", Room: "cmd_room", MessageChannel: make(chan *Message, 64)}
+	Broadcaster.UserLogin(alice)
+	Broadcaster.UserLogin(bob)
+	time.Sleep(50 * time.Millisecond)
+	drainMessages(alice)
+	drainMessages(bob)
+
+	// send runs the command line and waits for what it sent
+	send := func(content string) {
+		alice.Send("", content)
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	t.Run("unknown command", func(t *testing.T) {
+		send("/dance")
+		if got := lastOfType(drainMessages(alice), MsgTypeError); got != ErrUnknownCommand.Error() {
+			t.Errorf("wanted %q, but got %q", ErrUnknownCommand, got)
+		}
+		if msgs := drainMessages(bob); len(msgs) != 0 {
+			t.Errorf("a command should not be broadcast, but bob got %v", contents(msgs))
+		}
+	})
+
+	t.Run("double slash", func(t *testing.T) {
+		send("//shrug")
+		if got := lastOfType(drainMessages(bob), MsgTypeNormal); got != "/shrug" {
+			t.Errorf("wanted /shrug said in the room, but got %q", got)
+		}
+		drainMessages(alice)
+	})
+
+	t.Run("usage", func(t *testing.T) {
+		send("/msg testing_bob")
+		if got := lastOfType(drainMessages(alice), MsgTypeError); got != "usage: /msg <user> <text>" {
+			t.Errorf("wanted the usage, but got %q", got)
+		}
+	})
+
+	t.Run("msg", func(t *testing.T) {
+		send("/msg testing_bob see you later")
+		msgs := drainMessages(bob)
+		if len(msgs) != 1 || msgs[0].Type != MsgTypePrivate || msgs[0].Content != "see you later" {
+			t.Errorf("bob should have got the private message, but got %v", contents(msgs))
+		}
+		drainMessages(alice)
+	})
+
+	t.Run("me", func(t *testing.T) {
+		send("/me waves")
+		if got := lastOfType(drainMessages(bob), MsgTypeNormal); got != "* testing_alice waves" {
+			t.Errorf("wanted the action in the room, but got %q", got)
+		}
+		drainMessages(alice)
+	})
+
+	t.Run("who", func(t *testing.T) {
+		send("/who cmd_room")
+		got := lastOfType(drainMessages(alice), MsgTypeUserList)
+		if !strings.Contains(got, "testing_alice") || !strings.Contains(got, "testing_bob") {
+			t.Errorf("wanted both users listed, but got %q", got)
+		}
+		if msgs := drainMessages(bob); len(msgs) != 0 {
+			t.Errorf("the reply should be private, but bob got %v", contents(msgs))
+		}
+
+		Broadcaster.JoinRoom(bob, "secret_room")
+		send("/who secret_room")
+		if got := lastOfType(drainMessages(alice), MsgTypeError); got != ErrNotInRoom.Error() {
+			t.Errorf("wanted %q for a room alice is not in, but got %q", ErrNotInRoom, got)
+		}
+		Broadcaster.LeaveRoom(bob, "secret_room")
+		drainMessages(bob)
+	})
+
+	t.Run("join and leave", func(t *testing.T) {
+		send("/join other_room")
+		if !Broadcaster.inRoom(alice.Name, "other_room") {
+			t.Fatal("alice should have joined other_room")
+		}
+
+		send("/leave other_room")
+		if Broadcaster.inRoom(alice.Name, "other_room") {
+			t.Error("alice should have left other_room")
+		}
+		drainMessages(alice)
+	})
+
+	t.Run("topic", func(t *testing.T) {
+		send("/topic release on friday")
+		if got := lastOfType(drainMessages(bob), MsgTypeSystem); got != "testing_alice set the topic: release on friday" {
+			t.Errorf("the room should be told about the topic, but got %q", got)
+		}
+		drainMessages(alice)
+
+		send("/topic")
+		if got := lastOfType(drainMessages(alice), MsgTypeSystem); got != "the topic of cmd_room is: release on friday" {
+			t.Errorf("wanted the topic, but got %q", got)
+		}
+	})
+
+	t.Run("nick", func(t *testing.T) {
+		send("/nick testing_bob")
+		if got := lastOfType(drainMessages(alice), MsgTypeError); got != ErrNameTaken.Error() {
+			t.Errorf("wanted %q, but got %q", ErrNameTaken, got)
+		}
+
+		Broadcaster.Mute(alice.Name, time.Hour)
+		send("/nick testing_carol")
+		if alice.Name != "testing_carol" || !Broadcaster.inRoom("testing_carol", "cmd_room") {
+			t.Fatalf("alice should be testing_carol in cmd_room now, but is %v", alice.Name)
+		}
+		if got := lastOfType(drainMessages(bob), MsgTypeSystem); got != "testing_alice is now known as testing_carol" {
+			t.Errorf("the room should be told about the new name, but got %q", got)
+		}
+		if !Broadcaster.CheckUserCanLogin("testing_alice") {
+			t.Error("the old name should be free")
+		}
+		if _, ok := Broadcaster.mutes["testing_alice"]; !ok || !alice.Muted() {
+			t.Error("the mute should follow the account")
+		}
+		drainMessages(alice)
+	})
+
+	t.Run("help", func(t *testing.T) {
+		send("/help")
+		got := lastOfType(drainMessages(alice), MsgTypeSystem)
+		for _, cmd := range []string{"/help", "/join <room>", "/msg <user> <text>", "/who [room]"} {
+			if !strings.Contains(got, cmd) {
+				t.Errorf("the help should list %v, but got %q", cmd, got)
+			}
+		}
+	})
+}
+
+func TestCustomCommand(t *testing.T) {
+	defer clearUserListForTesting()
+
+	var args []string
+	err := Commands.Register(&Command{
+		Name:    "testing_announce",
+		Usage:   "<room> <text>",
+		Role:    RoleModerator,
+		MinArgs: 2,
+		MaxArgs: 2,
+		Run: func(ctx *CommandContext) error {
+			args = ctx.Args
+			ctx.Reply("announced")
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to register the command: %v", err)
+	}
+	defer func() {
+		Commands.mu.Lock()
+		delete(Commands.commands, "testing_announce")
+		Commands.mu.Unlock()
+	}()
+
+	member := &User{ID: 3003, Name: "testing_member", MessageChannel: make(chan *Message, 32)}
+	moderator := &User{ID: 3004, Name: "testing_mod", Role: RoleModerator, MessageChannel: make(chan *Message, 32)}
+	Broadcaster.UserLogin(member)
+	Broadcaster.UserLogin(moderator)
+
+	member.Send("", "/testing_announce lobby hello")
+	time.Sleep(50 * time.Millisecond)
+	if got := lastOfType(drainMessages(member), MsgTypeError); got != ErrPermissionDenied.Error() || args != nil {
+		t.Errorf("a member should not run the command, but got %q", got)
+	}
+
+	moderator.Send("", "/TESTING_ANNOUNCE lobby hello everyone")
+	time.Sleep(50 * time.Millisecond)
+	if !reflect.DeepEqual(args, []string{"lobby", "hello everyone"}) {
+		t.Errorf("wanted the parsed arguments, but got %q", args)
+	}
+	if got := lastOfType(drainMessages(moderator), MsgTypeSystem); got != "announced" {
+		t.Errorf("wanted the reply, but got %q", got)
+	}
+}
@@ -0,0 +1,152 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fyerfyer/chatroom/pkg/utils"
+)
+
+// the built-in commands
+func init() {
+	for _, cmd := range []*Command{
+		{Name: "nick", Usage: "<name>", Help: "change your name", MinArgs: 1, MaxArgs: 1, Run: nickCommand},
+		{Name: "me", Usage: "<action>", Help: "describe what you are doing", MinArgs: 1, MaxArgs: 1, Run: meCommand},
+		{Name: "who", Usage: "[room]", Help: "list the users online or in a room you are in", MaxArgs: 1, Run: whoCommand},
+		{Name: "msg", Usage: "<user> <text>", Help: "send a private message", MinArgs: 2, MaxArgs: 2, Run: msgCommand},
+		{Name: "join", Usage: "<room>", Help: "join a room", MinArgs: 1, MaxArgs: 1, Run: joinCommand},
+		{Name: "leave", Usage: "[room]", Help: "leave a room, this one by default", MaxArgs: 1, Run: leaveCommand},
+		{Name: "topic", Usage: "[topic]", Help: "show or set the topic of this room", MaxArgs: 1, Run: topicCommand},
+		{Name: "presence", Usage: "<online|away|busy|invisible>", Help: "set your presence", MinArgs: 1, MaxArgs: 1, Run: presenceCommand},
+		{Name: "help", Usage: "[command]", Help: "list the commands", MaxArgs: 1, Run: helpCommand},
+	} {
+		if err := Commands.Register(cmd); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func nickCommand(ctx *CommandContext) error {
+	if err := Broadcaster.Rename(ctx.User, ctx.Args[0]); err != nil {
+		return err
+	}
+
+	ctx.Reply("you are now known as " + ctx.User.Name)
+	return nil
+}
+
+func meCommand(ctx *CommandContext) error {
+	ctx.User.Say(ctx.Room, fmt.Sprintf("* %s %s", ctx.User.Name, ctx.Args[0]))
+	return nil
+}
+
+func whoCommand(ctx *CommandContext) error {
+	users := Broadcaster.GetUserList()
+	if len(ctx.Args) == 0 {
+		ctx.User.Notify(NewUserListMessage(users))
+		return nil
+	}
+
+	// only the members see who else is in a room
+	if !Broadcaster.InRoom(ctx.User.account(), ctx.Args[0]) {
+		return ErrNotInRoom
+	}
+	room := findRoom(ctx.Args[0])
+	if room == nil {
+		return ErrRoomNotExists
+	}
+
+	members := make([]*User, 0, len(room.Users))
+	for _, user := range users {
+		for _, name := range room.Users {
+			if user.Name == name {
+				members = append(members, user)
+			}
+		}
+	}
+	ctx.User.Notify(NewUserListMessage(members))
+	return nil
+}
+
+func msgCommand(ctx *CommandContext) error {
+	return ctx.User.Whisper(ctx.Args[0], ctx.Args[1])
+}
+
+func joinCommand(ctx *CommandContext) error {
+	room := ctx.Args[0]
+	if err := utils.ValidateRoomName(room); err != nil {
+		return err
+	}
+
+	if err := Broadcaster.JoinRoom(ctx.User, room); err != nil {
+		return err
+	}
+	ctx.Reply("you joined " + room)
+	return nil
+}
+
+func leaveCommand(ctx *CommandContext) error {
+	room := ctx.Arg(0, ctx.Room)
+	if err := Broadcaster.LeaveRoom(ctx.User, room); err != nil {
+		return err
+	}
+
+	ctx.Reply("you left " + room)
+	return nil
+}
+
+func topicCommand(ctx *CommandContext) error {
+	if len(ctx.Args) > 0 {
+		return Broadcaster.SetTopic(ctx.User, ctx.Room, ctx.Args[0])
+	}
+
+	room := findRoom(ctx.Room)
+	switch {
+	case room == nil:
+		return ErrRoomNotExists
+	case room.Topic == "":
+		ctx.Reply(ctx.Room + " has no topic")
+	default:
+		ctx.Reply(fmt.Sprintf("the topic of %s is: %s", ctx.Room, room.Topic))
+	}
+	return nil
+}
+
+func presenceCommand(ctx *CommandContext) error {
+	if err := Broadcaster.SetPresence(ctx.User, ctx.Args[0]); err != nil {
+		return err
+	}
+
+	ctx.Reply("you are " + ctx.Args[0])
+	return nil
+}
+
+func helpCommand(ctx *CommandContext) error {
+	if len(ctx.Args) > 0 {
+		cmd, ok := Commands.Lookup(strings.TrimPrefix(ctx.Args[0], "/"))
+		if !ok {
+			return ErrUnknownCommand
+		}
+		ctx.Reply(fmt.Sprintf("%s: %s", cmd.usage(), cmd.Help))
+		return nil
+	}
+
+	var lines []string
+	for _, cmd := range Commands.List() {
+		if HasRole(ctx.User.Role, cmd.Role) {
+			lines = append(lines, fmt.Sprintf("%s: %s", cmd.usage(), cmd.Help))
+		}
+	}
+	ctx.Reply(strings.Join(lines, "\n"))
+	return nil
+}
+
+// findRoom returns a snapshot of the named room, nil if nobody is in it
+func findRoom(name string) *Room {
+	for _, room := range Broadcaster.GetRoomList() {
+		if room.Name == name {
+			return room
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package models
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+var ErrMessageDeleted = errors.New("message was deleted")
+
+// canChange returns an error unless the user may edit or delete the message,
+// only its author and the moderators above the author may. The author is
+// known by its account, anyone can take a name the author no longer uses.
+func canChange(user *User) func(msg *Message) error {
+	return func(msg *Message) error {
+		if msg.User != nil && msg.User.account() == user.account() {
+			return nil
+		}
+		if msg.User != nil && CanModerate(user.Role, Accounts.Role(msg.User.account())) {
+			return nil
+		}
+		return ErrPermissionDenied
+	}
+}
+
+// changeMessage applies change to a copy of the stored message with the ID once check
+// lets it, stores it and tells the users of the room with the notice made from it.
+// A change returning no notice changed nothing, the changed message is nil then.
+func (b *broadcast) changeMessage(id string, check func(msg *Message) error,
+	change func(msg *Message) *Message) (*Message, error) {
+	if id == "" {
+		return nil, ErrMessageNotFound
+	}
+
+	stored, err := b.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if stored.Deleted {
+		return nil, ErrMessageDeleted
+	}
+	if err := check(stored); err != nil {
+		return nil, err
+	}
+
+	changed := *stored
+	notice := change(&changed)
+	if notice == nil {
+		return nil, nil
+	}
+	if err := b.store.Update(&changed); err != nil {
+		return nil, err
+	}
+	b.indexMessage(&changed)
+
+	messagesBroadcast.Inc(msgTypeName(notice.Type))
+	for _, u := range b.recipients(notice) {
+		b.deliver(u, notice)
+	}
+	b.publishMessage(notice)
+	return &changed, nil
+}
+
+func (b *broadcast) editMessage(user *User, id, content string) error {
+	// the new content goes through the filters like a new message
+	edit := NewMessage(user, MsgTypeNormal, content)
+	edit.Ref = id
+	if filtered(edit) {
+		if err := b.filter(edit); err != nil {
+			return err
+		}
+		content = edit.Content
+	}
+
+	edited, err := b.changeMessage(id, canChange(user), func(msg *Message) *Message {
+		msg.Content = content
+		msg.Ats = mentionRegexp.FindAllString(content, -1)
+		msg.EditedAt = time.Now()
+		return NewEditMsg(user, msg)
+	})
+	if err != nil {
+		return err
+	}
+
+	b.emit(&Event{Type: EventEdit, User: user.Name, Room: edited.Room, Message: edited})
+	return nil
+}
+
+func (b *broadcast) deleteMessage(user *User, id string) error {
+	deleted, err := b.changeMessage(id, canChange(user), func(msg *Message) *Message {
+		msg.Content = ""
+		msg.Ats = nil
+		msg.Reactions = nil
+		msg.Attachments = nil
+		msg.Deleted = true
+		return NewDeleteMsg(user, msg)
+	})
+	if err != nil {
+		return err
+	}
+
+	b.emit(&Event{Type: EventDelete, User: user.Name, Room: deleted.Room, Message: deleted})
+	return nil
+}
+
+// applyRemote applies an edit, a delete or a reaction made on another node to the store of this one
+func (b *broadcast) applyRemote(notice *Message) {
+	stored, err := b.store.Get(notice.Ref)
+	if err != nil {
+		return
+	}
+
+	changed := *stored
+	switch notice.Type {
+	case MsgTypeDelete:
+		changed.Content = ""
+		changed.Ats = nil
+		changed.Reactions = nil
+		changed.Attachments = nil
+		changed.Deleted = true
+	case MsgTypeEdit:
+		changed.Content = notice.Content
+		changed.Ats = notice.Ats
+		changed.EditedAt = notice.CreatedAt
+	case MsgTypeReact:
+		changed.react(notice.User.Name, notice.Content)
+	case MsgTypeUnreact:
+		changed.unreact(notice.User.Name, notice.Content)
+	}
+
+	if err := b.store.Update(&changed); err != nil {
+		log.Printf("failed to update message %s: %v", notice.Ref, err)
+		return
+	}
+	b.indexMessage(&changed)
+}
+
+// EditMessage replaces the content of a room message
+func (b *broadcast) EditMessage(user *User, id, content string) error {
+	err, _ := b.do(broadcastOp{typ: OpEditMessage, user: user, id: id, content: content}).(error)
+	return err
+}
+
+// DeleteMessage leaves a tombstone in place of a room message
+func (b *broadcast) DeleteMessage(user *User, id string) error {
+	err, _ := b.do(broadcastOp{typ: OpDeleteMessage, user: user, id: id}).(error)
+	return err
+}
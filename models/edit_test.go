@@ -0,0 +1,149 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+// noticeOf returns the edit or delete message in msgs applying to the ID
+func noticeOf(msgs []*Message, msgType int, id string) *Message {
+	for _, msg := range msgs {
+		if msg.Type == msgType && msg.Ref == id {
+			return msg
+		}
+	}
+	return nil
+}
+
+func TestEditMessage(t *testing.T) {
+	defer clearUserListForTesting()
+	defer ClearUserMsgProcessorForTesting()
+	ClearUserMsgProcessorForTesting()
+
+	alice := &User{ID: 5001, Name: "testing_alice", Room: "edit_room", MessageChannel: make(chan *Message, 32)}
+	bob := &User{ID: 5002, Name: "testing_bob", Room: "edit_room", MessageChannel: make(chan *Message, 32)}
+	Broadcaster.UserLogin(alice)
+	Broadcaster.UserLogin(bob)
+
+	msg := alice.Say("", "helo @testing_carol")
+	time.Sleep(50 * time.Millisecond)
+	drainMessages(alice)
+	drainMessages(bob)
+
+	if err := Broadcaster.EditMessage(bob, msg.ID, "hijacked"); err != ErrPermissionDenied {
+		t.Errorf("only the author should edit, but got %v", err)
+	}
+	if err := Broadcaster.EditMessage(alice, "missing", "hello"); err != ErrMessageNotFound {
+		t.Errorf("wanted %v, but got %v", ErrMessageNotFound, err)
+	}
+
+	if err := Broadcaster.EditMessage(alice, msg.ID, "hello @testing_carol"); err != nil {
+		t.Fatalf("failed to edit the message: %v", err)
+	}
+
+	for _, user := range []*User{alice, bob} {
+		notice := noticeOf(drainMessages(user), MsgTypeEdit, msg.ID)
+		if notice == nil || notice.Content != "hello @testing_carol" || notice.Room != "edit_room" {
+			t.Errorf("%v should have been told about the edit, but got %v", user.Name, notice)
+		}
+	}
+
+	stored, _ := UserMessageProcessor.Get(msg.ID)
+	if stored.Content != "hello @testing_carol" || stored.EditedAt.IsZero() || stored.Seq != msg.Seq {
+		t.Errorf("wanted the edited message stored in place, but got %+v", stored)
+	}
+	if msg.Content != "helo @testing_carol" {
+		t.Error("the message already delivered should not change")
+	}
+
+	// the mentioned user gets the edited version once online
+	carol := &User{ID: 5003, Name: "testing_carol", Room: "edit_room", MessageChannel: make(chan *Message, 32)}
+	Broadcaster.UserLogin(carol)
+	var replayed int
+	for _, m := range drainMessages(carol) {
+		if m.ID == msg.ID {
+			replayed++
+			if m.Content != "hello @testing_carol" {
+				t.Errorf("wanted the edited message replayed, but got %q", m.Content)
+			}
+		}
+	}
+	if replayed == 0 {
+		t.Error("the edited message should have been replayed")
+	}
+}
+
+func TestDeleteMessage(t *testing.T) {
+	defer clearUserListForTesting()
+	defer ClearUserMsgProcessorForTesting()
+	ClearUserMsgProcessorForTesting()
+
+	alice := &User{ID: 5004, Name: "testing_alice", Room: "edit_room", MessageChannel: make(chan *Message, 32)}
+	moderator := &User{ID: 5005, Name: "testing_mod", Room: "edit_room", Role: RoleModerator, MessageChannel: make(chan *Message, 32)}
+	Broadcaster.UserLogin(alice)
+	Broadcaster.UserLogin(moderator)
+
+	msg := alice.Say("", "spam")
+	time.Sleep(50 * time.Millisecond)
+	drainMessages(alice)
+
+	if err := Broadcaster.DeleteMessage(moderator, msg.ID); err != nil {
+		t.Fatalf("a moderator should delete the message, but got %v", err)
+	}
+	if notice := noticeOf(drainMessages(alice), MsgTypeDelete, msg.ID); notice == nil {
+		t.Error("alice should have been told about the delete")
+	}
+
+	if err := Broadcaster.EditMessage(alice, msg.ID, "not spam"); err != ErrMessageDeleted {
+		t.Errorf("wanted %v, but got %v", ErrMessageDeleted, err)
+	}
+
+	// a tombstone is replayed in place of the message
+	bob := &User{ID: 5006, Name: "testing_bob", Room: "edit_room", MessageChannel: make(chan *Message, 32)}
+	Broadcaster.UserLogin(bob)
+	var tombstone *Message
+	for _, m := range drainMessages(bob) {
+		if m.ID == msg.ID {
+			tombstone = m
+		}
+	}
+	if tombstone == nil || !tombstone.Deleted || tombstone.Content != "" {
+		t.Errorf("wanted a tombstone replayed, but got %+v", tombstone)
+	}
+}
+
+func TestEditAfterNameTaken(t *testing.T) {
+	defer clearUserListForTesting()
+
+	// the file store keeps the name the message was sent under
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open file store: %v", err)
+	}
+	defer store.Close()
+	Broadcaster.SetStore(store)
+	defer Broadcaster.SetStore(UserMessageProcessor)
+
+	alice := &User{ID: 5007, Name: "testing_alice", Account: "testing_alice", Room: "edit_room", MessageChannel: make(chan *Message, 32)}
+	bob := &User{ID: 5008, Name: "testing_bob", Account: "testing_bob", Room: "edit_room", MessageChannel: make(chan *Message, 32)}
+	Broadcaster.UserLogin(alice)
+	Broadcaster.UserLogin(bob)
+
+	if err := Broadcaster.Rename(alice, "testing_nick"); err != nil {
+		t.Fatalf("failed to rename: %v", err)
+	}
+	msg := alice.Say("", "mine")
+	time.Sleep(50 * time.Millisecond)
+
+	// bob takes the name alice no longer uses
+	Broadcaster.Rename(alice, "testing_alice2")
+	if err := Broadcaster.Rename(bob, "testing_nick"); err != nil {
+		t.Fatalf("failed to rename: %v", err)
+	}
+	if err := Broadcaster.EditMessage(bob, msg.ID, "hijacked"); err != ErrPermissionDenied {
+		t.Errorf("only the account that sent it should edit, but got %v", err)
+	}
+	if err := Broadcaster.EditMessage(alice, msg.ID, "still mine"); err != nil {
+		t.Errorf("alice should edit under her new name, but got %v", err)
+	}
+}
@@ -0,0 +1,537 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const (
+	logFileName   = "messages.log"
+	indexFileName = "messages.idx"
+	inboxFileName = "inbox.log"
+
+	// seq, offset, size and creation time, 8 bytes each
+	indexEntrySize = 32
+)
+
+// fileStore is the MessageStore that survives restarts.
+// Messages are appended to a log of json lines, the index maps every
+// sequence number to the position of its line in the log.
+// An updated message is appended again and its index entry moved to the new line.
+type fileStore struct {
+	mu  sync.Mutex
+	dir string
+
+	log     *os.File
+	logSize int64
+	index   *os.File
+	entries []indexEntry
+	// ids maps the ID of every message to its sequence number
+	ids    map[string]uint64
+	counts *seqIndex
+
+	inbox   *os.File
+	inboxes map[string][]*Message
+}
+
+type indexEntry struct {
+	seq       uint64
+	offset    int64
+	size      int64
+	createdAt int64
+}
+
+// inboxRecord is a line of the inbox log,
+// a record without message clears the user's inbox
+// and an update replaces the message of the same ID
+type inboxRecord struct {
+	Name    string   `json:"name"`
+	Message *Message `json:"message,omitempty"`
+	Update  bool     `json:"update,omitempty"`
+}
+
+func NewFileStore(dir string) (MessageStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &fileStore{
+		dir:     dir,
+		ids:     make(map[string]uint64),
+		counts:  newSeqIndex(),
+		inboxes: make(map[string][]*Message),
+	}
+
+	if err := s.openLog(); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	if err := s.openInbox(); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// openLog loads the index and recovers the lines
+// that were appended to the log but never indexed
+func (s *fileStore) openLog() error {
+	var err error
+	s.log, err = os.OpenFile(filepath.Join(s.dir, logFileName), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	s.index, err = os.OpenFile(filepath.Join(s.dir, indexFileName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := s.log.Stat()
+	if err != nil {
+		return err
+	}
+	s.logSize = info.Size()
+
+	data, err := io.ReadAll(s.index)
+	if err != nil {
+		return err
+	}
+
+	for len(data) >= indexEntrySize {
+		entry := decodeIndexEntry(data[:indexEntrySize])
+		if entry.offset+entry.size > s.logSize {
+			break
+		}
+		s.entries = append(s.entries, entry)
+		data = data[indexEntrySize:]
+	}
+
+	// drop a torn index record before appending to it again
+	if err := s.index.Truncate(int64(len(s.entries)) * indexEntrySize); err != nil {
+		return err
+	}
+	if _, err := s.index.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+
+	if err := s.loadIDs(); err != nil {
+		return err
+	}
+	return s.recover()
+}
+
+// loadIDs reads the ID of every indexed message and counts it in its room
+func (s *fileStore) loadIDs() error {
+	for _, entry := range s.entries {
+		data := make([]byte, entry.size)
+		if _, err := s.log.ReadAt(data, entry.offset); err != nil {
+			return err
+		}
+
+		var msg struct {
+			ID      string `json:"id"`
+			Room    string `json:"room"`
+			User    *User  `json:"from_user"`
+			Deleted bool   `json:"deleted"`
+		}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return err
+		}
+		s.ids[msg.ID] = entry.seq
+
+		s.counts.set(msg.Room, senderOf(&Message{User: msg.User}), entry.seq, msg.Deleted)
+	}
+	return nil
+}
+
+func (s *fileStore) recover() error {
+	// the updates have moved some entries past the last one
+	var offset int64
+	for _, entry := range s.entries {
+		offset = max(offset, entry.offset+entry.size)
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(s.log, offset, s.logSize-offset))
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		var msg Message
+		if err := json.Unmarshal(line, &msg); err != nil {
+			break
+		}
+
+		entry := indexEntry{
+			seq:       msg.Seq,
+			offset:    offset,
+			size:      int64(len(line)),
+			createdAt: msg.CreatedAt.UnixNano(),
+		}
+
+		// an update that never made it to the index
+		if i, ok := s.find(msg.Seq); ok {
+			err = s.moveIndexEntry(i, entry)
+		} else {
+			err = s.writeIndexEntry(entry)
+		}
+		if err != nil {
+			return err
+		}
+		s.ids[msg.ID] = msg.Seq
+		s.counts.set(msg.Room, senderOf(&msg), msg.Seq, msg.Deleted)
+		offset += entry.size
+	}
+
+	// cut the half written line off the end of the log
+	if offset < s.logSize {
+		if err := s.log.Truncate(offset); err != nil {
+			return err
+		}
+		s.logSize = offset
+	}
+
+	return nil
+}
+
+// openInbox replays the inbox log and compacts it to the pending messages
+func (s *fileStore) openInbox() error {
+	path := filepath.Join(s.dir, inboxFileName)
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var record inboxRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			continue
+		}
+
+		switch {
+		case record.Message == nil:
+			delete(s.inboxes, record.Name)
+		case record.Update:
+			replaceByID(s.inboxes[record.Name], record.Message)
+		default:
+			s.inboxes[record.Name] = append(s.inboxes[record.Name], record.Message)
+		}
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for name, msgs := range s.inboxes {
+		for _, msg := range msgs {
+			if err := encoder.Encode(inboxRecord{Name: name, Message: msg}); err != nil {
+				return err
+			}
+		}
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	s.inbox, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	return err
+}
+
+func (s *fileStore) Append(msg *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var seq uint64 = 1
+	if n := len(s.entries); n > 0 {
+		seq = s.entries[n-1].seq + 1
+	}
+	msg.Seq = seq
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if _, err := s.log.Write(data); err != nil {
+		return err
+	}
+
+	entry := indexEntry{
+		seq:       seq,
+		offset:    s.logSize,
+		size:      int64(len(data)),
+		createdAt: msg.CreatedAt.UnixNano(),
+	}
+	s.logSize += entry.size
+	if err := s.writeIndexEntry(entry); err != nil {
+		return err
+	}
+	s.ids[msg.ID] = seq
+	s.counts.set(msg.Room, senderOf(msg), seq, msg.Deleted)
+	return nil
+}
+
+func (s *fileStore) writeIndexEntry(entry indexEntry) error {
+	if _, err := s.index.Write(encodeIndexEntry(entry)); err != nil {
+		return err
+	}
+
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+func (s *fileStore) Range(q Query) ([]*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// narrow the scan down to the requested sequence window
+	lo := sort.Search(len(s.entries), func(i int) bool {
+		return s.entries[i].seq > q.AfterSeq
+	})
+	hi := len(s.entries)
+	if q.BeforeSeq != 0 {
+		hi = sort.Search(len(s.entries), func(i int) bool {
+			return s.entries[i].seq >= q.BeforeSeq
+		})
+	}
+
+	var msgs []*Message
+	collect := func(i int) error {
+		// the index knows the creation time, skip reading what is out of range
+		createdAt := s.entries[i].createdAt
+		if !q.Since.IsZero() && createdAt < q.Since.UnixNano() {
+			return nil
+		}
+		if !q.Until.IsZero() && createdAt >= q.Until.UnixNano() {
+			return nil
+		}
+
+		msg, err := s.read(s.entries[i])
+		if err != nil {
+			return err
+		}
+		if q.match(msg) {
+			msgs = append(msgs, msg)
+		}
+		return nil
+	}
+
+	if q.Latest {
+		for i := hi - 1; i >= lo && !q.full(len(msgs)); i-- {
+			if err := collect(i); err != nil {
+				return nil, err
+			}
+		}
+
+		// keep the result in sequence order
+		for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+			msgs[i], msgs[j] = msgs[j], msgs[i]
+		}
+		return msgs, nil
+	}
+
+	for i := lo; i < hi && !q.full(len(msgs)); i++ {
+		if err := collect(i); err != nil {
+			return nil, err
+		}
+	}
+	return msgs, nil
+}
+
+func (s *fileStore) Count(room, from string, afterSeq uint64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.counts.count(room, from, afterSeq), nil
+}
+
+func (s *fileStore) read(entry indexEntry) (*Message, error) {
+	data := make([]byte, entry.size)
+	if _, err := s.log.ReadAt(data, entry.offset); err != nil {
+		return nil, err
+	}
+
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// find returns the position of the entry with the sequence number
+func (s *fileStore) find(seq uint64) (int, bool) {
+	i := sort.Search(len(s.entries), func(i int) bool {
+		return s.entries[i].seq >= seq
+	})
+	return i, i < len(s.entries) && s.entries[i].seq == seq
+}
+
+// moveIndexEntry points the i-th entry to another line of the log
+func (s *fileStore) moveIndexEntry(i int, entry indexEntry) error {
+	if _, err := s.index.WriteAt(encodeIndexEntry(entry), int64(i)*indexEntrySize); err != nil {
+		return err
+	}
+
+	s.entries[i] = entry
+	return nil
+}
+
+func (s *fileStore) Get(id string) (*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seq, ok := s.ids[id]
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	i, ok := s.find(seq)
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	return s.read(s.entries[i])
+}
+
+func (s *fileStore) Update(msg *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.find(msg.Seq)
+	if !ok {
+		return ErrMessageNotFound
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if _, err := s.log.Write(data); err != nil {
+		return err
+	}
+
+	entry := indexEntry{
+		seq:       msg.Seq,
+		offset:    s.logSize,
+		size:      int64(len(data)),
+		createdAt: s.entries[i].createdAt,
+	}
+	s.logSize += entry.size
+	if err := s.moveIndexEntry(i, entry); err != nil {
+		return err
+	}
+	s.counts.set(msg.Room, senderOf(msg), msg.Seq, msg.Deleted)
+
+	for name, msgs := range s.inboxes {
+		if !replaceByID(msgs, msg) {
+			continue
+		}
+		if err := s.writeInboxRecord(inboxRecord{Name: name, Message: msg, Update: true}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceByID replaces the messages with the ID of msg, it reports whether there was any
+func replaceByID(msgs []*Message, msg *Message) bool {
+	replaced := false
+	for i, old := range msgs {
+		if old.ID == msg.ID {
+			msgs[i] = msg
+			replaced = true
+		}
+	}
+	return replaced
+}
+
+func (s *fileStore) PushInbox(name string, msg *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.writeInboxRecord(inboxRecord{Name: name, Message: msg}); err != nil {
+		return err
+	}
+
+	s.inboxes[name] = append(s.inboxes[name], msg)
+	return nil
+}
+
+func (s *fileStore) PopInbox(name string) ([]*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msgs, ok := s.inboxes[name]
+	if !ok {
+		return nil, nil
+	}
+
+	if err := s.writeInboxRecord(inboxRecord{Name: name}); err != nil {
+		return nil, err
+	}
+
+	delete(s.inboxes, name)
+	return msgs, nil
+}
+
+func (s *fileStore) writeInboxRecord(record inboxRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.inbox.Write(append(data, '\n'))
+	return err
+}
+
+func (s *fileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	for _, f := range []*os.File{s.log, s.index, s.inbox} {
+		if f == nil {
+			continue
+		}
+		errs = append(errs, f.Sync(), f.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+func encodeIndexEntry(entry indexEntry) []byte {
+	buf := make([]byte, indexEntrySize)
+	binary.BigEndian.PutUint64(buf[0:], entry.seq)
+	binary.BigEndian.PutUint64(buf[8:], uint64(entry.offset))
+	binary.BigEndian.PutUint64(buf[16:], uint64(entry.size))
+	binary.BigEndian.PutUint64(buf[24:], uint64(entry.createdAt))
+	return buf
+}
+
+func decodeIndexEntry(buf []byte) indexEntry {
+	return indexEntry{
+		seq:       binary.BigEndian.Uint64(buf[0:]),
+		offset:    int64(binary.BigEndian.Uint64(buf[8:])),
+		size:      int64(binary.BigEndian.Uint64(buf[16:])),
+		createdAt: int64(binary.BigEndian.Uint64(buf[24:])),
+	}
+}
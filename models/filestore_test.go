@@ -0,0 +1,295 @@
+package models
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func appendMessagesForTesting(t *testing.T, store MessageStore, n int) []*Message {
+	user := &User{Name: "testing_user1"}
+
+	var msgs []*Message
+	for i := 0; i < n; i++ {
+		msg := NewMessage(user, MsgTypeNormal, "testing message:"+strconv.Itoa(i))
+		msg.Room = "room_a"
+		if err := store.Append(msg); err != nil {
+			t.Fatalf("failed to append message: %v", err)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	return msgs
+}
+
+func TestFileStoreRange(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open file store: %v", err)
+	}
+	defer store.Close()
+
+	msgs := appendMessagesForTesting(t, store, 20)
+	if msgs[19].Seq != 20 {
+		t.Errorf("wanted sequence number 20, but got %v", msgs[19].Seq)
+		return
+	}
+
+	got, err := store.Range(Query{AfterSeq: 5, BeforeSeq: 15, Limit: 3})
+	if err != nil {
+		t.Fatalf("failed to range messages: %v", err)
+	}
+	if len(got) != 3 || got[0].Seq != 6 || got[2].Seq != 8 {
+		t.Errorf("wanted messages 6 to 8, but got %v", got)
+		return
+	}
+
+	got, _ = store.Range(Query{AfterSeq: 5, BeforeSeq: 15, Limit: 3, Latest: true})
+	if len(got) != 3 || got[0].Seq != 12 || got[2].Seq != 14 {
+		t.Errorf("wanted messages 12 to 14, but got %v", got)
+		return
+	}
+
+	got, _ = store.Range(Query{Since: msgs[10].CreatedAt, Until: msgs[12].CreatedAt})
+	if len(got) != 2 || got[0].Content != msgs[10].Content {
+		t.Errorf("wanted messages 11 and 12, but got %v", got)
+		return
+	}
+
+	if got, _ = store.Range(Query{Room: "room_b"}); len(got) != 0 {
+		t.Errorf("there should be no message in room_b, but got %v", got)
+	}
+}
+
+func TestFileStoreSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("failed to open file store: %v", err)
+	}
+
+	appendMessagesForTesting(t, store, 5)
+	store.PushInbox("testing_user2", NewPrivateMsg(&User{Name: "testing_user1"}, "testing_user2", "psst"))
+	store.PushInbox("testing_user3", NewPrivateMsg(&User{Name: "testing_user1"}, "testing_user3", "psst"))
+	store.PopInbox("testing_user3")
+	store.Close()
+
+	store, err = NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("failed to reopen file store: %v", err)
+	}
+	defer store.Close()
+
+	got, _ := store.Range(Query{})
+	if len(got) != 5 || got[4].Content != "testing message:4" {
+		t.Errorf("wanted 5 messages after restart, but got %v", got)
+		return
+	}
+
+	if inbox, _ := store.PopInbox("testing_user2"); len(inbox) != 1 {
+		t.Errorf("wanted 1 message in inbox after restart, but got %v", inbox)
+	}
+	if inbox, _ := store.PopInbox("testing_user3"); len(inbox) != 0 {
+		t.Errorf("popped inbox should stay empty after restart, but got %v", inbox)
+	}
+
+	// sequence numbers carry on after a restart
+	msg := NewMessage(&User{Name: "testing_user1"}, MsgTypeNormal, "after restart")
+	store.Append(msg)
+	if msg.Seq != 6 {
+		t.Errorf("wanted sequence number 6, but got %v", msg.Seq)
+	}
+}
+
+func TestFileStoreRecovery(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("failed to open file store: %v", err)
+	}
+	appendMessagesForTesting(t, store, 3)
+	store.Close()
+
+	// lose the index and leave half a line at the end of the log
+	os.Remove(filepath.Join(dir, indexFileName))
+	f, _ := os.OpenFile(filepath.Join(dir, logFileName), os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString(`{"seq":4,"content":"torn`)
+	f.Close()
+
+	store, err = NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("failed to reopen file store: %v", err)
+	}
+	defer store.Close()
+
+	got, _ := store.Range(Query{})
+	if len(got) != 3 {
+		t.Errorf("wanted the 3 complete messages, but got %v", got)
+		return
+	}
+
+	msg := NewMessage(&User{Name: "testing_user1"}, MsgTypeNormal, "after recovery")
+	store.Append(msg)
+	if got, _ = store.Range(Query{AfterSeq: 3}); len(got) != 1 || got[0].Content != msg.Content {
+		t.Errorf("wanted the message appended after recovery, but got %v", got)
+	}
+	for _, m := range append(got, msg) {
+		if found, err := store.Get(m.ID); err != nil || found.Seq != m.Seq {
+			t.Errorf("wanted message %v by its ID, but got %v %v", m.Seq, found, err)
+		}
+	}
+}
+
+func TestBroadcastPersistsThroughStore(t *testing.T) {
+	defer clearUserListForTesting()
+
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open file store: %v", err)
+	}
+	defer store.Close()
+
+	Broadcaster.SetStore(store)
+	defer Broadcaster.SetStore(UserMessageProcessor)
+
+	user := &User{ID: 1, Name: "testing_user1", MessageChannel: make(chan *Message, 32)}
+	loginUserWithoutSendingMessage(user)
+
+	Broadcaster.Broadcast(NewMessage(user, MsgTypeNormal, "persist me"))
+	time.Sleep(50 * time.Millisecond)
+
+	got, _ := store.Range(Query{})
+	if len(got) != 1 || got[0].Content != "persist me" {
+		t.Errorf("wanted the broadcast message in the store, but got %v", got)
+	}
+}
+
+func TestFileStoreUpdate(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("failed to open file store: %v", err)
+	}
+
+	msgs := appendMessagesForTesting(t, store, 3)
+	store.PushInbox("testing_user2", msgs[1])
+	if _, err := store.Get("missing"); err != ErrMessageNotFound {
+		t.Errorf("wanted %v, but got %v", ErrMessageNotFound, err)
+	}
+
+	edited, err := store.Get(msgs[1].ID)
+	if err != nil {
+		t.Fatalf("failed to get the message: %v", err)
+	}
+	edited.Content = "edited"
+	if err := store.Update(edited); err != nil {
+		t.Fatalf("failed to update the message: %v", err)
+	}
+	store.Close()
+
+	store, err = NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("failed to reopen file store: %v", err)
+	}
+	defer store.Close()
+
+	got, _ := store.Range(Query{})
+	if len(got) != 3 || got[1].Content != "edited" || got[1].Seq != 2 || got[2].Seq != 3 {
+		t.Errorf("wanted the edited message in place, but got %v", got)
+	}
+
+	// the IDs are known again after a restart
+	if got, err := store.Get(msgs[1].ID); err != nil || got.Content != "edited" {
+		t.Errorf("wanted the edited message by its ID, but got %v %v", got, err)
+	}
+
+	inbox, _ := store.PopInbox("testing_user2")
+	if len(inbox) != 1 || inbox[0].Content != "edited" {
+		t.Errorf("wanted the edited message in the inbox, but got %v", inbox)
+	}
+
+	// appending goes on after the moved line
+	msg := NewMessage(&User{Name: "testing_user1"}, MsgTypeNormal, "after update")
+	store.Append(msg)
+	if msg.Seq != 4 {
+		t.Errorf("wanted sequence number 4, but got %v", msg.Seq)
+	}
+}
+
+func TestFileStoreRecoversUpdate(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("failed to open file store: %v", err)
+	}
+	msgs := appendMessagesForTesting(t, store, 2)
+	store.Close()
+
+	// an update written to the log but not to the index
+	edited := *msgs[0]
+	edited.Content = "edited"
+	data, _ := json.Marshal(&edited)
+	f, _ := os.OpenFile(filepath.Join(dir, logFileName), os.O_WRONLY|os.O_APPEND, 0644)
+	f.Write(append(data, '\n'))
+	f.Close()
+
+	store, err = NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("failed to reopen file store: %v", err)
+	}
+	defer store.Close()
+
+	got, _ := store.Range(Query{})
+	if len(got) != 2 || got[0].Content != "edited" {
+		t.Errorf("wanted the recovered update, but got %v", got)
+	}
+}
+
+func TestStoreCount(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("failed to open file store: %v", err)
+	}
+	defer func() { fs.Close() }()
+
+	// more messages than the memory store replays
+	for _, store := range []MessageStore{newUserMessageProcessor(), fs} {
+		msgs := appendMessagesForTesting(t, store, 15)
+		other := NewMessage(&User{Name: "testing_user2"}, MsgTypeNormal, "mine")
+		other.Room = "room_a"
+		store.Append(other)
+
+		deleted := *msgs[12]
+		deleted.Deleted = true
+		if err := store.Update(&deleted); err != nil {
+			t.Fatalf("failed to delete the message: %v", err)
+		}
+
+		check := func() {
+			t.Helper()
+			if n, _ := store.Count("room_a", "", 0); n != 15 {
+				t.Errorf("wanted 15 messages not deleted in the room, but got %v", n)
+			}
+			if n, _ := store.Count("room_a", "testing_user1", 10); n != 4 {
+				t.Errorf("wanted 4 messages of testing_user1 after 10, but got %v", n)
+			}
+			if n, _ := store.Count("room_b", "", 0); n != 0 {
+				t.Errorf("wanted no message in another room, but got %v", n)
+			}
+		}
+		check()
+
+		if store == fs {
+			fs.Close()
+			if fs, err = NewFileStore(dir); err != nil {
+				t.Fatalf("failed to reopen file store: %v", err)
+			}
+			store = fs
+			check()
+		}
+	}
+}
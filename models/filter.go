@@ -0,0 +1,188 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownFilter = errors.New("unknown filter")
+	ErrFilterExists  = errors.New("filter already registered")
+)
+
+// the actions a filter can take on a message it matches
+const (
+	FilterReject = "reject"
+	FilterFlag   = "flag"
+	FilterMask   = "mask"
+)
+
+// Filter inspects a chat message before it is broadcast. It may rewrite the content,
+// return an error to reject the message, or a reason to flag it to the moderators.
+// Filters run on the broadcaster, one message at a time, and must not block.
+// An edit is filtered as a message with Ref set to the ID of the edited one.
+type Filter interface {
+	Filter(msg *Message) (flag string, err error)
+}
+
+// FilterFunc turns a function into a Filter
+type FilterFunc func(msg *Message) (string, error)
+
+func (f FilterFunc) Filter(msg *Message) (string, error) {
+	return f(msg)
+}
+
+// FilterConfig holds the keys of the [filter.<name>] section of a filter
+type FilterConfig map[string]string
+
+func (c FilterConfig) String(key, fallback string) string {
+	if v, ok := c[key]; ok && v != "" {
+		return v
+	}
+	return fallback
+}
+
+// Strings splits a comma separated value, the empty items are left out
+func (c FilterConfig) Strings(key string) []string {
+	var items []string
+	for _, item := range strings.Split(c[key], ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (c FilterConfig) Int(key string, fallback int) (int, error) {
+	v, ok := c[key]
+	if !ok || v == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	return n, nil
+}
+
+func (c FilterConfig) Duration(key string, fallback time.Duration) (time.Duration, error) {
+	v, ok := c[key]
+	if !ok || v == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	return d, nil
+}
+
+// Action returns the action of the filter, one of the allowed ones
+func (c FilterConfig) Action(fallback string, allowed ...string) (string, error) {
+	action := c.String("Action", fallback)
+	for _, a := range allowed {
+		if a == action {
+			return action, nil
+		}
+	}
+	return "", fmt.Errorf("Action: %q is not one of %s", action, strings.Join(allowed, ", "))
+}
+
+// FilterFactory makes a filter from its section of the config
+type FilterFactory func(config FilterConfig) (Filter, error)
+
+type filterRegistry struct {
+	mu        sync.RWMutex
+	factories map[string]FilterFactory
+}
+
+// FilterTypes holds the filters the config can name, the built-in ones included
+var FilterTypes = &filterRegistry{
+	factories: make(map[string]FilterFactory),
+}
+
+// Register adds a filter, a name can only be registered once
+func (r *filterRegistry) Register(name string, factory FilterFactory) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.factories[name]; ok {
+		return ErrFilterExists
+	}
+	r.factories[name] = factory
+	return nil
+}
+
+// New makes the named filter from its config
+func (r *filterRegistry) New(name string, config FilterConfig) (Filter, error) {
+	r.mu.RLock()
+	factory, ok := r.factories[name]
+	r.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownFilter, name)
+	}
+
+	f, err := factory(config)
+	if err != nil {
+		return nil, fmt.Errorf("filter %s: %w", name, err)
+	}
+	return f, nil
+}
+
+type namedFilter struct {
+	name   string
+	filter Filter
+}
+
+// UseFilter appends a filter to the chain every chat message goes through,
+// it must be called before Start
+func (b *broadcast) UseFilter(name string, f Filter) {
+	b.filters = append(b.filters, namedFilter{name: name, filter: f})
+}
+
+// filtered reports whether the message is a chat message of a user, bots and the server are trusted
+func filtered(msg *Message) bool {
+	return (msg.Type == MsgTypeNormal || msg.Type == MsgTypePrivate) &&
+		msg.User != nil && msg.User != System && !msg.User.IsBot
+}
+
+// filter runs the message through the chain, the error of the first filter
+// rejecting it stops it. A flagged message goes on and the moderators are told.
+func (b *broadcast) filter(msg *Message) error {
+	var flags []string
+	for _, f := range b.filters {
+		flag, err := f.filter.Filter(msg)
+		if err != nil {
+			messagesFiltered.Inc(f.name)
+			return err
+		}
+		if flag != "" {
+			messagesFiltered.Inc(f.name)
+			flags = append(flags, f.name+": "+flag)
+		}
+	}
+
+	if len(flags) > 0 {
+		b.flag(msg, strings.Join(flags, "; "))
+	}
+	return nil
+}
+
+// flag tells the moderators online and the listeners about a message
+func (b *broadcast) flag(msg *Message, reason string) {
+	log.Printf("flagged message %s from %s: %s", msg.ID, msg.User.Name, reason)
+
+	notice := NewSystemMsg(fmt.Sprintf("message %s from %s was flagged: %s", msg.ID, msg.User.Name, reason))
+	for _, u := range b.users {
+		if HasRole(u.Role, RoleModerator) {
+			b.deliver(u, notice)
+		}
+	}
+	b.emit(&Event{Type: EventFlag, User: msg.User.Name, Room: msg.Room, Message: msg, Reason: reason})
+}
@@ -0,0 +1,193 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newFilterForTesting(t *testing.T, name string, config FilterConfig) Filter {
+	f, err := FilterTypes.New(name, config)
+	if err != nil {
+		t.Fatalf("failed to create filter %v: %v", name, err)
+	}
+	return f
+}
+
+func runFilter(f Filter, user, content string) (*Message, string, error) {
+	msg := NewMessage(&User{Name: user}, MsgTypeNormal, content)
+	flag, err := f.Filter(msg)
+	return msg, flag, err
+}
+
+func TestBuiltinFilters(t *testing.T) {
+	t.Run("censor", func(t *testing.T) {
+		mask := newFilterForTesting(t, "censor", FilterConfig{"Words": "darn, heck"})
+		msg, _, _ := runFilter(mask, "alice", "Darn it, what the heck, darnation")
+		if msg.Content != "**** it, what the ****, darnation" {
+			t.Errorf("wanted the whole words masked, but got %q", msg.Content)
+		}
+
+		reject := newFilterForTesting(t, "censor", FilterConfig{"Words": "darn", "Action": "reject"})
+		if _, _, err := runFilter(reject, "alice", "darn"); err != ErrCensored {
+			t.Errorf("wanted %v, but got %v", ErrCensored, err)
+		}
+
+		flag := newFilterForTesting(t, "censor", FilterConfig{"Words": "darn", "Action": "flag"})
+		if msg, reason, err := runFilter(flag, "alice", "DARN"); err != nil || reason == "" || msg.Content != "DARN" {
+			t.Errorf("wanted the message flagged as is, but got %q %q %v", msg.Content, reason, err)
+		}
+
+		// the words are not all ASCII, and some scripts have no spaces
+		unicode := newFilterForTesting(t, "censor", FilterConfig{"Words": "café, 傻瓜"})
+		for content, want := range map[string]string{
+			"the CAFÉ is closed": "the **** is closed",
+			"two cafés":          "two cafés",
+			"你是傻瓜吗":              "你是**吗",
+			"café_au_lait":       "café_au_lait",
+		} {
+			if msg, _, _ := runFilter(unicode, "alice", content); msg.Content != want {
+				t.Errorf("wanted %q masked as %q, but got %q", content, want, msg.Content)
+			}
+		}
+	})
+
+	t.Run("length", func(t *testing.T) {
+		f := newFilterForTesting(t, "length", FilterConfig{"Max": "5"})
+		if _, _, err := runFilter(f, "alice", "héllo"); err != nil {
+			t.Errorf("5 characters should be fine, but got %v", err)
+		}
+		if _, _, err := runFilter(f, "alice", "hello!"); err == nil {
+			t.Error("6 characters should be rejected")
+		}
+	})
+
+	t.Run("links", func(t *testing.T) {
+		f := newFilterForTesting(t, "links", FilterConfig{"Blocked": "spam.com"})
+		for _, content := range []string{"see https://spam.com/x", "www.spam.com", "http://cheap.SPAM.com"} {
+			if _, _, err := runFilter(f, "alice", content); err != ErrBlockedLink {
+				t.Errorf("wanted %v for %q, but got %v", ErrBlockedLink, content, err)
+			}
+		}
+		for _, content := range []string{"https://notspam.com", "spam.com is bad", "https://example.com/?spam.com"} {
+			if _, _, err := runFilter(f, "alice", content); err != nil {
+				t.Errorf("%q should be fine, but got %v", content, err)
+			}
+		}
+	})
+
+	t.Run("flood", func(t *testing.T) {
+		f := newFilterForTesting(t, "flood", FilterConfig{"Repeats": "2"})
+		for i := 0; i < 2; i++ {
+			if _, _, err := runFilter(f, "alice", "buy now"); err != nil {
+				t.Fatalf("message %v should be fine, but got %v", i, err)
+			}
+		}
+		if _, _, err := runFilter(f, "alice", "BUY NOW"); err != ErrRepeatedMessage {
+			t.Errorf("wanted %v, but got %v", ErrRepeatedMessage, err)
+		}
+		if _, _, err := runFilter(f, "bob", "buy now"); err != nil {
+			t.Errorf("another user should be fine, but got %v", err)
+		}
+
+		// fixing a typo twice is no flood
+		for i := 0; i < 3; i++ {
+			edit := NewMessage(&User{Name: "bob"}, MsgTypeNormal, "buy now")
+			edit.Ref = "some message"
+			if _, err := f.Filter(edit); err != nil {
+				t.Fatalf("edit %v should be fine, but got %v", i, err)
+			}
+		}
+		if _, _, err := runFilter(f, "bob", "buy now"); err != nil {
+			t.Errorf("the edits should not count as sends, but got %v", err)
+		}
+
+		short := newFilterForTesting(t, "flood", FilterConfig{"Repeats": "1", "Window": "20ms"})
+		runFilter(short, "carol", "hi")
+		time.Sleep(30 * time.Millisecond)
+		if _, _, err := runFilter(short, "carol", "hi"); err != nil {
+			t.Errorf("the window is over, but got %v", err)
+		}
+	})
+
+	t.Run("bad config", func(t *testing.T) {
+		for name, config := range map[string]FilterConfig{
+			"censor": {"Action": "shout"},
+			"length": {"Max": "many"},
+			"flood":  {"Window": "soon"},
+		} {
+			if _, err := FilterTypes.New(name, config); err == nil {
+				t.Errorf("wanted an error for %v %v", name, config)
+			}
+		}
+		if _, err := FilterTypes.New("magic", nil); !errors.Is(err, ErrUnknownFilter) {
+			t.Errorf("wanted %v, but got %v", ErrUnknownFilter, err)
+		}
+		if err := FilterTypes.Register("length", newLengthFilter); err != ErrFilterExists {
+			t.Errorf("wanted %v, but got %v", ErrFilterExists, err)
+		}
+	})
+}
+
+func TestFilterChain(t *testing.T) {
+	defer clearUserListForTesting()
+	defer ClearUserMsgProcessorForTesting()
+	ClearUserMsgProcessorForTesting()
+	defer func() { Broadcaster.filters = nil }()
+
+	// a filter from outside the package
+	shout := FilterFunc(func(msg *Message) (string, error) {
+		if strings.Contains(msg.Content, "!!!") {
+			return "too loud", nil
+		}
+		return "", nil
+	})
+	Broadcaster.filters = nil
+	Broadcaster.UseFilter("censor", newFilterForTesting(t, "censor", FilterConfig{"Words": "darn"}))
+	Broadcaster.UseFilter("length", newFilterForTesting(t, "length", FilterConfig{"Max": "20"}))
+	Broadcaster.UseFilter("shout", shout)
+
+	alice := &User{ID: 9201, Name: "testing_alice", Room: "filter_room", MessageChannel: make(chan *Message, 32)}
+	mod := &User{ID: 9202, Name: "testing_mod", Role: RoleModerator, Room: "filter_room", MessageChannel: make(chan *Message, 32)}
+	Broadcaster.UserLogin(alice)
+	Broadcaster.UserLogin(mod)
+	time.Sleep(50 * time.Millisecond)
+	drainMessages(mod)
+
+	alice.Say("", "darn it")
+	alice.Say("", "this message is far too long to send")
+	alice.Say("", "hello!!!")
+	time.Sleep(50 * time.Millisecond)
+
+	got := contents(drainMessages(mod))
+	want := []string{"**** it", "hello!!!"}
+	var flagged bool
+	var chat []string
+	for _, content := range got {
+		if strings.Contains(content, "was flagged: shout: too loud") {
+			flagged = true
+		} else {
+			chat = append(chat, content)
+		}
+	}
+	if strings.Join(chat, "|") != strings.Join(want, "|") {
+		t.Errorf("wanted %v in the room, but got %v", want, chat)
+	}
+	if !flagged {
+		t.Errorf("the moderator should have been told about the loud message, but got %v", got)
+	}
+	if errs := lastOfType(drainMessages(alice), MsgTypeError); !strings.Contains(errs, "too long") {
+		t.Errorf("alice should have been told her message was too long, but got %q", errs)
+	}
+
+	// an edit cannot get around the filters
+	msg := alice.Say("", "hi")
+	time.Sleep(50 * time.Millisecond)
+	if err := Broadcaster.EditMessage(alice, msg.ID, "darn"); err != nil {
+		t.Fatalf("failed to edit: %v", err)
+	}
+	if stored, _ := UserMessageProcessor.Get(msg.ID); stored.Content != "****" {
+		t.Errorf("wanted the edit masked, but got %q", stored.Content)
+	}
+}
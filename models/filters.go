@@ -0,0 +1,242 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	ErrCensored        = errors.New("message contains blocked words")
+	ErrBlockedLink     = errors.New("message links to a blocked site")
+	ErrRepeatedMessage = errors.New("stop repeating yourself")
+)
+
+var linkRegexp = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"]+`)
+
+// the built-in filters
+func init() {
+	for name, factory := range map[string]FilterFactory{
+		"censor": newCensorFilter,
+		"length": newLengthFilter,
+		"links":  newLinkFilter,
+		"flood":  newFloodFilter,
+	} {
+		if err := FilterTypes.Register(name, factory); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// newCensorFilter masks, rejects or flags the whole words of the Words list, whatever their case.
+// A word of a script written without spaces, like Chinese or Thai, is found anywhere.
+func newCensorFilter(config FilterConfig) (Filter, error) {
+	action, err := config.Action(FilterMask, FilterMask, FilterReject, FilterFlag)
+	if err != nil {
+		return nil, err
+	}
+
+	words := config.Strings("Words")
+	if len(words) == 0 {
+		return FilterFunc(func(*Message) (string, error) { return "", nil }), nil
+	}
+
+	// of two words starting at the same place the longer one is tried first
+	slices.SortFunc(words, func(a, b string) int { return len(b) - len(a) })
+	for i, word := range words {
+		words[i] = regexp.QuoteMeta(word)
+	}
+	re := regexp.MustCompile(`(?i)(?:` + strings.Join(words, "|") + `)`)
+
+	return FilterFunc(func(msg *Message) (string, error) {
+		found := findWords(re, msg.Content)
+		if len(found) == 0 {
+			return "", nil
+		}
+
+		switch action {
+		case FilterReject:
+			return "", ErrCensored
+		case FilterFlag:
+			return "contains " + msg.Content[found[0][0]:found[0][1]], nil
+		}
+
+		var sb strings.Builder
+		last := 0
+		for _, loc := range found {
+			sb.WriteString(msg.Content[last:loc[0]])
+			sb.WriteString(strings.Repeat("*", utf8.RuneCountInString(msg.Content[loc[0]:loc[1]])))
+			last = loc[1]
+		}
+		sb.WriteString(msg.Content[last:])
+		msg.Content = sb.String()
+		return "", nil
+	}), nil
+}
+
+// findWords returns where the matches of re in s are whole words.
+// RE2 only knows ASCII word boundaries, so they are checked here.
+func findWords(re *regexp.Regexp, s string) [][]int {
+	var found [][]int
+	for start := 0; start < len(s); {
+		loc := re.FindStringIndex(s[start:])
+		if loc == nil {
+			break
+		}
+		loc[0] += start
+		loc[1] += start
+
+		if loc[0] < loc[1] && wordBoundary(s, loc[0]) && wordBoundary(s, loc[1]) {
+			found = append(found, loc)
+			start = loc[1]
+			continue
+		}
+
+		// a match inside a word, look again from the next character
+		_, size := utf8.DecodeRuneInString(s[loc[0]:])
+		start = loc[0] + size
+	}
+	return found
+}
+
+// wordBoundary reports whether a word may start or end at the byte i of s
+func wordBoundary(s string, i int) bool {
+	if i == 0 || i == len(s) {
+		return true
+	}
+
+	before, _ := utf8.DecodeLastRuneInString(s[:i])
+	after, _ := utf8.DecodeRuneInString(s[i:])
+	return !wordRune(before) || !wordRune(after) || unspaced(before) || unspaced(after)
+}
+
+func wordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
+
+// unspaced reports whether the script of r is written without spaces between the words
+func unspaced(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana,
+		unicode.Thai, unicode.Lao, unicode.Khmer, unicode.Myanmar)
+}
+
+// newLengthFilter rejects the messages of more than Max characters
+func newLengthFilter(config FilterConfig) (Filter, error) {
+	max, err := config.Int("Max", 2000)
+	if err != nil {
+		return nil, err
+	}
+	tooLong := fmt.Errorf("message too long, the most is %d characters", max)
+
+	return FilterFunc(func(msg *Message) (string, error) {
+		if utf8.RuneCountInString(msg.Content) > max {
+			return "", tooLong
+		}
+		return "", nil
+	}), nil
+}
+
+// newLinkFilter rejects or flags the links to the Blocked sites and their subdomains
+func newLinkFilter(config FilterConfig) (Filter, error) {
+	action, err := config.Action(FilterReject, FilterReject, FilterFlag)
+	if err != nil {
+		return nil, err
+	}
+
+	blocked := config.Strings("Blocked")
+	for i, site := range blocked {
+		blocked[i] = strings.ToLower(site)
+	}
+
+	return FilterFunc(func(msg *Message) (string, error) {
+		for _, link := range linkRegexp.FindAllString(msg.Content, -1) {
+			if !strings.Contains(link, "://") {
+				link = "http://" + link
+			}
+			u, err := url.Parse(link)
+			if err != nil {
+				continue
+			}
+
+			host := strings.ToLower(u.Hostname())
+			for _, site := range blocked {
+				if host != site && !strings.HasSuffix(host, "."+site) {
+					continue
+				}
+				if action == FilterFlag {
+					return "links to " + site, nil
+				}
+				return "", ErrBlockedLink
+			}
+		}
+		return "", nil
+	}), nil
+}
+
+type sentMessage struct {
+	content string
+	at      time.Time
+}
+
+// newFloodFilter rejects a message sent Repeats times already by the user within the Window,
+// edits are not sends and go through
+func newFloodFilter(config FilterConfig) (Filter, error) {
+	repeats, err := config.Int("Repeats", 3)
+	if err != nil {
+		return nil, err
+	}
+	window, err := config.Duration("Window", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	// only the broadcaster runs the filters, the history needs no lock
+	history := make(map[string][]sentMessage)
+	swept := time.Now()
+
+	return FilterFunc(func(msg *Message) (string, error) {
+		if msg.Ref != "" {
+			return "", nil
+		}
+
+		// about once a window forget the users who have sent nothing in it
+		now := time.Now()
+		if now.Sub(swept) > window {
+			for name, sent := range history {
+				if now.Sub(sent[len(sent)-1].at) > window {
+					delete(history, name)
+				}
+			}
+			swept = now
+		}
+
+		sent := history[msg.User.Name]
+		for len(sent) > 0 && now.Sub(sent[0].at) > window {
+			sent = sent[1:]
+		}
+
+		same := 0
+		for _, m := range sent {
+			if strings.EqualFold(m.content, msg.Content) {
+				same++
+			}
+		}
+		if same >= repeats {
+			if len(sent) == 0 {
+				delete(history, msg.User.Name)
+			} else {
+				history[msg.User.Name] = sent
+			}
+			return "", ErrRepeatedMessage
+		}
+
+		history[msg.User.Name] = append(sent, sentMessage{content: msg.Content, at: now})
+		return "", nil
+	}), nil
+}
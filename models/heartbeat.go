@@ -0,0 +1,46 @@
+package models
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/fyerfyer/chatroom/pkg/setting"
+)
+
+// Heartbeat pings the client every setting.PingInterval until ctx is done.
+// A client that does not answer within setting.IdleTimeout is treated as
+// dead and its connection is closed, which ends FetchMessage so the user
+// is logged out the normal way.
+func (u *User) Heartbeat(ctx context.Context) {
+	if setting.PingInterval <= 0 || u.conn == nil {
+		return
+	}
+
+	ticker := time.NewTicker(setting.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, setting.IdleTimeout)
+		err := u.conn.Ping(pingCtx)
+		cancel()
+		if err == nil {
+			continue
+		}
+
+		// the session ended while we were waiting for the pong
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.Printf("%s missed the pong, closing the connection: %v", u.Name, err)
+		u.conn.CloseNow()
+		return
+	}
+}
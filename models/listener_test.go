@@ -0,0 +1,45 @@
+package models
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestListeners(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events []string
+	)
+
+	b := newBroadcast()
+	b.SetStore(newUserMessageProcessor())
+	b.AddListener(func(event *Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event.Type)
+	})
+	go b.Start()
+
+	user := &User{ID: 1, Name: "testing_user1", MessageChannel: make(chan *Message, 32)}
+	b.UserLogin(user)
+	b.Broadcast(NewMessage(user, MsgTypeNormal, "hello"))
+	b.Mute(user.Name, time.Minute)
+	b.Kick(user.Name, "testing")
+	time.Sleep(50 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// the kick logs the user out before it is reported
+	want := []string{EventLogin, EventMessage, EventMute, EventLogout, EventKick}
+	if len(events) != len(want) {
+		t.Fatalf("wanted events %v, but got %v", want, events)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("wanted events %v, but got %v", want, events)
+			break
+		}
+	}
+}
@@ -13,20 +13,62 @@ const (
 	MsgTypeUserLogout
 	MsgTypeError
 	MsgTypeUserList
+	MsgTypePrivate
+	MsgTypeSession
+	MsgTypeSystem
+	MsgTypeTyping
+	MsgTypePresence
+	MsgTypeEdit
+	MsgTypeDelete
+	MsgTypeReact
+	MsgTypeUnreact
+	MsgTypeThread
+	MsgTypeRead
+	MsgTypeUnread
+	MsgTypeGap
 )
 
 type Message struct {
+	// ID is the same on every node, Seq is the position in this node's store
+	ID      string `json:"id,omitempty"`
+	Seq     uint64 `json:"seq,omitempty"`
 	User    *User  `json:"from_user"`
 	Type    int    `json:"type"`
 	Content string `json:"content"`
+	Room    string `json:"room,omitempty"`
+	To      string `json:"to,omitempty"`
 
 	CreatedAt time.Time `json:"created_at"`
 	Ats       []string  `json:"ats"`
+
+	// Ref is the ID of the message an edit or a delete applies to
+	Ref string `json:"ref,omitempty"`
+	// an edited message keeps its ID, a deleted one is kept as an empty tombstone
+	EditedAt time.Time `json:"edited_at,omitempty"`
+	Deleted  bool      `json:"deleted,omitempty"`
+
+	// Reactions are in the order the emojis were first used
+	Reactions []Reaction `json:"reactions,omitempty"`
+
+	// ParentID is the message a reply belongs to, Thread is kept on that message
+	ParentID string  `json:"parent_id,omitempty"`
+	Thread   *Thread `json:"thread,omitempty"`
+
+	Attachments []Attachment `json:"attachments,omitempty"`
+
+	// ReadSeq is how far the sender of a read receipt has read the room,
+	// Unread counts the unread messages of each room
+	ReadSeq uint64         `json:"read_seq,omitempty"`
+	Unread  map[string]int `json:"unread,omitempty"`
+
+	// Missed is the first and last sequence number of the messages a resumed session lost
+	Missed []uint64 `json:"missed,omitempty"`
 }
 
 func NewMessage(user *User, msgType int, content string) *Message {
 	msg := &Message{
-		User:      user,
+		ID:        newID(),
+		User:      user.snapshot(),
 		Type:      msgType,
 		Content:   content,
 		CreatedAt: time.Now(),
@@ -35,22 +77,32 @@ func NewMessage(user *User, msgType int, content string) *Message {
 	return msg
 }
 
+func NewPrivateMsg(user *User, to, content string) *Message {
+	msg := NewMessage(user, MsgTypePrivate, content)
+	msg.To = to
+	return msg
+}
+
 func NewWelcomeMsg(user *User) *Message {
 	return NewMessage(user,
 		MsgTypeWelcome,
 		fmt.Sprintf("hello: %s ,welcome to the chatroom!", user.Name))
 }
 
-func NewLoginMsg(user *User) *Message {
-	return NewMessage(user,
+func NewLoginMsg(user *User, room string) *Message {
+	msg := NewMessage(user,
 		MsgTypeUserLogin,
-		fmt.Sprintf("%s has entered the chatroom!", user.Name))
+		fmt.Sprintf("%s has entered the room %s!", user.Name, room))
+	msg.Room = room
+	return msg
 }
 
-func NewLogoutMsg(user *User) *Message {
-	return NewMessage(user,
+func NewLogoutMsg(user *User, room string) *Message {
+	msg := NewMessage(user,
 		MsgTypeUserLogout,
-		fmt.Sprintf("%s has exited the chatroom!", user.Name))
+		fmt.Sprintf("%s has exited the room %s!", user.Name, room))
+	msg.Room = room
+	return msg
 }
 
 func NewErrorMsg(content string) *Message {
@@ -59,6 +111,91 @@ func NewErrorMsg(content string) *Message {
 		content)
 }
 
+// NewSessionMsg carries the token a client resumes its session with
+func NewSessionMsg(token string) *Message {
+	return NewMessage(System,
+		MsgTypeSession,
+		token)
+}
+
+// NewSystemMsg is a notice from the server to everyone online
+func NewSystemMsg(content string) *Message {
+	return NewMessage(System,
+		MsgTypeSystem,
+		content)
+}
+
+// NewTypingMsg tells that the user is typing, it is never stored
+func NewTypingMsg(user *User) *Message {
+	return NewMessage(user, MsgTypeTyping, "")
+}
+
+// NewPresenceMsg tells everyone the presence of the user, it is only built on the broadcaster
+func NewPresenceMsg(user *User, presence string) *Message {
+	msg := NewMessage(user, MsgTypePresence, presence)
+	msg.User.Presence = presence
+	return msg
+}
+
+// NewEditMsg tells the users showing a message that it was edited
+func NewEditMsg(user *User, edited *Message) *Message {
+	msg := NewMessage(user, MsgTypeEdit, edited.Content)
+	msg.Ref = edited.ID
+	msg.Room = edited.Room
+	msg.Ats = edited.Ats
+	return msg
+}
+
+// NewDeleteMsg tells the users showing a message that it was deleted
+func NewDeleteMsg(user *User, deleted *Message) *Message {
+	msg := NewMessage(user, MsgTypeDelete, "")
+	msg.Ref = deleted.ID
+	msg.Room = deleted.Room
+	return msg
+}
+
+// NewReactMsg tells the users showing a message that a reaction was added or removed,
+// it carries the reactions of the message after the change
+func NewReactMsg(user *User, reacted *Message, emoji string, msgType int) *Message {
+	msg := NewMessage(user, msgType, emoji)
+	msg.Ref = reacted.ID
+	msg.Room = reacted.Room
+	msg.Reactions = reacted.Reactions
+	return msg
+}
+
+// NewThreadMsg tells the users showing a message that its thread changed
+func NewThreadMsg(root *Message) *Message {
+	msg := NewMessage(System, MsgTypeThread, "")
+	msg.Ref = root.ID
+	msg.Room = root.Room
+	msg.Thread = root.Thread
+	return msg
+}
+
+// NewReadMsg tells a room that the user has read it up to the message with the sequence number
+func NewReadMsg(user *User, room string, seq uint64) *Message {
+	msg := NewMessage(user, MsgTypeRead, "")
+	msg.Room = room
+	msg.ReadSeq = seq
+	return msg
+}
+
+// NewUnreadMsg tells a user how many messages it has not read in each room
+func NewUnreadMsg(unread map[string]int) *Message {
+	msg := NewMessage(System, MsgTypeUnread, "")
+	msg.Unread = unread
+	return msg
+}
+
+// NewGapMsg tells a resumed session that the messages from first to last are no longer
+// kept to be replayed, the client has to load the history again
+func NewGapMsg(first, last uint64) *Message {
+	msg := NewMessage(System, MsgTypeGap, "some messages were lost, load the history again")
+	msg.Missed = []uint64{first, last}
+	return msg
+}
+
 func NewUserListMessage(users []*User) *Message {
 	userNames := make([]string, 0, len(users))
 	for _, user := range users {
@@ -0,0 +1,241 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/fyerfyer/chatroom/pkg/setting"
+	"github.com/fyerfyer/chatroom/pkg/utils"
+)
+
+const (
+	StoreMemory = "memory"
+	StoreFile   = "file"
+)
+
+var ErrMessageNotFound = errors.New("message not found")
+
+// MessageStore keeps the chat history and the per-user inboxes
+type MessageStore interface {
+	// Append stores the message and assigns its sequence number
+	Append(msg *Message) error
+	// Range returns the stored messages matching the query in sequence order
+	Range(q Query) ([]*Message, error)
+	// Count returns how many messages of the room not deleted come after
+	// the sequence number, only the ones sent from the account from if it is set
+	Count(room, from string, afterSeq uint64) (int, error)
+	// Get returns the stored message with the ID
+	Get(id string) (*Message, error)
+	// Update replaces the stored message of the same sequence number,
+	// and its copies waiting in the inboxes
+	Update(msg *Message) error
+	// PushInbox keeps the message for the user until the inbox is popped
+	PushInbox(name string, msg *Message) error
+	// PopInbox returns and clears the user's inbox
+	PopInbox(name string) ([]*Message, error)
+	Close() error
+}
+
+// Query selects stored messages, zero fields are ignored
+type Query struct {
+	AfterSeq  uint64
+	BeforeSeq uint64
+	Since     time.Time
+	Until     time.Time
+	Room      string
+	// Rooms selects the messages of any of the rooms
+	Rooms []string
+	From  string
+	// Parent selects the replies of a thread
+	Parent string
+
+	// Limit caps the number of messages, the oldest ones are kept
+	// unless Latest is set
+	Limit  int
+	Latest bool
+}
+
+func (q *Query) match(msg *Message) bool {
+	if q.AfterSeq != 0 && msg.Seq <= q.AfterSeq {
+		return false
+	}
+	if q.BeforeSeq != 0 && msg.Seq >= q.BeforeSeq {
+		return false
+	}
+	if !q.Since.IsZero() && msg.CreatedAt.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !msg.CreatedAt.Before(q.Until) {
+		return false
+	}
+	if q.Room != "" && msg.Room != q.Room {
+		return false
+	}
+	if len(q.Rooms) > 0 && !slices.Contains(q.Rooms, msg.Room) {
+		return false
+	}
+	if q.From != "" && (msg.User == nil || msg.User.Name != q.From) {
+		return false
+	}
+	if q.Parent != "" && msg.ParentID != q.Parent {
+		return false
+	}
+
+	return true
+}
+
+// full reports whether a scan can stop after n matches
+func (q *Query) full(n int) bool {
+	return q.Limit > 0 && n >= q.Limit
+}
+
+// seqIndex keeps the sequence numbers of the messages not deleted of every room,
+// and of every sender in it, in order, so counting them is a binary search
+type seqIndex struct {
+	rooms   map[string][]uint64
+	senders map[[2]string][]uint64
+}
+
+func newSeqIndex() *seqIndex {
+	return &seqIndex{
+		rooms:   make(map[string][]uint64),
+		senders: make(map[[2]string][]uint64),
+	}
+}
+
+// set indexes the message, or drops it once it is deleted
+func (x *seqIndex) set(room, sender string, seq uint64, deleted bool) {
+	if deleted {
+		x.remove(room, sender, seq)
+		return
+	}
+
+	x.rooms[room] = insertSeq(x.rooms[room], seq)
+	if sender != "" {
+		x.senders[[2]string{room, sender}] = insertSeq(x.senders[[2]string{room, sender}], seq)
+	}
+}
+
+func (x *seqIndex) remove(room, sender string, seq uint64) {
+	if seqs := removeSeq(x.rooms[room], seq); len(seqs) > 0 {
+		x.rooms[room] = seqs
+	} else {
+		delete(x.rooms, room)
+	}
+
+	key := [2]string{room, sender}
+	if seqs := removeSeq(x.senders[key], seq); len(seqs) > 0 {
+		x.senders[key] = seqs
+	} else {
+		delete(x.senders, key)
+	}
+}
+
+func (x *seqIndex) count(room, from string, afterSeq uint64) int {
+	seqs := x.rooms[room]
+	if from != "" {
+		seqs = x.senders[[2]string{room, from}]
+	}
+
+	i, _ := slices.BinarySearch(seqs, afterSeq+1)
+	return len(seqs) - i
+}
+
+// insertSeq adds seq in order, new messages go at the end
+func insertSeq(seqs []uint64, seq uint64) []uint64 {
+	if n := len(seqs); n == 0 || seqs[n-1] < seq {
+		return append(seqs, seq)
+	}
+
+	i, found := slices.BinarySearch(seqs, seq)
+	if found {
+		return seqs
+	}
+	return slices.Insert(seqs, i, seq)
+}
+
+// removeSeq drops seq, the oldest ones go first in the memory store
+func removeSeq(seqs []uint64, seq uint64) []uint64 {
+	i, found := slices.BinarySearch(seqs, seq)
+	switch {
+	case !found:
+		return seqs
+	case i == 0:
+		return seqs[1:]
+	default:
+		return slices.Delete(seqs, i, i+1)
+	}
+}
+
+// senderOf returns the account the message was sent from, if any,
+// so the messages sent before and after a rename count as the same sender
+func senderOf(msg *Message) string {
+	if msg.User == nil {
+		return ""
+	}
+	return msg.User.account()
+}
+
+// NewMessageStore opens the store configured by typ,
+// a relative path is resolved against the project root
+func NewMessageStore(typ, path string) (MessageStore, error) {
+	switch typ {
+	case "", StoreMemory:
+		return newUserMessageProcessor(), nil
+	case StoreFile:
+		return NewFileStore(utils.RootPath(path))
+	default:
+		return nil, fmt.Errorf("unknown message store: %v", typ)
+	}
+}
+
+// saveMessage persists a chat message and files the '@' mentions
+// into the inboxes of the mentioned users
+func saveMessage(store MessageStore, msg *Message) error {
+	if msg.Type != MsgTypeNormal {
+		return nil
+	}
+
+	if err := store.Append(msg); err != nil {
+		return err
+	}
+
+	for _, name := range msg.Ats {
+		if err := store.PushInbox(name[1:], msg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pendingMessages returns the recent messages of the user's room the user has
+// not read, all of them if it never read any, and, if the user is online,
+// pops the messages kept in the inbox
+func pendingMessages(store MessageStore, user *User) ([]*Message, error) {
+	room := roomName(user.Room)
+	msgs, err := store.Range(Query{
+		Room:     room,
+		AfterSeq: ReadMarkers.Get(user.account(), room),
+		Limit:    setting.OfflineMsgNum,
+		Latest:   true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// if user is offline
+	// there's no need to send the @ message to it
+	if !user.IsOnline {
+		return msgs, nil
+	}
+
+	inbox, err := store.PopInbox(user.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(msgs, inbox...), nil
+}
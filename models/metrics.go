@@ -0,0 +1,75 @@
+package models
+
+import (
+	"github.com/fyerfyer/chatroom/pkg/metrics"
+)
+
+var msgTypeNames = map[int]string{
+	MsgTypeNormal:     "normal",
+	MsgTypeWelcome:    "welcome",
+	MsgTypeUserLogin:  "login",
+	MsgTypeUserLogout: "logout",
+	MsgTypeError:      "error",
+	MsgTypeUserList:   "user_list",
+	MsgTypePrivate:    "private",
+	MsgTypeSession:    "session",
+	MsgTypeSystem:     "system",
+	MsgTypeTyping:     "typing",
+	MsgTypePresence:   "presence",
+	MsgTypeEdit:       "edit",
+	MsgTypeDelete:     "delete",
+	MsgTypeReact:      "react",
+	MsgTypeUnreact:    "unreact",
+	MsgTypeThread:     "thread",
+	MsgTypeRead:       "read",
+	MsgTypeUnread:     "unread",
+	MsgTypeGap:        "gap",
+}
+
+func msgTypeName(msgType int) string {
+	if name, ok := msgTypeNames[msgType]; ok {
+		return name
+	}
+
+	return "unknown"
+}
+
+var (
+	onlineUsers = metrics.NewGauge("chatroom_online_users",
+		"Users logged in on this node.")
+
+	messagesBroadcast = metrics.NewCounterVec("chatroom_messages_broadcast_total",
+		"Messages dispatched by the broadcaster, by type.", "type")
+
+	messagesFiltered = metrics.NewCounterVec("chatroom_messages_filtered_total",
+		"Messages rejected or flagged by the filters, by filter.", "filter")
+
+	broadcastDropped = metrics.NewCounter("chatroom_broadcast_dropped_total",
+		"Messages dropped because the broadcast queue was full.")
+
+	connectionDuration = metrics.NewHistogram("chatroom_connection_duration_seconds",
+		"How long the websocket connections stayed open.",
+		metrics.ExponentialBuckets(1, 4, 9))
+
+	_ = metrics.NewGaugeFunc("chatroom_broadcast_queue_length",
+		"Messages waiting in the broadcast queue.",
+		func() float64 {
+			return float64(len(Broadcaster.messageChannel))
+		})
+
+	_ = metrics.NewCounterVecFunc("chatroom_dropped_messages_total",
+		"Messages dropped for slow consumers, by overflow policy.", "policy",
+		func() map[string]float64 {
+			dropped := make(map[string]float64)
+			for policy, n := range Broadcaster.DroppedMessages() {
+				dropped[policy] = float64(n)
+			}
+			return dropped
+		})
+
+	_ = metrics.NewGaugeFunc("chatroom_user_queue_high_water",
+		"The longest the message queue of any user online on this node has been.",
+		func() float64 {
+			return float64(Broadcaster.QueueHighWater())
+		})
+)
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBroadcastMetrics(t *testing.T) {
+	defer clearUserListForTesting()
+	defer ClearUserMsgProcessorForTesting()
+	time.Sleep(50 * time.Millisecond)
+
+	user := &User{ID: 1, Name: "testing_user1", MessageChannel: make(chan *Message, 32)}
+	Broadcaster.UserLogin(user)
+	if online := onlineUsers.Value(); online != 1 {
+		t.Errorf("wanted 1 user online, but got %v", online)
+	}
+
+	before := messagesBroadcast.Value("normal")
+	Broadcaster.Broadcast(NewMessage(user, MsgTypeNormal, "counted"))
+	time.Sleep(50 * time.Millisecond)
+
+	if got := messagesBroadcast.Value("normal") - before; got != 1 {
+		t.Errorf("wanted 1 more normal message, but got %v", got)
+	}
+
+	// the invisible users have queues as well
+	Broadcaster.SetPresence(user, PresenceInvisible)
+	user.queueHighWater.Store(7)
+	if got := Broadcaster.QueueHighWater(); got != 7 {
+		t.Errorf("wanted the longest queue of 7, but got %v", got)
+	}
+
+	Broadcaster.UserLogout(user)
+	if online := onlineUsers.Value(); online != 0 {
+		t.Errorf("wanted no user online, but got %v", online)
+	}
+}
@@ -0,0 +1,213 @@
+package models
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/fyerfyer/chatroom/pkg/utils"
+	"nhooyr.io/websocket"
+)
+
+// the roles of the users, each one can do what the ones below it can
+const (
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+	RoleMember    = "member"
+)
+
+// StatusKicked closes the connection of a kicked or banned user
+const StatusKicked websocket.StatusCode = 4001
+
+var (
+	ErrInvalidRole      = errors.New("invalid role")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrMuted            = errors.New("you are muted")
+)
+
+func validRole(role string) bool {
+	switch role {
+	case RoleAdmin, RoleModerator, RoleMember:
+		return true
+	default:
+		return false
+	}
+}
+
+func roleRank(role string) int {
+	switch role {
+	case RoleAdmin:
+		return 3
+	case RoleModerator:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// HasRole reports whether the role is at least the required one
+func HasRole(role, required string) bool {
+	return roleRank(role) >= roleRank(required)
+}
+
+// CanModerate reports whether a user with the role may kick, ban or mute
+// a user with the target role, nobody can moderate their equals
+func CanModerate(role, target string) bool {
+	return HasRole(role, RoleModerator) && roleRank(role) > roleRank(target)
+}
+
+// Muted reports whether the frames of the user are rejected
+func (u *User) Muted() bool {
+	until := u.mutedUntil.Load()
+	return until == mutedForever || until != 0 && time.Now().UnixNano() < until
+}
+
+// kick logs the user of the account out and closes the connection,
+// it reports whether the user was on this node
+func (b *broadcast) kick(account, reason string) bool {
+	user, ok := b.userOf(account)
+	if !ok {
+		return false
+	}
+
+	name := user.Name
+	if session, ok := b.detached[account]; ok {
+		session.timer.Stop()
+		delete(b.detached, account)
+		b.logout(session.user)
+		b.emit(&Event{Type: EventKick, User: account, Reason: reason})
+		return true
+	}
+
+	b.deliver(user, NewErrorMsg("you have been kicked: "+reason))
+	user.evicted = true
+	b.logout(user)
+	if user.conn != nil {
+		go user.conn.Close(StatusKicked, reason)
+	}
+
+	b.emit(&Event{Type: EventKick, User: account, Reason: reason})
+	log.Printf("%s has been kicked: %s", name, reason)
+	return true
+}
+
+// kickAddr kicks every user of this node connected from the address
+func (b *broadcast) kickAddr(addr, reason string) {
+	for _, user := range b.users {
+		if utils.HostOf(user.Addr) == addr {
+			b.kick(user.account(), reason)
+		}
+	}
+}
+
+// mute rejects the frames of the user of the account until the time, or forever if it is zero
+func (b *broadcast) mute(account string, until time.Time) {
+	b.mutes[account] = until
+	if user, ok := b.userOf(account); ok {
+		user.mutedUntil.Store(mutedUntil(until))
+	}
+}
+
+func (b *broadcast) unmute(account string) {
+	delete(b.mutes, account)
+	if user, ok := b.userOf(account); ok {
+		user.mutedUntil.Store(0)
+	}
+}
+
+// applyMute keeps a user muted across reconnects and renames
+func (b *broadcast) applyMute(user *User) {
+	until, ok := b.mutes[user.account()]
+	if !ok {
+		return
+	}
+
+	if !until.IsZero() && !time.Now().Before(until) {
+		delete(b.mutes, user.account())
+		return
+	}
+	user.mutedUntil.Store(mutedUntil(until))
+}
+
+// a user is muted forever with a negative time
+const mutedForever = -1
+
+func mutedUntil(until time.Time) int64 {
+	if until.IsZero() {
+		return mutedForever
+	}
+
+	return until.UnixNano()
+}
+
+// accountOf returns the account of the user going by the name, here or on another node,
+// a name nobody goes by is taken for an account
+func (b *broadcast) accountOf(name string) string {
+	if user, ok := b.users[name]; ok {
+		return user.account()
+	}
+	for _, users := range b.remote {
+		if user, ok := users[name]; ok {
+			return user.account()
+		}
+	}
+	return name
+}
+
+// AccountOf returns the account of the user going by the name,
+// the roles, bans, kicks and mutes all go by account
+func (b *broadcast) AccountOf(name string) string {
+	account, _ := b.do(broadcastOp{typ: OpAccountOf, user: &User{Name: name}}).(string)
+	return account
+}
+
+// Kick disconnects the user of the account wherever in the cluster it is logged in
+func (b *broadcast) Kick(account, reason string) error {
+	err, _ := b.do(broadcastOp{typ: OpKick, user: &User{Account: account}, reason: reason}).(error)
+	return err
+}
+
+// Mute rejects the frames of the user of the account for d, or forever if d is 0
+func (b *broadcast) Mute(account string, d time.Duration) {
+	var until time.Time
+	if d > 0 {
+		until = time.Now().Add(d)
+	}
+
+	b.do(broadcastOp{typ: OpMute, user: &User{Account: account}, until: until})
+}
+
+func (b *broadcast) Unmute(account string) {
+	b.do(broadcastOp{typ: OpUnmute, user: &User{Account: account}})
+}
+
+// Ban adds the ban for d, or forever if d is 0,
+// and kicks the users it applies to
+func (b *broadcast) Ban(ban *Ban, d time.Duration) (*Ban, error) {
+	ban, err := Bans.Add(ban, d)
+	if err != nil {
+		return nil, err
+	}
+
+	b.emit(&Event{
+		Type:   EventBan,
+		User:   ban.Name,
+		Addr:   ban.Addr,
+		Reason: ban.Reason,
+		Until:  ban.ExpiresAt,
+	})
+
+	reason := "banned"
+	if ban.Reason != "" {
+		reason += ": " + ban.Reason
+	}
+
+	if ban.Name != "" {
+		if err := b.Kick(ban.Name, reason); err != nil && !errors.Is(err, ErrUserOffline) {
+			return ban, err
+		}
+	} else {
+		b.do(broadcastOp{typ: OpKickAddr, addr: ban.Addr, reason: reason})
+	}
+	return ban, nil
+}
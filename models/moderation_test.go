@@ -0,0 +1,100 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCanModerate(t *testing.T) {
+	tests := []struct {
+		role, target string
+		want         bool
+	}{
+		{RoleAdmin, RoleModerator, true},
+		{RoleAdmin, RoleAdmin, false},
+		{RoleModerator, RoleMember, true},
+		{RoleModerator, RoleModerator, false},
+		{RoleModerator, RoleAdmin, false},
+		{RoleMember, RoleMember, false},
+		{"", RoleMember, false},
+	}
+
+	for _, test := range tests {
+		if got := CanModerate(test.role, test.target); got != test.want {
+			t.Errorf("CanModerate(%q, %q) should be %v", test.role, test.target, test.want)
+		}
+	}
+}
+
+func TestKick(t *testing.T) {
+	defer clearUserListForTesting()
+	time.Sleep(50 * time.Millisecond)
+
+	watcher := &User{ID: 1, Name: "testing_user1", MessageChannel: make(chan *Message, 32)}
+	user := &User{ID: 2, Name: "testing_user2", MessageChannel: make(chan *Message, 32)}
+	Broadcaster.UserLogin(watcher)
+	Broadcaster.UserLogin(user)
+	time.Sleep(50 * time.Millisecond)
+	drainMessages(watcher)
+
+	if err := Broadcaster.Kick(user.Name, "spamming"); err != nil {
+		t.Fatalf("failed to kick: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if Broadcaster.CheckUserCanLogout(user.Name) {
+		t.Errorf("%v should have been logged out", user.Name)
+	}
+
+	var notice *Message
+	for msg := range user.MessageChannel {
+		notice = msg
+	}
+	if notice == nil || notice.Type != MsgTypeError {
+		t.Errorf("%v should have been told about the kick, but got %v", user.Name, notice)
+	}
+
+	left := false
+	for _, msg := range drainMessages(watcher) {
+		left = left || msg.Type == MsgTypeUserLogout
+	}
+	if !left {
+		t.Errorf("%v should have seen %v leave", watcher.Name, user.Name)
+	}
+
+	if err := Broadcaster.Kick(user.Name, "again"); err != ErrUserOffline {
+		t.Errorf("kicking an offline user should fail, but got %v", err)
+	}
+}
+
+func TestMute(t *testing.T) {
+	defer clearUserListForTesting()
+
+	user := &User{ID: 1, Name: "testing_user1", MessageChannel: make(chan *Message, 32)}
+	Broadcaster.UserLogin(user)
+
+	Broadcaster.Mute(user.Name, 0)
+	if !user.Muted() {
+		t.Errorf("%v should be muted", user.Name)
+	}
+
+	// the mute outlives the connection
+	Broadcaster.UserLogout(user)
+	again := &User{ID: 2, Name: user.Name, MessageChannel: make(chan *Message, 32)}
+	Broadcaster.UserLogin(again)
+	if !again.Muted() {
+		t.Errorf("%v should still be muted after logging in again", user.Name)
+	}
+
+	Broadcaster.Unmute(user.Name)
+	if again.Muted() {
+		t.Errorf("%v should not be muted anymore", user.Name)
+	}
+
+	Broadcaster.Mute(user.Name, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	if again.Muted() {
+		t.Errorf("the mute of %v should have expired", user.Name)
+	}
+	Broadcaster.UserLogout(again)
+}
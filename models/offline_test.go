@@ -9,6 +9,9 @@ import (
 func ClearUserMsgProcessorForTesting() {
 	UserMessageProcessor.recentMsgDeque = list.New()
 	UserMessageProcessor.userMsgDeque = make(map[string]*list.List)
+	UserMessageProcessor.byID = make(map[string]*Message)
+	UserMessageProcessor.idDeque = list.New()
+	UserMessageProcessor.counts = newSeqIndex()
 }
 
 func TestOfflineSave(t *testing.T) {
@@ -91,3 +94,33 @@ func TestOfflineSend(t *testing.T) {
 		return
 	}
 }
+
+func TestOfflineGetOlderMessages(t *testing.T) {
+	defer ClearUserMsgProcessorForTesting()
+	ClearUserMsgProcessorForTesting()
+
+	store := newUserMessageProcessor()
+	store.maxIDs = store.maxMsgNum * 2
+	user := &User{Name: "testing_user1"}
+
+	var msgs []*Message
+	for i := 0; i < store.maxIDs+1; i++ {
+		msg := NewMessage(user, MsgTypeNormal, "testing message:"+strconv.Itoa(i))
+		store.Append(msg)
+		msgs = append(msgs, msg)
+	}
+
+	// past the replayed messages, but still found to be edited
+	older := *msgs[2]
+	older.Content = "edited"
+	if err := store.Update(&older); err != nil {
+		t.Fatalf("failed to update an older message: %v", err)
+	}
+	if got, err := store.Get(older.ID); err != nil || got.Content != "edited" {
+		t.Errorf("wanted the edited message, but got %v %v", got, err)
+	}
+
+	if _, err := store.Get(msgs[0].ID); err != ErrMessageNotFound {
+		t.Errorf("the oldest message should be forgotten, but got %v", err)
+	}
+}
@@ -2,16 +2,28 @@ package models
 
 import (
 	"container/list"
+	"log"
+	"sync"
 
 	"github.com/fyerfyer/chatroom/pkg/setting"
 )
 
+// userMessageProcessor is the in-memory MessageStore, it only keeps the
+// latest maxMsgNum messages and finds and counts the latest maxIDs ones
 type userMessageProcessor struct {
+	mu        sync.Mutex
 	maxMsgNum int
+	maxIDs    int
+	seq       uint64
 
 	// the front of the deque stores the oldest message
 	recentMsgDeque *list.List
 	userMsgDeque   map[string]*list.List
+
+	// the messages by ID, the front of the ID deque is the oldest
+	byID    map[string]*Message
+	idDeque *list.List
+	counts  *seqIndex
 }
 
 var UserMessageProcessor = newUserMessageProcessor()
@@ -19,59 +31,165 @@ var UserMessageProcessor = newUserMessageProcessor()
 func newUserMessageProcessor() *userMessageProcessor {
 	return &userMessageProcessor{
 		maxMsgNum:      setting.OfflineMsgNum,
+		maxIDs:         max(setting.MemoryStoreSize, setting.OfflineMsgNum),
 		recentMsgDeque: list.New(),
 		userMsgDeque:   make(map[string]*list.List),
+		byID:           make(map[string]*Message),
+		idDeque:        list.New(),
+		counts:         newSeqIndex(),
 	}
 }
 
 func (p *userMessageProcessor) Save(msg *Message) {
-	if msg.Type != MsgTypeNormal {
-		return
+	if err := saveMessage(p, msg); err != nil {
+		log.Printf("failed to save message: %v", err)
+	}
+}
+
+func (p *userMessageProcessor) Send(user *User) {
+	msgs, err := pendingMessages(p, user)
+	if err != nil {
+		log.Printf("failed to replay messages to %s: %v", user.Name, err)
 	}
 
+	for _, msg := range msgs {
+		user.MessageChannel <- msg
+	}
+}
+
+func (p *userMessageProcessor) Append(msg *Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.seq++
+	msg.Seq = p.seq
+
 	if p.recentMsgDeque.Len() >= p.maxMsgNum {
 		p.recentMsgDeque.Remove(p.recentMsgDeque.Front())
 	}
 	p.recentMsgDeque.PushBack(msg)
 
-	// deal with the '@' operations
-	for _, name := range msg.Ats {
-		name = name[1:]
-		var (
-			userMsg *list.List
-			ok      bool
-		)
-
-		if userMsg, ok = p.userMsgDeque[name]; !ok {
-			userMsg = list.New()
+	if p.idDeque.Len() >= p.maxIDs {
+		id := p.idDeque.Remove(p.idDeque.Front()).(string)
+		if old, ok := p.byID[id]; ok {
+			p.counts.remove(old.Room, senderOf(old), old.Seq)
+			delete(p.byID, id)
 		}
-		userMsg.PushBack(msg)
-		p.userMsgDeque[name] = userMsg
 	}
+	p.idDeque.PushBack(msg.ID)
+	p.byID[msg.ID] = msg
+	p.counts.set(msg.Room, senderOf(msg), msg.Seq, msg.Deleted)
+	return nil
 }
 
-func (p *userMessageProcessor) Send(user *User) {
-	// send the recent message to the user
-	for msg := p.recentMsgDeque.Front(); msg != nil; msg = msg.Next() {
-		if msg.Value != nil {
-			msgValue, _ := msg.Value.(*Message)
-			user.MessageChannel <- msgValue
+func (p *userMessageProcessor) Range(q Query) ([]*Message, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var msgs []*Message
+	if q.Latest {
+		for e := p.recentMsgDeque.Back(); e != nil && !q.full(len(msgs)); e = e.Prev() {
+			if msg, _ := e.Value.(*Message); msg != nil && q.match(msg) {
+				msgs = append(msgs, msg)
+			}
+		}
+
+		// keep the result in sequence order
+		for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+			msgs[i], msgs[j] = msgs[j], msgs[i]
+		}
+		return msgs, nil
+	}
+
+	for e := p.recentMsgDeque.Front(); e != nil && !q.full(len(msgs)); e = e.Next() {
+		if msg, _ := e.Value.(*Message); msg != nil && q.match(msg) {
+			msgs = append(msgs, msg)
 		}
 	}
+	return msgs, nil
+}
+
+func (p *userMessageProcessor) Count(room, from string, afterSeq uint64) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.counts.count(room, from, afterSeq), nil
+}
+
+func (p *userMessageProcessor) Get(id string) (*Message, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if msg, ok := p.byID[id]; ok {
+		return msg, nil
+	}
+	return nil, ErrMessageNotFound
+}
+
+// Update swaps the message in, the old one may still be read by whoever got it
+func (p *userMessageProcessor) Update(msg *Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-	// if user is offline
-	// there's no need to send the @ message to it
-	if !user.IsOnline {
-		return
+	old, ok := p.byID[msg.ID]
+	if !ok || old.Seq != msg.Seq {
+		return ErrMessageNotFound
 	}
+	p.byID[msg.ID] = msg
+	p.counts.set(msg.Room, senderOf(msg), msg.Seq, msg.Deleted)
 
-	userMsg, ok := p.userMsgDeque[user.Name]
-	if ok {
-		for msg := userMsg.Front(); msg != nil; msg = msg.Next() {
-			msgValue, _ := msg.Value.(*Message)
-			user.MessageChannel <- msgValue
+	for e := p.recentMsgDeque.Back(); e != nil; e = e.Prev() {
+		if old, _ := e.Value.(*Message); old != nil && old.Seq == msg.Seq {
+			e.Value = msg
+			break
 		}
+	}
 
-		delete(p.userMsgDeque, user.Name)
+	for _, inbox := range p.userMsgDeque {
+		for e := inbox.Front(); e != nil; e = e.Next() {
+			if old, _ := e.Value.(*Message); old != nil && old.ID == msg.ID {
+				e.Value = msg
+			}
+		}
 	}
+	return nil
+}
+
+func (p *userMessageProcessor) PushInbox(name string, msg *Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var (
+		userMsg *list.List
+		ok      bool
+	)
+
+	if userMsg, ok = p.userMsgDeque[name]; !ok {
+		userMsg = list.New()
+	}
+	userMsg.PushBack(msg)
+	p.userMsgDeque[name] = userMsg
+	return nil
+}
+
+func (p *userMessageProcessor) PopInbox(name string) ([]*Message, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	userMsg, ok := p.userMsgDeque[name]
+	if !ok {
+		return nil, nil
+	}
+
+	msgs := make([]*Message, 0, userMsg.Len())
+	for e := userMsg.Front(); e != nil; e = e.Next() {
+		msgValue, _ := e.Value.(*Message)
+		msgs = append(msgs, msgValue)
+	}
+	delete(p.userMsgDeque, name)
+	return msgs, nil
+}
+
+func (p *userMessageProcessor) Close() error {
+	return nil
 }
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"log"
+	"sync/atomic"
+
+	"github.com/fyerfyer/chatroom/pkg/setting"
+	"nhooyr.io/websocket"
+)
+
+// what to do when a user's message queue is full
+const (
+	OverflowDropOldest = "drop_oldest"
+	OverflowDropNewest = "drop_newest"
+	OverflowDisconnect = "disconnect"
+)
+
+// StatusSlowConsumer closes the connection of a user that cannot keep up
+const StatusSlowConsumer websocket.StatusCode = 4000
+
+type overflowStats struct {
+	dropOldest atomic.Uint64
+	dropNewest atomic.Uint64
+	disconnect atomic.Uint64
+}
+
+func validOverflowPolicy(policy string) bool {
+	switch policy {
+	case OverflowDropOldest, OverflowDropNewest, OverflowDisconnect:
+		return true
+	default:
+		return false
+	}
+}
+
+// overflowPolicy is the user's own policy or the configured one
+func (u *User) overflowPolicy() string {
+	if validOverflowPolicy(u.OverflowPolicy) {
+		return u.OverflowPolicy
+	}
+
+	return setting.OverflowPolicy
+}
+
+// deliver queues the message for the user without ever blocking the broadcaster,
+// when the queue is full the user's overflow policy decides what is lost
+func (b *broadcast) deliver(user *User, msg *Message) {
+	if user.evicted || user.detached {
+		return
+	}
+
+	select {
+	case user.MessageChannel <- msg:
+		return
+	default:
+	}
+
+	switch user.overflowPolicy() {
+	case OverflowDropOldest:
+		select {
+		case <-user.MessageChannel:
+		default:
+		}
+		b.overflow.dropOldest.Add(1)
+
+		// an unbuffered queue has no oldest message to make room,
+		// the new one is lost instead
+		select {
+		case user.MessageChannel <- msg:
+		default:
+		}
+
+	case OverflowDropNewest:
+		b.overflow.dropNewest.Add(1)
+
+	case OverflowDisconnect:
+		b.overflow.disconnect.Add(1)
+		user.evicted = true
+		log.Printf("disconnecting slow consumer: %s", user.Name)
+
+		// the normal logout path runs once the connection is closed
+		if user.conn != nil {
+			go user.conn.Close(StatusSlowConsumer, "message queue overflow")
+		}
+	}
+}
+
+// QueueHighWater returns the longest the message queue of any user of this node has been,
+// the invisible ones included
+func (b *broadcast) QueueHighWater() int64 {
+	highWater, _ := b.do(broadcastOp{typ: OpQueueHighWater}).(int64)
+	return highWater
+}
+
+// DroppedMessages returns how many messages each overflow policy dropped
+func (b *broadcast) DroppedMessages() map[string]uint64 {
+	return map[string]uint64{
+		OverflowDropOldest: b.overflow.dropOldest.Load(),
+		OverflowDropNewest: b.overflow.dropNewest.Load(),
+		OverflowDisconnect: b.overflow.disconnect.Load(),
+	}
+}
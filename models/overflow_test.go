@@ -0,0 +1,90 @@
+package models
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func fillQueueForTesting(policy string) (*User, *User) {
+	sender := &User{ID: 1, Name: "testing_user1", MessageChannel: make(chan *Message, 32)}
+	slow := &User{
+		ID:             2,
+		Name:           "testing_user2",
+		MessageChannel: make(chan *Message, 2),
+		OverflowPolicy: policy,
+	}
+	// let messages queued by other tests go through first
+	time.Sleep(50 * time.Millisecond)
+	loginUserWithoutSendingMessage(sender)
+	loginUserWithoutSendingMessage(slow)
+
+	for i := 0; i < 4; i++ {
+		Broadcaster.Broadcast(NewMessage(sender, MsgTypeNormal, "message:"+strconv.Itoa(i)))
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	return sender, slow
+}
+
+func contents(msgs []*Message) []string {
+	var res []string
+	for _, msg := range msgs {
+		res = append(res, msg.Content)
+	}
+	return res
+}
+
+func TestOverflowDropOldest(t *testing.T) {
+	defer clearUserListForTesting()
+	before := Broadcaster.DroppedMessages()[OverflowDropOldest]
+
+	sender, slow := fillQueueForTesting(OverflowDropOldest)
+
+	// the broadcaster was not blocked by the slow user
+	if len(sender.MessageChannel) != 4 {
+		t.Errorf("sender should have received 4 messages, but got %v", len(sender.MessageChannel))
+	}
+
+	msgs := drainMessages(slow)
+	if len(msgs) != 2 || msgs[0].Content != "message:2" || msgs[1].Content != "message:3" {
+		t.Errorf("slow user should keep the 2 newest messages, but got %v", contents(msgs))
+	}
+
+	if dropped := Broadcaster.DroppedMessages()[OverflowDropOldest] - before; dropped != 2 {
+		t.Errorf("wanted 2 dropped messages, but got %v", dropped)
+	}
+}
+
+func TestOverflowDropNewest(t *testing.T) {
+	defer clearUserListForTesting()
+	before := Broadcaster.DroppedMessages()[OverflowDropNewest]
+
+	_, slow := fillQueueForTesting(OverflowDropNewest)
+
+	msgs := drainMessages(slow)
+	if len(msgs) != 2 || msgs[0].Content != "message:0" || msgs[1].Content != "message:1" {
+		t.Errorf("slow user should keep the 2 oldest messages, but got %v", contents(msgs))
+	}
+
+	if dropped := Broadcaster.DroppedMessages()[OverflowDropNewest] - before; dropped != 2 {
+		t.Errorf("wanted 2 dropped messages, but got %v", dropped)
+	}
+}
+
+func TestOverflowDisconnect(t *testing.T) {
+	defer clearUserListForTesting()
+	before := Broadcaster.DroppedMessages()[OverflowDisconnect]
+
+	_, slow := fillQueueForTesting(OverflowDisconnect)
+
+	if !slow.evicted {
+		t.Error("slow user should be disconnected")
+		return
+	}
+
+	// nothing is queued after the user is disconnected
+	if dropped := Broadcaster.DroppedMessages()[OverflowDisconnect] - before; dropped != 1 {
+		t.Errorf("wanted 1 dropped message, but got %v", dropped)
+	}
+}
@@ -0,0 +1,185 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/fyerfyer/chatroom/pkg/setting"
+	"github.com/fyerfyer/chatroom/pkg/utils"
+)
+
+// the presence of a user, invisible users look offline to everyone else
+const (
+	PresenceOnline    = "online"
+	PresenceAway      = "away"
+	PresenceBusy      = "busy"
+	PresenceInvisible = "invisible"
+	PresenceOffline   = "offline"
+)
+
+const (
+	// how often the broadcaster looks for idle users
+	idleCheckInterval = 10 * time.Second
+
+	// a user typing is announced at most this often to the same room or user
+	typingInterval = 3 * time.Second
+)
+
+var ErrInvalidPresence = errors.New("presence should be online, away, busy or invisible")
+
+func validPresence(presence string) bool {
+	switch presence {
+	case PresenceOnline, PresenceAway, PresenceBusy, PresenceInvisible:
+		return true
+	default:
+		return false
+	}
+}
+
+// shownPresence is the presence the other users see
+func shownPresence(presence string) string {
+	if presence == PresenceInvisible {
+		return PresenceOffline
+	}
+	return presence
+}
+
+// MarshalJSON puts the presence the others see in place of the user's own,
+// so an invisible user looks offline in the messages it sends as well
+func (u *User) MarshalJSON() ([]byte, error) {
+	type user User
+	return json.Marshal(struct {
+		*user
+		Presence string `json:"presence,omitempty"`
+	}{(*user)(u), shownPresence(u.Presence)})
+}
+
+// touch records the activity of the user, it is only called by FetchMessage.
+// A user back from being idle is online again right away.
+func (u *User) touch() {
+	u.lastActive.Store(time.Now().UnixNano())
+	if u.idle.Load() {
+		Broadcaster.do(broadcastOp{typ: OpCheckIdle, user: u})
+	}
+}
+
+// idleFor returns how long the user has not sent anything,
+// users without a connection are never idle
+func (u *User) idleFor(now time.Time) time.Duration {
+	last := u.lastActive.Load()
+	if last == 0 {
+		return 0
+	}
+	return now.Sub(time.Unix(0, last))
+}
+
+// Typing tells the room, or the user named to, that u is typing.
+// It is only called by FetchMessage, repeated calls within typingInterval are dropped.
+func (u *User) Typing(room, to string) error {
+	if to != "" {
+		if err := utils.ValidateName(to); err != nil {
+			return err
+		}
+	}
+
+	target := "@" + to
+	if to == "" {
+		room = roomName(roomOr(room, u.Room))
+		target = room
+	}
+
+	now := time.Now()
+	if u.typingSent == nil {
+		u.typingSent = make(map[string]time.Time)
+	}
+	if now.Sub(u.typingSent[target]) < typingInterval {
+		return nil
+	}
+	u.typingSent[target] = now
+
+	msg := NewTypingMsg(u)
+	if to != "" {
+		msg.To = to
+	} else {
+		msg.Room = room
+	}
+	Broadcaster.Broadcast(msg)
+	return nil
+}
+
+func roomOr(room, fallback string) string {
+	if room == "" {
+		return fallback
+	}
+	return room
+}
+
+// updatePresence works out the presence of the user from the one it chose and
+// how long it has been idle, everyone is told when what they see changes
+func (b *broadcast) updatePresence(user *User, now time.Time) {
+	presence := user.chosenPresence
+	if presence == "" {
+		presence = PresenceOnline
+	}
+
+	idle := presence == PresenceOnline && setting.AwayAfter > 0 &&
+		user.idleFor(now) > setting.AwayAfter
+	if idle {
+		presence = PresenceAway
+	}
+	user.idle.Store(idle)
+
+	if presence == user.Presence {
+		return
+	}
+
+	shown := shownPresence(presence)
+	changed := shown != shownPresence(user.Presence)
+	user.Presence = presence
+	if !changed {
+		return
+	}
+
+	b.Broadcast(NewPresenceMsg(user, shown))
+	b.emit(&Event{Type: EventPresence, User: user.Name, Presence: shown})
+	b.publish(&Event{Type: EventPresence, User: user.Name, Presence: shown})
+}
+
+// checkIdle updates the presence of every user
+func (b *broadcast) checkIdle() {
+	now := time.Now()
+	for _, user := range b.users {
+		if !user.detached {
+			b.updatePresence(user, now)
+		}
+	}
+}
+
+// watchIdle checks the idle users until the broadcaster stops
+func (b *broadcast) watchIdle() {
+	if setting.AwayAfter <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.do(broadcastOp{typ: OpCheckIdle})
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// SetPresence sets the presence the user chose, online lets it go away when idle
+func (b *broadcast) SetPresence(user *User, presence string) error {
+	if !validPresence(presence) {
+		return ErrInvalidPresence
+	}
+
+	err, _ := b.do(broadcastOp{typ: OpSetPresence, user: user, presence: presence}).(error)
+	return err
+}
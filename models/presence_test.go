@@ -0,0 +1,194 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fyerfyer/chatroom/pkg/setting"
+)
+
+// presenceOf returns the presence the message announces for the user, empty if none
+func presenceOf(msgs []*Message, name string) string {
+	var presence string
+	for _, msg := range msgs {
+		if msg.Type == MsgTypePresence && msg.User.Name == name {
+			presence = msg.Content
+		}
+	}
+	return presence
+}
+
+func TestSetPresence(t *testing.T) {
+	defer clearUserListForTesting()
+
+	alice := &User{ID: 4001, Name: "testing_alice", MessageChannel: make(chan *Message, 32)}
+	bob := &User{ID: 4002, Name: "testing_bob", MessageChannel: make(chan *Message, 32)}
+	Broadcaster.UserLogin(alice)
+	Broadcaster.UserLogin(bob)
+	if alice.Presence != PresenceOnline {
+		t.Fatalf("a user should be online after login, but is %q", alice.Presence)
+	}
+
+	if err := Broadcaster.SetPresence(alice, "asleep"); err != ErrInvalidPresence {
+		t.Errorf("wanted %v, but got %v", ErrInvalidPresence, err)
+	}
+
+	for _, test := range []struct {
+		presence, shown string
+	}{
+		{PresenceBusy, PresenceBusy},
+		{PresenceInvisible, PresenceOffline},
+		{PresenceOnline, PresenceOnline},
+	} {
+		drainMessages(bob)
+		if err := Broadcaster.SetPresence(alice, test.presence); err != nil {
+			t.Fatalf("failed to set the presence: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+
+		if got := presenceOf(drainMessages(bob), alice.Name); got != test.shown {
+			t.Errorf("bob should see alice %q, but got %q", test.shown, got)
+		}
+		if listed := hasUser(Broadcaster.GetUserList(), alice); listed == (test.presence == PresenceInvisible) {
+			t.Errorf("alice being %v should be listed: %v", test.presence, !listed)
+		}
+	}
+
+	// what the others see does not change, they are not told
+	Broadcaster.SetPresence(alice, PresenceOnline)
+	time.Sleep(50 * time.Millisecond)
+	if msgs := drainMessages(bob); presenceOf(msgs, alice.Name) != "" {
+		t.Errorf("bob should not be told again, but got %v", contents(msgs))
+	}
+}
+
+func TestIdlePresence(t *testing.T) {
+	defer clearUserListForTesting()
+	defer func(d time.Duration) { setting.AwayAfter = d }(setting.AwayAfter)
+	setting.AwayAfter = time.Minute
+
+	alice := &User{ID: 4003, Name: "testing_alice", MessageChannel: make(chan *Message, 32)}
+	bob := &User{ID: 4004, Name: "testing_bob", MessageChannel: make(chan *Message, 32)}
+	Broadcaster.UserLogin(alice)
+	Broadcaster.UserLogin(bob)
+	alice.lastActive.Store(time.Now().Add(-2 * time.Minute).UnixNano())
+
+	// bob has no connection and is never idle
+	Broadcaster.do(broadcastOp{typ: OpCheckIdle})
+	if alice.Presence != PresenceAway || bob.Presence != PresenceOnline {
+		t.Fatalf("wanted alice away and bob online, but got %q and %q", alice.Presence, bob.Presence)
+	}
+
+	alice.touch()
+	if alice.Presence != PresenceOnline {
+		t.Errorf("alice should be back online, but is %q", alice.Presence)
+	}
+	time.Sleep(50 * time.Millisecond)
+	var seen []string
+	for _, msg := range drainMessages(bob) {
+		if msg.Type == MsgTypePresence {
+			seen = append(seen, msg.Content)
+		}
+	}
+	if len(seen) != 2 || seen[0] != PresenceAway || seen[1] != PresenceOnline {
+		t.Errorf("bob should have seen alice away then online, but got %v", seen)
+	}
+
+	// busy users are not made away
+	Broadcaster.SetPresence(alice, PresenceBusy)
+	alice.lastActive.Store(time.Now().Add(-2 * time.Minute).UnixNano())
+	Broadcaster.do(broadcastOp{typ: OpCheckIdle})
+	if alice.Presence != PresenceBusy {
+		t.Errorf("a busy user should stay busy, but is %q", alice.Presence)
+	}
+}
+
+func TestTyping(t *testing.T) {
+	defer clearUserListForTesting()
+	defer ClearUserMsgProcessorForTesting()
+	ClearUserMsgProcessorForTesting()
+
+	alice := &User{ID: 4005, Name: "testing_alice", Room: "typing_room", MessageChannel: make(chan *Message, 32)}
+	bob := &User{ID: 4006, Name: "testing_bob", Room: "typing_room", MessageChannel: make(chan *Message, 32)}
+	carol := &User{ID: 4007, Name: "testing_carol", MessageChannel: make(chan *Message, 32)}
+	Broadcaster.UserLogin(alice)
+	Broadcaster.UserLogin(bob)
+	Broadcaster.UserLogin(carol)
+	time.Sleep(50 * time.Millisecond)
+	drainMessages(alice)
+	drainMessages(bob)
+	drainMessages(carol)
+
+	// the second one is throttled
+	alice.Typing("", "")
+	alice.Typing("", "")
+	alice.Typing("", carol.Name)
+	time.Sleep(50 * time.Millisecond)
+
+	typing := func(user *User) int {
+		var n int
+		for _, msg := range drainMessages(user) {
+			if msg.Type == MsgTypeTyping && msg.User.Name == alice.Name {
+				n++
+			}
+		}
+		return n
+	}
+	if got := typing(bob); got != 1 {
+		t.Errorf("bob should have seen alice typing once, but got %v", got)
+	}
+	if got := typing(carol); got != 1 {
+		t.Errorf("carol should have seen alice typing to her once, but got %v", got)
+	}
+	if got := typing(alice); got != 0 {
+		t.Errorf("alice should not see herself typing, but got %v", got)
+	}
+
+	// typing is never stored nor replayed
+	alice.Typing("", "testing_dave")
+	time.Sleep(50 * time.Millisecond)
+	if msgs, _ := UserMessageProcessor.Range(Query{}); len(msgs) != 0 {
+		t.Errorf("typing should not be stored, but got %v", contents(msgs))
+	}
+	dave := &User{ID: 4008, Name: "testing_dave", MessageChannel: make(chan *Message, 32)}
+	Broadcaster.UserLogin(dave)
+	if got := typing(dave); got != 0 {
+		t.Errorf("typing should not be replayed on login, but got %v", got)
+	}
+}
+
+func TestInvisibleOnTheWire(t *testing.T) {
+	defer clearUserListForTesting()
+
+	alice := &User{ID: 4005, Name: "testing_alice", MessageChannel: make(chan *Message, 32)}
+	Broadcaster.UserLogin(alice)
+	Broadcaster.SetPresence(alice, PresenceInvisible)
+
+	// a chat message tells nothing of the presence, a presence message what the others see
+	said := NewMessage(alice, MsgTypeNormal, "boo")
+	for msg, want := range map[*Message]string{said: `"from_user":{`, NewPresenceMsg(alice, PresenceOffline): `"presence":"offline"`} {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			t.Fatalf("failed to encode the message: %v", err)
+		}
+		if strings.Contains(string(data), PresenceInvisible) || !strings.Contains(string(data), want) {
+			t.Errorf("alice should look offline, but got %s", data)
+		}
+
+		var decoded Message
+		json.Unmarshal(data, &decoded)
+		if decoded.User.Name != alice.Name || decoded.User.Presence != "" {
+			t.Errorf("wanted alice without her presence back, but got %+v", decoded.User)
+		}
+	}
+
+	// the message keeps the user as it was, the broadcaster goes on changing the user
+	if said.User == alice {
+		t.Error("the message should have a copy of alice, not alice")
+	}
+	if users := Broadcaster.GetUserList(); len(users) != 0 {
+		t.Errorf("an invisible user should not be listed, but got %v", users)
+	}
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+const maxEmojiLength = 32
+
+var ErrInvalidEmoji = errors.New("invalid emoji")
+
+// Reaction counts the users who reacted to a message with the emoji
+type Reaction struct {
+	Emoji string   `json:"emoji"`
+	Count int      `json:"count"`
+	Users []string `json:"users"`
+}
+
+// validEmoji accepts an emoji or a short name like :+1:
+func validEmoji(emoji string) bool {
+	return emoji != "" && len(emoji) <= maxEmojiLength &&
+		!strings.ContainsFunc(emoji, unicode.IsSpace)
+}
+
+// react adds the user to the reaction, it reports false if it was there already.
+// The reactions are copied, the old ones may still be read by whoever got the message.
+func (m *Message) react(name, emoji string) bool {
+	i := slices.IndexFunc(m.Reactions, func(r Reaction) bool { return r.Emoji == emoji })
+	if i >= 0 && slices.Contains(m.Reactions[i].Users, name) {
+		return false
+	}
+
+	reactions := slices.Clone(m.Reactions)
+	if i < 0 {
+		reactions = append(reactions, Reaction{Emoji: emoji})
+		i = len(reactions) - 1
+	}
+	r := &reactions[i]
+	r.Users = append(slices.Clone(r.Users), name)
+	r.Count = len(r.Users)
+	m.Reactions = reactions
+	return true
+}
+
+// unreact removes the user from the reaction, it reports false if it was not there
+func (m *Message) unreact(name, emoji string) bool {
+	i := slices.IndexFunc(m.Reactions, func(r Reaction) bool { return r.Emoji == emoji })
+	if i < 0 || !slices.Contains(m.Reactions[i].Users, name) {
+		return false
+	}
+
+	reactions := slices.Clone(m.Reactions)
+	r := &reactions[i]
+	r.Users = slices.DeleteFunc(slices.Clone(r.Users), func(u string) bool { return u == name })
+	r.Count = len(r.Users)
+	if r.Count == 0 {
+		reactions = slices.Delete(reactions, i, i+1)
+	}
+	m.Reactions = reactions
+	return true
+}
+
+// canReact returns an error unless the user is in the room of the message
+func (b *broadcast) canReact(user *User) func(msg *Message) error {
+	return func(msg *Message) error {
+		if !b.inRoom(user.Name, msg.Room) {
+			return ErrNotInRoom
+		}
+		return nil
+	}
+}
+
+// reactMessage adds or removes the reaction of the user,
+// adding it twice or removing it when it is not there does nothing
+func (b *broadcast) reactMessage(user *User, id, emoji string, add bool) error {
+	if !validEmoji(emoji) {
+		return ErrInvalidEmoji
+	}
+
+	var notice *Message
+	_, err := b.changeMessage(id, b.canReact(user), func(msg *Message) *Message {
+		if add && msg.react(user.Name, emoji) {
+			notice = NewReactMsg(user, msg, emoji, MsgTypeReact)
+		}
+		if !add && msg.unreact(user.Name, emoji) {
+			notice = NewReactMsg(user, msg, emoji, MsgTypeUnreact)
+		}
+		return notice
+	})
+	if err != nil || notice == nil {
+		return err
+	}
+
+	// the listeners get the notice, it names the emoji and carries the reactions
+	event := EventReact
+	if !add {
+		event = EventUnreact
+	}
+	b.emit(&Event{Type: event, User: user.Name, Room: notice.Room, Message: notice})
+	return nil
+}
+
+// React adds the reaction of the user to a room message
+func (b *broadcast) React(user *User, id, emoji string) error {
+	err, _ := b.do(broadcastOp{typ: OpReact, user: user, id: id, content: emoji}).(error)
+	return err
+}
+
+// Unreact removes the reaction of the user from a room message
+func (b *broadcast) Unreact(user *User, id, emoji string) error {
+	err, _ := b.do(broadcastOp{typ: OpUnreact, user: user, id: id, content: emoji}).(error)
+	return err
+}
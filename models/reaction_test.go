@@ -0,0 +1,92 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReact(t *testing.T) {
+	msg := &Message{}
+	msg.react("testing_alice", "+1")
+	old := msg.Reactions
+
+	if !msg.react("testing_bob", "+1") || !msg.react("testing_bob", "tada") {
+		t.Fatal("new reactions should be added")
+	}
+	if msg.react("testing_bob", "+1") {
+		t.Error("a user should react with an emoji only once")
+	}
+
+	want := []Reaction{
+		{Emoji: "+1", Count: 2, Users: []string{"testing_alice", "testing_bob"}},
+		{Emoji: "tada", Count: 1, Users: []string{"testing_bob"}},
+	}
+	if !reflect.DeepEqual(msg.Reactions, want) {
+		t.Errorf("wanted %v, but got %v", want, msg.Reactions)
+	}
+	if old[0].Count != 1 || len(old[0].Users) != 1 {
+		t.Errorf("the reactions handed out before should not change, but got %v", old)
+	}
+
+	if !msg.unreact("testing_bob", "tada") || msg.unreact("testing_bob", "tada") {
+		t.Error("a reaction should be removed once")
+	}
+	if len(msg.Reactions) != 1 {
+		t.Errorf("an emoji nobody uses should be dropped, but got %v", msg.Reactions)
+	}
+}
+
+func TestReactToMessage(t *testing.T) {
+	defer clearUserListForTesting()
+	defer ClearUserMsgProcessorForTesting()
+	ClearUserMsgProcessorForTesting()
+
+	alice := &User{ID: 6001, Name: "testing_alice", Room: "react_room", MessageChannel: make(chan *Message, 32)}
+	bob := &User{ID: 6002, Name: "testing_bob", Room: "react_room", MessageChannel: make(chan *Message, 32)}
+	outsider := &User{ID: 6003, Name: "testing_carol", Room: "other_room", MessageChannel: make(chan *Message, 32)}
+	Broadcaster.UserLogin(alice)
+	Broadcaster.UserLogin(bob)
+	Broadcaster.UserLogin(outsider)
+
+	msg := alice.Say("", "shipped!")
+	time.Sleep(50 * time.Millisecond)
+	drainMessages(alice)
+
+	if err := Broadcaster.React(outsider, msg.ID, "+1"); err != ErrNotInRoom {
+		t.Errorf("wanted %v for a user outside the room, but got %v", ErrNotInRoom, err)
+	}
+	if err := Broadcaster.React(bob, msg.ID, "thumbs up"); err != ErrInvalidEmoji {
+		t.Errorf("wanted %v, but got %v", ErrInvalidEmoji, err)
+	}
+
+	for _, user := range []*User{bob, bob, alice} {
+		if err := Broadcaster.React(user, msg.ID, "+1"); err != nil {
+			t.Fatalf("failed to react: %v", err)
+		}
+	}
+
+	var notices []*Message
+	for _, m := range drainMessages(alice) {
+		if m.Type == MsgTypeReact && m.Ref == msg.ID {
+			notices = append(notices, m)
+		}
+	}
+	if len(notices) != 2 || notices[1].Content != "+1" || notices[1].Reactions[0].Count != 2 {
+		t.Errorf("wanted 2 notices with the counts, but got %v", notices)
+	}
+
+	Broadcaster.Unreact(alice, msg.ID, "+1")
+	if notice := noticeOf(drainMessages(bob), MsgTypeUnreact, msg.ID); notice == nil {
+		t.Error("bob should have been told about the removed reaction")
+	}
+
+	// the counts are replayed with the history
+	dave := &User{ID: 6004, Name: "testing_dave", Room: "react_room", MessageChannel: make(chan *Message, 32)}
+	UserMessageProcessor.Send(dave)
+	replayed := drainMessages(dave)
+	want := []Reaction{{Emoji: "+1", Count: 1, Users: []string{"testing_bob"}}}
+	if len(replayed) != 1 || !reflect.DeepEqual(replayed[0].Reactions, want) {
+		t.Errorf("wanted the message replayed with %v, but got %v", want, replayed)
+	}
+}
@@ -0,0 +1,214 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fyerfyer/chatroom/pkg/utils"
+)
+
+var ErrInvalidSeq = errors.New("invalid sequence number")
+
+// markerSaveDelay batches the changes of the read markers into one write of the file
+const markerSaveDelay = time.Second
+
+// readMarkerStore keeps the sequence number of the last message each account
+// has read in each room and, once loaded from a file, writes the changes
+// back to it at most once every markerSaveDelay
+type readMarkerStore struct {
+	mu      sync.Mutex
+	path    string
+	markers map[string]map[string]uint64
+	dirty   bool
+	timer   *time.Timer
+
+	// saveMu keeps the writes of the file in order
+	saveMu sync.Mutex
+}
+
+var ReadMarkers = &readMarkerStore{
+	markers: make(map[string]map[string]uint64),
+}
+
+// Load reads the markers from the json file at path, an empty path keeps them in memory only.
+// The changes not written yet go to the file loaded before.
+func (s *readMarkerStore) Load(path string) error {
+	if err := s.Flush(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.path = path
+	s.markers = make(map[string]map[string]uint64)
+
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &s.markers)
+}
+
+// Get returns the last message the user read in the room, 0 if it never read any
+func (s *readMarkerStore) Get(account, room string) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.markers[account][room]
+}
+
+// Rooms returns the marker of every room the user has read in
+func (s *readMarkerStore) Rooms(account string) map[string]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rooms := make(map[string]uint64, len(s.markers[account]))
+	for room, seq := range s.markers[account] {
+		rooms[room] = seq
+	}
+	return rooms
+}
+
+// advance moves the marker forward, it reports false if it was there already
+func (s *readMarkerStore) advance(account, room string, seq uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rooms, ok := s.markers[account]
+	if !ok {
+		rooms = make(map[string]uint64)
+		s.markers[account] = rooms
+	}
+	if seq <= rooms[room] {
+		return false
+	}
+
+	rooms[room] = seq
+	s.changed()
+	return true
+}
+
+// changed schedules a write of the file, s.mu is held
+func (s *readMarkerStore) changed() {
+	if s.path == "" {
+		return
+	}
+
+	s.dirty = true
+	if s.timer == nil {
+		s.timer = time.AfterFunc(markerSaveDelay, func() {
+			if err := s.Flush(); err != nil {
+				log.Printf("failed to save read markers: %v", err)
+			}
+		})
+	}
+}
+
+// Flush writes the changes not written yet to the file
+func (s *readMarkerStore) Flush() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.Lock()
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	path := s.path
+	data, err := json.MarshalIndent(s.markers, "", "  ")
+	s.dirty = false
+	s.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(path, data, 0600)
+}
+
+// markRead moves the read marker of the user in the room and tells the room,
+// a marker past the last message of the room stops at it.
+// The sequence numbers are this node's, the receipts are not shared with the cluster.
+func (b *broadcast) markRead(user *User, room string, seq uint64) error {
+	if seq == 0 {
+		return ErrInvalidSeq
+	}
+	if !b.inRoom(user.Name, room) {
+		return ErrNotInRoom
+	}
+
+	last, err := b.store.Range(Query{Room: room, Limit: 1, Latest: true})
+	if err != nil {
+		return err
+	}
+	if len(last) == 0 {
+		return nil
+	}
+	seq = min(seq, last[0].Seq)
+
+	if !ReadMarkers.advance(user.account(), room, seq) {
+		return nil
+	}
+
+	msg := NewReadMsg(user, room, seq)
+	for _, u := range b.recipients(msg) {
+		if u.Name != user.Name {
+			b.deliver(u, msg)
+		}
+	}
+	b.emit(&Event{Type: EventRead, User: user.Name, Room: room, Message: msg})
+	return nil
+}
+
+// unreadCount counts the messages of other accounts in the room after the account's marker
+func unreadCount(store MessageStore, account, room string) (int, error) {
+	marker := ReadMarkers.Get(account, room)
+	all, err := store.Count(room, "", marker)
+	if err != nil {
+		return 0, err
+	}
+	own, err := store.Count(room, account, marker)
+	if err != nil {
+		return 0, err
+	}
+	return all - own, nil
+}
+
+// UnreadCounts returns the unread messages of the account in every room it has
+// read in and in the given rooms
+func UnreadCounts(store MessageStore, account string, rooms ...string) (map[string]int, error) {
+	names := ReadMarkers.Rooms(account)
+	for _, room := range rooms {
+		names[room] = 0
+	}
+
+	counts := make(map[string]int, len(names))
+	for room := range names {
+		n, err := unreadCount(store, account, room)
+		if err != nil {
+			return nil, err
+		}
+		counts[room] = n
+	}
+	return counts, nil
+}
+
+// MarkRead moves the read marker of the user in the room to the message with the sequence number
+func (b *broadcast) MarkRead(user *User, room string, seq uint64) error {
+	err, _ := b.do(broadcastOp{typ: OpMarkRead, user: user, room: room, seq: seq}).(error)
+	return err
+}
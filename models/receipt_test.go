@@ -0,0 +1,143 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadReceipts(t *testing.T) {
+	defer clearUserListForTesting()
+	defer ClearUserMsgProcessorForTesting()
+	ClearUserMsgProcessorForTesting()
+	ReadMarkers.Load("")
+	defer ReadMarkers.Load("")
+
+	alice := &User{ID: 9001, Name: "testing_alice", Room: "read_room", MessageChannel: make(chan *Message, 32)}
+	bob := &User{ID: 9002, Name: "testing_bob", Room: "read_room", MessageChannel: make(chan *Message, 32)}
+	Broadcaster.UserLogin(alice)
+	Broadcaster.UserLogin(bob)
+
+	var msgs []*Message
+	for _, content := range []string{"one", "two", "three"} {
+		msgs = append(msgs, alice.Say("", content))
+	}
+	time.Sleep(50 * time.Millisecond)
+	drainMessages(alice)
+
+	if err := Broadcaster.MarkRead(bob, "read_room", msgs[1].Seq); err != nil {
+		t.Fatalf("failed to mark the room read: %v", err)
+	}
+	var receipt *Message
+	for _, msg := range drainMessages(alice) {
+		if msg.Type == MsgTypeRead {
+			receipt = msg
+		}
+	}
+	if receipt == nil || receipt.User.Name != bob.Name || receipt.ReadSeq != msgs[1].Seq {
+		t.Errorf("alice should have got bob's read receipt, but got %+v", receipt)
+	}
+
+	// a marker never moves back, nor past the last message
+	Broadcaster.MarkRead(bob, "read_room", msgs[0].Seq)
+	if got := ReadMarkers.Get(bob.Name, "read_room"); got != msgs[1].Seq {
+		t.Errorf("wanted the marker at %v, but got %v", msgs[1].Seq, got)
+	}
+	if msgs := drainMessages(alice); len(msgs) != 0 {
+		t.Errorf("an old marker should not be announced, but got %v", contents(msgs))
+	}
+	Broadcaster.MarkRead(bob, "read_room", 1000)
+	if got := ReadMarkers.Get(bob.Name, "read_room"); got != msgs[2].Seq {
+		t.Errorf("wanted the marker at the last message %v, but got %v", msgs[2].Seq, got)
+	}
+
+	if err := Broadcaster.MarkRead(bob, "other_room", 1); err != ErrNotInRoom {
+		t.Errorf("wanted %v for a room bob is not in, but got %v", ErrNotInRoom, err)
+	}
+	if err := Broadcaster.MarkRead(bob, "read_room", 0); err != ErrInvalidSeq {
+		t.Errorf("wanted %v for no sequence number, but got %v", ErrInvalidSeq, err)
+	}
+
+	// the own messages are never unread, whatever name they were sent under
+	alice.Say("", "four")
+	bob.Say("", "mine")
+	time.Sleep(50 * time.Millisecond)
+	if err := Broadcaster.Rename(bob, "testing_robert"); err != nil {
+		t.Fatalf("failed to rename: %v", err)
+	}
+	bob.Say("", "mine again")
+	time.Sleep(50 * time.Millisecond)
+	counts, err := UnreadCounts(Broadcaster.Store(), bob.account(), "empty_room")
+	if err != nil {
+		t.Fatalf("failed to count unread messages: %v", err)
+	}
+	if len(counts) != 2 || counts["read_room"] != 1 || counts["empty_room"] != 0 {
+		t.Errorf("wanted 1 unread message in read_room, but got %v", counts)
+	}
+}
+
+func TestReplayAfterMarker(t *testing.T) {
+	defer clearUserListForTesting()
+	defer ClearUserMsgProcessorForTesting()
+	ClearUserMsgProcessorForTesting()
+	ReadMarkers.Load(filepath.Join(t.TempDir(), "read_markers.json"))
+	defer ReadMarkers.Load("")
+
+	alice := &User{ID: 9003, Name: "testing_alice", Room: "read_room", MessageChannel: make(chan *Message, 32)}
+	bob := &User{ID: 9004, Name: "testing_bob", Room: "read_room", MessageChannel: make(chan *Message, 32)}
+	Broadcaster.UserLogin(alice)
+	Broadcaster.UserLogin(bob)
+
+	read := alice.Say("", "seen")
+	time.Sleep(50 * time.Millisecond)
+	Broadcaster.MarkRead(bob, "read_room", read.Seq)
+	Broadcaster.UserLogout(bob)
+
+	alice.Say("", "missed 1")
+	alice.Say("", "missed 2")
+	time.Sleep(50 * time.Millisecond)
+
+	// the markers outlive a restart
+	ReadMarkers.Load(ReadMarkers.path)
+
+	bob = &User{ID: 9004, Name: "testing_bob", Room: "read_room", MessageChannel: make(chan *Message, 32)}
+	Broadcaster.UserLogin(bob)
+
+	var replayed []string
+	var unread map[string]int
+	for _, msg := range drainMessages(bob) {
+		switch msg.Type {
+		case MsgTypeNormal:
+			replayed = append(replayed, msg.Content)
+		case MsgTypeUnread:
+			unread = msg.Unread
+		}
+	}
+	if len(replayed) != 2 || replayed[0] != "missed 1" || replayed[1] != "missed 2" {
+		t.Errorf("wanted only the messages after the marker, but got %v", replayed)
+	}
+	if unread["read_room"] != 2 {
+		t.Errorf("wanted 2 unread messages on login, but got %v", unread)
+	}
+}
+
+func TestReadMarkersBatched(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "read_markers.json")
+	ReadMarkers.Load(path)
+	defer ReadMarkers.Load("")
+
+	ReadMarkers.advance("testing_alice", "read_room", 3)
+	ReadMarkers.advance("testing_alice", "read_room", 5)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("the markers should not be written on every change, but got %v", err)
+	}
+
+	if err := ReadMarkers.Flush(); err != nil {
+		t.Fatalf("failed to flush the markers: %v", err)
+	}
+	ReadMarkers.Load(path)
+	if got := ReadMarkers.Get("testing_alice", "read_room"); got != 5 {
+		t.Errorf("wanted the marker at 5 after a restart, but got %v", got)
+	}
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/fyerfyer/chatroom/pkg/setting"
+)
+
+// Room is a named group of users. Rooms are only touched by the
+// broadcaster goroutine; everyone else gets a snapshot.
+type Room struct {
+	Name      string    `json:"name"`
+	Topic     string    `json:"topic,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Users     []string  `json:"users"`
+
+	members map[string]*User
+}
+
+func newRoom(name string) *Room {
+	return &Room{
+		Name:      name,
+		CreatedAt: time.Now(),
+		members:   make(map[string]*User),
+	}
+}
+
+// snapshot copies the room so it can be handed out of the broadcaster
+func (r *Room) snapshot() *Room {
+	names := make([]string, 0, len(r.members))
+	for name := range r.members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return &Room{
+		Name:      r.Name,
+		Topic:     r.Topic,
+		CreatedAt: r.CreatedAt,
+		Users:     names,
+	}
+}
+
+// roomName maps the empty room to the default one
+func roomName(name string) string {
+	if name == "" {
+		return setting.DefaultRoom
+	}
+
+	return name
+}
+
+// maxTopicLength is the longest topic a room can have, in bytes
+const maxTopicLength = 200
+
+var ErrTopicTooLong = fmt.Errorf("the topic should have at most %d characters", maxTopicLength)
+
+// setTopic changes the topic of a room the user is in and announces it there
+func (b *broadcast) setTopic(user *User, name, topic string) error {
+	if len(topic) > maxTopicLength {
+		return ErrTopicTooLong
+	}
+
+	room, ok := b.rooms[name]
+	if !ok {
+		return ErrRoomNotExists
+	}
+	if _, ok := room.members[user.Name]; !ok {
+		return ErrNotInRoom
+	}
+
+	room.Topic = topic
+	content := fmt.Sprintf("%s set the topic: %s", user.Name, topic)
+	if topic == "" {
+		content = user.Name + " cleared the topic"
+	}
+
+	msg := NewSystemMsg(content)
+	msg.Room = room.Name
+	b.Broadcast(msg)
+	return nil
+}
@@ -0,0 +1,127 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func drainMessages(user *User) []*Message {
+	var msgs []*Message
+	for len(user.MessageChannel) > 0 {
+		msgs = append(msgs, <-user.MessageChannel)
+	}
+
+	return msgs
+}
+
+func TestRoomCreatedOnJoin(t *testing.T) {
+	defer clearUserListForTesting()
+
+	user := &User{
+		Name:           "testing_user1",
+		Room:           "room_a",
+		MessageChannel: make(chan *Message, 32),
+	}
+	Broadcaster.UserLogin(user)
+
+	if err := Broadcaster.JoinRoom(user, "room_b"); err != nil {
+		t.Errorf("failed to join room: %v", err)
+		return
+	}
+
+	rooms := Broadcaster.GetRoomList()
+	if len(rooms) != 2 || rooms[0].Name != "room_a" || rooms[1].Name != "room_b" {
+		t.Errorf("wanted rooms room_a and room_b, but got %v", rooms)
+		return
+	}
+
+	if len(rooms[1].Users) != 1 || rooms[1].Users[0] != user.Name {
+		t.Errorf("wanted %v in room_b, but got %v", user.Name, rooms[1].Users)
+	}
+}
+
+func TestRoomTornDownWhenEmpty(t *testing.T) {
+	defer clearUserListForTesting()
+
+	user := &User{
+		Name:           "testing_user1",
+		Room:           "room_a",
+		MessageChannel: make(chan *Message, 32),
+	}
+	Broadcaster.UserLogin(user)
+	Broadcaster.JoinRoom(user, "room_b")
+
+	if err := Broadcaster.LeaveRoom(user, "room_b"); err != nil {
+		t.Errorf("failed to leave room: %v", err)
+		return
+	}
+	if err := Broadcaster.LeaveRoom(user, "room_b"); err != ErrRoomNotExists {
+		t.Errorf("wanted error %v, but got %v", ErrRoomNotExists, err)
+		return
+	}
+
+	Broadcaster.UserLogout(user)
+	if rooms := Broadcaster.GetRoomList(); len(rooms) != 0 {
+		t.Errorf("all rooms should be torn down, but got %v", rooms)
+	}
+}
+
+func TestBroadcastScopedToRoom(t *testing.T) {
+	defer clearUserListForTesting()
+
+	inRoom := &User{ID: 1, Name: "testing_user1", Room: "room_a", MessageChannel: make(chan *Message, 32)}
+	sender := &User{ID: 2, Name: "testing_user2", Room: "room_a", MessageChannel: make(chan *Message, 32)}
+	outRoom := &User{ID: 3, Name: "testing_user3", Room: "room_b", MessageChannel: make(chan *Message, 32)}
+	for _, user := range []*User{inRoom, sender, outRoom} {
+		loginUserWithoutSendingMessage(user)
+	}
+	// let messages queued by other tests go through first
+	time.Sleep(50 * time.Millisecond)
+	drainMessages(inRoom)
+
+	msg := NewMessage(sender, MsgTypeNormal, "hello room_a")
+	msg.Room = "room_a"
+	Broadcaster.Broadcast(msg)
+	time.Sleep(50 * time.Millisecond)
+
+	if msgs := drainMessages(inRoom); len(msgs) != 1 || msgs[0].Content != msg.Content {
+		t.Errorf("user in room should receive the message, but got %v", msgs)
+	}
+	if msgs := drainMessages(outRoom); len(msgs) != 0 {
+		t.Errorf("user out of room should not receive the message, but got %v", msgs)
+	}
+
+	// sending to a room the user has not joined is refused
+	msg = NewMessage(sender, MsgTypeNormal, "hello room_b")
+	msg.Room = "room_b"
+	drainMessages(sender)
+	Broadcaster.Broadcast(msg)
+	time.Sleep(50 * time.Millisecond)
+
+	if msgs := drainMessages(outRoom); len(msgs) != 0 {
+		t.Errorf("message from outside the room should be dropped, but got %v", msgs)
+	}
+	if msgs := drainMessages(sender); len(msgs) != 1 || msgs[0].Type != MsgTypeError {
+		t.Errorf("sender should get an error message, but got %v", msgs)
+	}
+}
+
+func TestJoinMessageScopedToRoom(t *testing.T) {
+	defer clearUserListForTesting()
+
+	inRoom := &User{ID: 1, Name: "testing_user1", Room: "room_a", MessageChannel: make(chan *Message, 32)}
+	outRoom := &User{ID: 2, Name: "testing_user2", Room: "room_b", MessageChannel: make(chan *Message, 32)}
+	loginUserWithoutSendingMessage(inRoom)
+	loginUserWithoutSendingMessage(outRoom)
+
+	user := &User{ID: 3, Name: "testing_user3", Room: "room_a", MessageChannel: make(chan *Message, 32)}
+	Broadcaster.UserLogin(user)
+	time.Sleep(50 * time.Millisecond)
+
+	if msgs := drainMessages(inRoom); len(msgs) != 1 || msgs[0].Type != MsgTypeUserLogin {
+		t.Errorf("user in room should receive the login message, but got %v", msgs)
+	}
+	if msgs := drainMessages(outRoom); len(msgs) != 0 {
+		t.Errorf("user out of room should not receive the login message, but got %v", msgs)
+	}
+}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"log"
+
+	"github.com/fyerfyer/chatroom/pkg/search"
+)
+
+// SearchResult is a message found by a search, with the matches highlighted
+type SearchResult struct {
+	Message   *Message `json:"message"`
+	Highlight string   `json:"highlight"`
+	Score     float64  `json:"score"`
+}
+
+func messageDoc(msg *Message) search.Doc {
+	doc := search.Doc{
+		ID:   msg.Seq,
+		Text: msg.Content,
+		Room: msg.Room,
+		Time: msg.CreatedAt,
+	}
+	if msg.User != nil {
+		doc.From = msg.User.Name
+	}
+	return doc
+}
+
+// indexMessage adds a stored chat message to the search index, or takes it out once deleted
+func (b *broadcast) indexMessage(msg *Message) {
+	if msg.Type != MsgTypeNormal || msg.Seq == 0 {
+		return
+	}
+
+	if msg.Deleted {
+		b.index.Remove(msg.Seq)
+		return
+	}
+	b.index.Add(messageDoc(msg))
+}
+
+// RebuildIndex indexes every message of the store again, it must be called before Start
+func (b *broadcast) RebuildIndex() error {
+	msgs, err := b.store.Range(Query{})
+	if err != nil {
+		return err
+	}
+
+	b.index = search.New()
+	for _, msg := range msgs {
+		b.indexMessage(msg)
+	}
+	log.Printf("indexed %d messages", b.index.Len())
+	return nil
+}
+
+// Search returns a page of the stored messages matching the query, with the total
+// number of matches. The messages the store no longer has are dropped from the index.
+func (b *broadcast) Search(q *search.Query, order string, offset, limit int) ([]*SearchResult, int, error) {
+	hits, total := b.index.Search(q, order, offset, limit)
+
+	results := make([]*SearchResult, 0, len(hits))
+	for _, hit := range hits {
+		msgs, err := b.store.Range(Query{AfterSeq: hit.ID - 1, Limit: 1})
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(msgs) == 0 || msgs[0].Seq != hit.ID {
+			b.index.Remove(hit.ID)
+			total--
+			continue
+		}
+
+		results = append(results, &SearchResult{
+			Message:   msgs[0],
+			Highlight: q.Highlight(msgs[0].Content),
+			Score:     hit.Score,
+		})
+	}
+	return results, total, nil
+}
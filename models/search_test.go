@@ -0,0 +1,84 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fyerfyer/chatroom/pkg/search"
+)
+
+func searchForTesting(t *testing.T, s string) []string {
+	q, err := search.ParseQuery(s)
+	if err != nil {
+		t.Fatalf("failed to parse %q: %v", s, err)
+	}
+
+	results, total, err := Broadcaster.Search(q, search.OrderTime, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to search: %v", err)
+	}
+	if total != len(results) {
+		t.Errorf("wanted a total of %v, but got %v", len(results), total)
+	}
+
+	var found []string
+	for _, result := range results {
+		found = append(found, result.Message.Content)
+	}
+	return found
+}
+
+func TestSearchIndex(t *testing.T) {
+	defer clearUserListForTesting()
+	defer Broadcaster.SetStore(UserMessageProcessor)
+	Broadcaster.SetStore(UserMessageProcessor)
+	defer ClearUserMsgProcessorForTesting()
+	ClearUserMsgProcessorForTesting()
+
+	alice := &User{ID: 9101, Name: "testing_alice", Room: "search_room", MessageChannel: make(chan *Message, 32)}
+	Broadcaster.UserLogin(alice)
+
+	release := alice.Say("", "release on friday")
+	alice.Say("", "lunch on friday")
+	time.Sleep(50 * time.Millisecond)
+
+	if got := searchForTesting(t, "friday"); len(got) != 2 || got[0] != "lunch on friday" {
+		t.Errorf("wanted both messages newest first, but got %v", got)
+	}
+
+	// an edit is searched by its new content
+	if err := Broadcaster.EditMessage(alice, release.ID, "release on monday"); err != nil {
+		t.Fatalf("failed to edit: %v", err)
+	}
+	if got := searchForTesting(t, "release friday"); len(got) != 0 {
+		t.Errorf("the old content should not be found, but got %v", got)
+	}
+	if got := searchForTesting(t, "monday"); len(got) != 1 {
+		t.Errorf("wanted the edited message, but got %v", got)
+	}
+
+	if err := Broadcaster.DeleteMessage(alice, release.ID); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if got := searchForTesting(t, "monday"); len(got) != 0 {
+		t.Errorf("a deleted message should not be found, but got %v", got)
+	}
+
+	// the memory store forgets the oldest messages, so does the search
+	for i := 0; i < UserMessageProcessor.maxMsgNum; i++ {
+		alice.Say("", "filler")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := searchForTesting(t, "lunch"); len(got) != 0 {
+		t.Errorf("a message no longer stored should not be found, but got %v", got)
+	}
+
+	// the index is rebuilt from the store
+	Broadcaster.SetStore(UserMessageProcessor)
+	if err := Broadcaster.RebuildIndex(); err != nil {
+		t.Fatalf("failed to rebuild the index: %v", err)
+	}
+	if got := searchForTesting(t, "filler in:search_room"); len(got) != UserMessageProcessor.maxMsgNum {
+		t.Errorf("wanted every stored message back in the index, but got %v", len(got))
+	}
+}
@@ -0,0 +1,174 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"github.com/fyerfyer/chatroom/pkg/setting"
+)
+
+// resumeRequest is what a reconnecting client presents to get its session back
+type resumeRequest struct {
+	token   string
+	lastSeq uint64
+}
+
+// detachedSession is a session whose connection was lost,
+// it can be resumed until the grace window is over
+type detachedSession struct {
+	user  *User
+	timer *time.Timer
+	// lastSeq is the last message stored when the connection was lost
+	lastSeq uint64
+}
+
+// RequestResume asks the broadcaster to resume the session the token was
+// issued for instead of logging in, lastSeq is the last message the client got
+func (u *User) RequestResume(token string, lastSeq uint64) {
+	u.resume = &resumeRequest{token: token, lastSeq: lastSeq}
+}
+
+// LeftNormally reports whether the client closed the connection on purpose
+func (u *User) LeftNormally() bool {
+	return u.leftNormally
+}
+
+func newResumeToken() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		log.Fatalf("Failed to generate resume token: %v", err)
+	}
+
+	return hex.EncodeToString(buf)
+}
+
+// issueResumeToken hands the user a fresh token to resume the session with
+func (b *broadcast) issueResumeToken(user *User) {
+	if setting.ResumeGrace <= 0 || user.IsBot {
+		return
+	}
+
+	user.resumeToken = newResumeToken()
+	b.deliver(user, NewSessionMsg(user.resumeToken))
+}
+
+// resumable returns the detached session the user asked to resume
+func (b *broadcast) resumable(user *User) (*detachedSession, bool) {
+	if user.resume == nil {
+		return nil, false
+	}
+
+	session, ok := b.detached[user.account()]
+	if !ok || session.user.resumeToken != user.resume.token {
+		return nil, false
+	}
+	return session, true
+}
+
+// detach keeps the session of a lost connection for setting.ResumeGrace under
+// the account, a new name does not lose it. Nobody is told the user left,
+// messages to the user are kept in the store.
+func (b *broadcast) detach(user *User) {
+	if b.users[user.Name] != user || user.resumeToken == "" {
+		b.logout(user)
+		return
+	}
+
+	var lastSeq uint64
+	last, err := b.store.Range(Query{Limit: 1, Latest: true})
+	if err != nil {
+		log.Printf("failed to find the last message of %s: %v", user.Name, err)
+	} else if len(last) > 0 {
+		lastSeq = last[0].Seq
+	}
+
+	user.detached = true
+	user.CloseChannel()
+	b.detached[user.account()] = &detachedSession{
+		user: user,
+		timer: time.AfterFunc(setting.ResumeGrace, func() {
+			b.do(broadcastOp{typ: OpExpire, user: user})
+		}),
+		lastSeq: lastSeq,
+	}
+}
+
+// expire logs out a detached session that was not resumed in time
+func (b *broadcast) expire(user *User) {
+	if session, ok := b.detached[user.account()]; !ok || session.user != user {
+		return
+	}
+
+	delete(b.detached, user.account())
+	b.logout(user)
+}
+
+// resume puts the new connection in place of the detached session, under the
+// name it had, and replays what the client missed, without any leave or join
+// message. The replay starts at the detach point at the earliest and holds
+// the last setting.OfflineMsgNum messages at most, the client is told first
+// if some of it is left out.
+func (b *broadcast) resume(user *User, session *detachedSession) {
+	session.timer.Stop()
+	delete(b.detached, user.account())
+
+	old := session.user
+	user.Name = old.Name
+	user.Account = old.account()
+	user.ID = old.ID
+	user.Room = old.Room
+	user.IsOnline = true
+	user.Presence = old.Presence
+	user.chosenPresence = old.chosenPresence
+	b.users[user.Name] = user
+	b.applyMute(user)
+	onlineUsers.Set(float64(len(b.users)))
+
+	rooms := b.roomsOf(user.Name)
+	joined := make(map[string]bool, len(rooms))
+	for _, room := range rooms {
+		room.members[user.Name] = user
+		joined[room.Name] = true
+	}
+
+	// the client got everything sent before the detach, whatever it says
+	lastSeq := max(user.resume.lastSeq, session.lastSeq)
+	missed, err := b.store.Range(Query{AfterSeq: lastSeq, Limit: setting.OfflineMsgNum, Latest: true})
+	if err != nil {
+		log.Printf("failed to replay messages to %s: %v", user.Name, err)
+	}
+	// the oldest of the missed messages are forgotten or too many to replay
+	if len(missed) > 0 && missed[0].Seq > lastSeq+1 {
+		b.deliver(user, NewGapMsg(lastSeq+1, missed[0].Seq-1))
+	}
+	for _, msg := range missed {
+		if joined[msg.Room] {
+			b.deliver(user, msg)
+		}
+	}
+
+	inbox, err := b.store.PopInbox(user.Name)
+	if err != nil {
+		log.Printf("failed to replay messages to %s: %v", user.Name, err)
+	}
+	for _, msg := range inbox {
+		b.deliver(user, msg)
+	}
+
+	b.issueResumeToken(user)
+	log.Printf("%s resumed the session", user.Name)
+}
+
+func (b *broadcast) UserDetach(user *User) {
+	b.do(broadcastOp{typ: OpDetach, user: user})
+}
+
+// CheckUserCanResume reports whether the token resumes a detached session of the account
+func (b *broadcast) CheckUserCanResume(account, token string) bool {
+	user := &User{Account: account}
+	user.RequestResume(token, 0)
+	boolReply, _ := b.do(broadcastOp{typ: OpCheckResume, user: user}).(bool)
+	return boolReply
+}
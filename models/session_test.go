@@ -0,0 +1,189 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fyerfyer/chatroom/pkg/setting"
+)
+
+// loginWithSessionForTesting logs the user in and returns its resume token
+func loginWithSessionForTesting(t *testing.T, user *User) string {
+	Broadcaster.UserLogin(user)
+
+	for _, msg := range drainMessages(user) {
+		if msg.Type == MsgTypeSession {
+			return msg.Content
+		}
+	}
+
+	t.Fatalf("%v should have received a resume token", user.Name)
+	return ""
+}
+
+func TestResumeSession(t *testing.T) {
+	defer clearUserListForTesting()
+	time.Sleep(50 * time.Millisecond)
+
+	watcher := &User{ID: 1, Name: "testing_user1", MessageChannel: make(chan *Message, 32)}
+	loginUserWithoutSendingMessage(watcher)
+
+	user := &User{ID: 2, Name: "testing_user2", MessageChannel: make(chan *Message, 32)}
+	token := loginWithSessionForTesting(t, user)
+
+	seen := NewMessage(watcher, MsgTypeNormal, "seen before the blip")
+	Broadcaster.Broadcast(seen)
+	time.Sleep(50 * time.Millisecond)
+	drainMessages(user)
+	drainMessages(watcher)
+
+	Broadcaster.UserDetach(user)
+	Broadcaster.Broadcast(NewMessage(watcher, MsgTypeNormal, "missed during the blip"))
+	time.Sleep(50 * time.Millisecond)
+
+	if Broadcaster.CheckUserCanLogin(user.Name) {
+		t.Error("the name of a detached session should stay taken")
+	}
+	if !Broadcaster.CheckUserCanResume(user.Name, token) {
+		t.Fatal("the detached session should be resumable with its token")
+	}
+	if Broadcaster.CheckUserCanResume(user.Name, "forged") {
+		t.Error("the detached session should not be resumable with another token")
+	}
+
+	resumed := &User{ID: 3, Name: user.Name, MessageChannel: make(chan *Message, 32)}
+	resumed.RequestResume(token, seen.Seq)
+	Broadcaster.UserLogin(resumed)
+	time.Sleep(50 * time.Millisecond)
+
+	if resumed.ID != user.ID {
+		t.Errorf("resumed session should keep ID %v, but got %v", user.ID, resumed.ID)
+	}
+
+	var replayed []string
+	for _, msg := range drainMessages(resumed) {
+		if msg.Type == MsgTypeNormal {
+			replayed = append(replayed, msg.Content)
+		}
+	}
+	if len(replayed) != 1 || replayed[0] != "missed during the blip" {
+		t.Errorf("wanted only the missed message replayed, but got %v", replayed)
+	}
+
+	// nobody saw the user leave or join again
+	for _, msg := range drainMessages(watcher) {
+		if msg.Type == MsgTypeUserLogin || msg.Type == MsgTypeUserLogout {
+			t.Errorf("watcher should not see leave or join noise, but got %v", msg.Content)
+		}
+	}
+}
+
+func TestDetachedSessionExpires(t *testing.T) {
+	defer clearUserListForTesting()
+	grace := setting.ResumeGrace
+	setting.ResumeGrace = 50 * time.Millisecond
+	defer func() { setting.ResumeGrace = grace }()
+	time.Sleep(50 * time.Millisecond)
+
+	watcher := &User{ID: 1, Name: "testing_user1", MessageChannel: make(chan *Message, 32)}
+	loginUserWithoutSendingMessage(watcher)
+
+	user := &User{ID: 2, Name: "testing_user2", MessageChannel: make(chan *Message, 32)}
+	token := loginWithSessionForTesting(t, user)
+	Broadcaster.UserDetach(user)
+	time.Sleep(100 * time.Millisecond)
+
+	if !Broadcaster.CheckUserCanLogin(user.Name) {
+		t.Error("the name should be free once the grace window is over")
+	}
+	if Broadcaster.CheckUserCanResume(user.Name, token) {
+		t.Error("an expired session should not be resumable")
+	}
+
+	for _, msg := range drainMessages(watcher) {
+		if msg.Type == MsgTypeUserLogout && msg.User.Name == user.Name {
+			return
+		}
+	}
+	t.Error("watcher should see the logout once the session expired")
+}
+
+func TestResumeAfterGap(t *testing.T) {
+	defer clearUserListForTesting()
+	defer ClearUserMsgProcessorForTesting()
+	time.Sleep(50 * time.Millisecond)
+
+	watcher := &User{ID: 1, Name: "testing_user1", MessageChannel: make(chan *Message, 32)}
+	loginUserWithoutSendingMessage(watcher)
+
+	user := &User{ID: 2, Name: "testing_user2", MessageChannel: make(chan *Message, 32)}
+	token := loginWithSessionForTesting(t, user)
+
+	seen := NewMessage(watcher, MsgTypeNormal, "seen before the blip")
+	Broadcaster.Broadcast(seen)
+	time.Sleep(50 * time.Millisecond)
+	drainMessages(user)
+
+	// more is missed than the memory store keeps
+	Broadcaster.UserDetach(user)
+	for i := 0; i <= UserMessageProcessor.maxMsgNum; i++ {
+		Broadcaster.Broadcast(NewMessage(watcher, MsgTypeNormal, "missed during the blip"))
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	resumed := &User{ID: 3, Name: user.Name, MessageChannel: make(chan *Message, 32)}
+	resumed.RequestResume(token, seen.Seq)
+	Broadcaster.UserLogin(resumed)
+	time.Sleep(50 * time.Millisecond)
+
+	msgs := drainMessages(resumed)
+	if len(msgs) == 0 || msgs[0].Type != MsgTypeGap {
+		t.Fatalf("wanted the gap told before the replay, but got %v", contents(msgs))
+	}
+	if gap := msgs[0].Missed; len(gap) != 2 || gap[0] != seen.Seq+1 || gap[1] != seen.Seq+1 {
+		t.Errorf("wanted message %v missing, but got %v", seen.Seq+1, gap)
+	}
+}
+
+func TestResumeAfterRename(t *testing.T) {
+	defer clearUserListForTesting()
+	defer ClearUserMsgProcessorForTesting()
+	time.Sleep(50 * time.Millisecond)
+
+	watcher := &User{ID: 1, Name: "testing_user1", MessageChannel: make(chan *Message, 32)}
+	loginUserWithoutSendingMessage(watcher)
+
+	user := &User{ID: 2, Name: "testing_user2", MessageChannel: make(chan *Message, 32)}
+	token := loginWithSessionForTesting(t, user)
+	if err := Broadcaster.Rename(user, "testing_renamed"); err != nil {
+		t.Fatalf("failed to rename: %v", err)
+	}
+
+	Broadcaster.Broadcast(NewMessage(watcher, MsgTypeNormal, "seen before the blip"))
+	time.Sleep(50 * time.Millisecond)
+	Broadcaster.UserDetach(user)
+	Broadcaster.Broadcast(NewMessage(watcher, MsgTypeNormal, "missed during the blip"))
+	time.Sleep(50 * time.Millisecond)
+
+	// the client logs in with its account and claims to have seen nothing
+	if !Broadcaster.CheckUserCanResume("testing_user2", token) {
+		t.Fatal("the session should be resumable with the account")
+	}
+	resumed := &User{ID: 3, Name: "testing_user2", MessageChannel: make(chan *Message, 32)}
+	resumed.RequestResume(token, 0)
+	Broadcaster.UserLogin(resumed)
+	time.Sleep(50 * time.Millisecond)
+
+	if resumed.Name != "testing_renamed" || resumed.account() != "testing_user2" {
+		t.Errorf("wanted the session back as testing_renamed, but got %v of %v", resumed.Name, resumed.account())
+	}
+	var replayed []string
+	for _, msg := range drainMessages(resumed) {
+		if msg.Type == MsgTypeNormal {
+			replayed = append(replayed, msg.Content)
+		}
+	}
+	if len(replayed) != 1 || replayed[0] != "missed during the blip" {
+		t.Errorf("wanted only the message sent after the detach, but got %v", replayed)
+	}
+}
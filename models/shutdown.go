@@ -0,0 +1,108 @@
+package models
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"nhooyr.io/websocket"
+)
+
+const shutdownNotice = "server restarting"
+
+// ShuttingDown reports whether the server stopped taking new connections
+func (b *broadcast) ShuttingDown() bool {
+	return b.closing.Load()
+}
+
+// Shutdown tells everyone the server is going away, stores the queued messages,
+// stops the broadcaster and closes every connection with StatusGoingAway.
+// It gives up waiting for the broadcaster and the connections once ctx is done.
+func (b *broadcast) Shutdown(ctx context.Context) error {
+	if !b.closing.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	b.Broadcast(NewSystemMsg(shutdownNotice))
+
+	op := broadcastOp{typ: OpShutdown, reply: make(chan interface{}, 1)}
+	select {
+	case b.ops <- op:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	users, _ := (<-op.reply).([]*User)
+
+	// every user got what was queued for it before its connection goes away
+	var wg sync.WaitGroup
+	for _, user := range users {
+		wg.Add(1)
+		go func(user *User) {
+			defer wg.Done()
+			user.closeGoingAway(ctx)
+		}(user)
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-ctx.Done():
+	}
+
+	// the other nodes forget the users of this one
+	if b.backplane != nil {
+		if err := b.backplane.Close(); err != nil {
+			log.Printf("failed to close the backplane: %v", err)
+		}
+	}
+
+	if err := ReadMarkers.Flush(); err != nil {
+		log.Printf("failed to save read markers: %v", err)
+	}
+	if err := b.store.Close(); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// stop runs on the broadcaster once it is asked to shut down, it dispatches
+// the queued messages and closes the channel of every connected user
+func (b *broadcast) stop() []*User {
+	// only the broadcaster reads the queue, so it cannot block here
+	for len(b.messageChannel) > 0 {
+		b.dispatch(<-b.messageChannel)
+	}
+
+	for _, session := range b.detached {
+		session.timer.Stop()
+	}
+
+	users := make([]*User, 0, len(b.users))
+	for _, user := range b.users {
+		if user.detached {
+			continue
+		}
+		user.CloseChannel()
+		users = append(users, user)
+	}
+	return users
+}
+
+// closeGoingAway waits for the queued messages to be written and closes the connection
+func (u *User) closeGoingAway(ctx context.Context) {
+	if u.sendDone != nil {
+		select {
+		case <-u.sendDone:
+		case <-ctx.Done():
+		}
+	}
+
+	if u.conn != nil {
+		u.conn.Close(websocket.StatusGoingAway, shutdownNotice)
+	}
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	b := newBroadcast()
+	b.SetStore(newUserMessageProcessor())
+
+	stopped := make(chan struct{})
+	go func() {
+		b.Start()
+		close(stopped)
+	}()
+
+	user1 := &User{ID: 1, Name: "testing_user1", MessageChannel: make(chan *Message, 32)}
+	user2 := &User{ID: 2, Name: "testing_user2", MessageChannel: make(chan *Message, 32)}
+	b.UserLogin(user1)
+	b.UserLogin(user2)
+
+	// queued but not yet dispatched when the shutdown starts
+	b.Broadcast(NewMessage(user1, MsgTypeNormal, "last words"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := b.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown should succeed, but got %v", err)
+	}
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("the broadcaster should have stopped")
+	}
+
+	if !b.ShuttingDown() {
+		t.Error("the broadcaster should report it is shutting down")
+	}
+
+	for _, user := range []*User{user1, user2} {
+		var got []string
+		for msg := range user.MessageChannel {
+			if msg.Type == MsgTypeNormal || msg.Type == MsgTypeSystem {
+				got = append(got, msg.Content)
+			}
+		}
+
+		want := []string{"last words", shutdownNotice}
+		if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+			t.Errorf("%v should have received %v before its channel closed, but got %v",
+				user.Name, want, got)
+		}
+	}
+
+	msgs, _ := b.Store().Range(Query{})
+	if len(msgs) != 1 || msgs[0].Content != "last words" {
+		t.Errorf("the queued message should have been stored, but got %v", contents(msgs))
+	}
+
+	// nothing blocks once the broadcaster is gone
+	if users := b.GetUserList(); users != nil {
+		t.Errorf("user list should be empty after shutdown, but got %v", users)
+	}
+	b.Broadcast(NewMessage(user1, MsgTypeNormal, "too late"))
+	b.UserLogout(user1)
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/fyerfyer/chatroom/pkg/hub"
+)
+
+// tcpBackplane exchanges the events with the other nodes through a hub server
+type tcpBackplane struct {
+	client *hub.Client
+	events chan *Event
+}
+
+// NewTCPBackplane connects to the hub at addr, see cmd/hub
+func NewTCPBackplane(addr string) (Backplane, error) {
+	bp := &tcpBackplane{
+		events: make(chan *Event, backplaneQueueLength),
+	}
+
+	client, err := hub.Dial(addr, func() {
+		bp.events <- &Event{Type: EventConnected}
+	})
+	if err != nil {
+		return nil, err
+	}
+	bp.client = client
+
+	go bp.readLoop()
+	return bp, nil
+}
+
+func (bp *tcpBackplane) readLoop() {
+	defer close(bp.events)
+
+	for frame := range bp.client.Frames() {
+		var event Event
+		if err := json.Unmarshal(frame, &event); err != nil {
+			log.Printf("backplane: malformed event: %v", err)
+			continue
+		}
+		bp.events <- &event
+	}
+}
+
+func (bp *tcpBackplane) Publish(event *Event) error {
+	frame, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return bp.client.Send(frame)
+}
+
+func (bp *tcpBackplane) Events() <-chan *Event {
+	return bp.events
+}
+
+func (bp *tcpBackplane) Close() error {
+	return bp.client.Close()
+}
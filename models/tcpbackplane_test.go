@@ -0,0 +1,30 @@
+package models
+
+import (
+	"net"
+	"testing"
+
+	"github.com/fyerfyer/chatroom/pkg/hub"
+)
+
+func TestTCPBackplane(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := hub.NewServer()
+	go server.Serve(ln)
+	defer server.Close()
+
+	var nodes []*broadcast
+	for _, node := range []string{"a", "b"} {
+		backplane, err := NewTCPBackplane(ln.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to connect to the hub: %v", err)
+		}
+		nodes = append(nodes, startNodeForTesting(t, backplane, node))
+	}
+
+	checkClusterForTesting(t, nodes[0], nodes[1])
+}
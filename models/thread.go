@@ -0,0 +1,244 @@
+package models
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fyerfyer/chatroom/pkg/utils"
+)
+
+// Thread is kept on the message the replies are attached to
+type Thread struct {
+	Replies     int       `json:"replies"`
+	LastReplyAt time.Time `json:"last_reply_at,omitempty"`
+	// who they are is kept in ThreadFollowers, everyone only sees how many
+	Followers int `json:"follower_count"`
+}
+
+// clone copies the thread, the old one may still be read by whoever got the message
+func (t *Thread) clone() *Thread {
+	if t == nil {
+		return &Thread{}
+	}
+
+	c := *t
+	return &c
+}
+
+// followerStore keeps the followers of each thread by the ID of its first message,
+// the followers get the replies even when they are offline
+type followerStore struct {
+	mu        sync.Mutex
+	path      string
+	followers map[string][]string
+}
+
+var ThreadFollowers = &followerStore{
+	followers: make(map[string][]string),
+}
+
+// Load reads the followers from the json file at path, an empty path keeps them in memory only
+func (s *followerStore) Load(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.path = path
+	s.followers = make(map[string][]string)
+
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &s.followers)
+}
+
+// Get returns the followers of the thread
+func (s *followerStore) Get(id string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.followers[id])
+}
+
+// follow adds the user to the thread and returns how many follow it now,
+// it reports false if the user followed it already
+func (s *followerStore) follow(id, name string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if slices.Contains(s.followers[id], name) {
+		return len(s.followers[id]), false
+	}
+	s.followers[id] = append(s.followers[id], name)
+	s.saveOrLog()
+	return len(s.followers[id]), true
+}
+
+func (s *followerStore) unfollow(id, name string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.followers[id])
+	followers := slices.DeleteFunc(s.followers[id], func(f string) bool { return f == name })
+	if len(followers) == n {
+		return n, false
+	}
+
+	if len(followers) == 0 {
+		delete(s.followers, id)
+	} else {
+		s.followers[id] = followers
+	}
+	s.saveOrLog()
+	return len(followers), true
+}
+
+// rename moves the threads a user follows to its new name
+func (s *followerStore) rename(old, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var changed bool
+	for id, followers := range s.followers {
+		i := slices.Index(followers, old)
+		if i < 0 {
+			continue
+		}
+		if slices.Contains(followers, name) {
+			s.followers[id] = slices.Delete(followers, i, i+1)
+		} else {
+			followers[i] = name
+		}
+		changed = true
+	}
+	if changed {
+		s.saveOrLog()
+	}
+}
+
+func (s *followerStore) saveOrLog() {
+	if s.path == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(s.followers, "", "  ")
+	if err == nil {
+		err = utils.WriteFileAtomic(s.path, data, 0600)
+	}
+	if err != nil {
+		log.Printf("failed to save thread followers: %v", err)
+	}
+}
+
+// threadRoot returns the message a reply is attached to,
+// a reply to a reply goes to the thread of the first one
+func (b *broadcast) threadRoot(id string) (*Message, error) {
+	root, err := b.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if root.ParentID != "" {
+		if root, err = b.store.Get(root.ParentID); err != nil {
+			return nil, err
+		}
+	}
+	if root.Deleted {
+		return nil, ErrMessageDeleted
+	}
+	return root, nil
+}
+
+// addReply counts the stored reply on its thread, tells the room and hands
+// the reply to the followers who did not get it. A follower who left the room
+// gets nothing, an offline one gets it in the inbox of the node the reply was
+// sent on, or of the node it may come back to.
+func (b *broadcast) addReply(reply *Message, local bool) {
+	anyone := func(*Message) error { return nil }
+	root, err := b.changeMessage(reply.ParentID, anyone, func(msg *Message) *Message {
+		msg.Thread = msg.Thread.clone()
+		msg.Thread.Replies++
+		msg.Thread.LastReplyAt = reply.CreatedAt
+		if msg.User != nil {
+			msg.Thread.Followers, _ = ThreadFollowers.follow(msg.ID, msg.User.Name)
+		}
+		msg.Thread.Followers, _ = ThreadFollowers.follow(msg.ID, reply.User.Name)
+		return NewThreadMsg(msg)
+	})
+	if err != nil {
+		log.Printf("failed to add reply to %s: %v", reply.ParentID, err)
+		return
+	}
+
+	delivered := b.recipients(reply)
+	for _, name := range ThreadFollowers.Get(root.ID) {
+		if _, ok := delivered[name]; ok {
+			continue
+		}
+
+		// an online follower not among the recipients has left the room,
+		// an offline one is kept once in the cluster
+		user, online := b.users[name]
+		if online && !user.detached || !online && (!local || b.remoteUser(name)) {
+			continue
+		}
+		if err := b.store.PushInbox(name, reply); err != nil {
+			log.Printf("failed to keep reply for %s: %v", name, err)
+		}
+	}
+}
+
+// followThread adds the user to the followers of the thread, or removes it
+func (b *broadcast) followThread(user *User, id string, follow bool) error {
+	root, err := b.threadRoot(id)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.changeMessage(root.ID, b.canReact(user), func(msg *Message) *Message {
+		count, changed := 0, false
+		if follow {
+			count, changed = ThreadFollowers.follow(msg.ID, user.Name)
+		} else {
+			count, changed = ThreadFollowers.unfollow(msg.ID, user.Name)
+		}
+		if !changed {
+			return nil
+		}
+
+		msg.Thread = msg.Thread.clone()
+		msg.Thread.Followers = count
+		return NewThreadMsg(msg)
+	})
+	return err
+}
+
+// Reply sends a reply to the thread of the message with the ID, in the room of that message
+func (u *User) Reply(parentID, content string, attachments ...string) *Message {
+	msg := withAttachments(NewMessage(u, MsgTypeNormal, content), attachments)
+	msg.ParentID = parentID
+	msg.Ats = mentionRegexp.FindAllString(content, -1)
+
+	Broadcaster.Broadcast(msg)
+	return msg
+}
+
+// Follow makes the user get the replies of the thread wherever it is
+func (b *broadcast) Follow(user *User, id string) error {
+	err, _ := b.do(broadcastOp{typ: OpFollow, user: user, id: id}).(error)
+	return err
+}
+
+func (b *broadcast) Unfollow(user *User, id string) error {
+	err, _ := b.do(broadcastOp{typ: OpUnfollow, user: user, id: id}).(error)
+	return err
+}
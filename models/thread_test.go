@@ -0,0 +1,128 @@
+package models
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestThreadReplies(t *testing.T) {
+	defer clearUserListForTesting()
+	defer ClearUserMsgProcessorForTesting()
+	ClearUserMsgProcessorForTesting()
+
+	alice := &User{ID: 7001, Name: "testing_alice", Room: "thread_room", MessageChannel: make(chan *Message, 32)}
+	bob := &User{ID: 7002, Name: "testing_bob", Room: "thread_room", MessageChannel: make(chan *Message, 32)}
+	Broadcaster.UserLogin(alice)
+	Broadcaster.UserLogin(bob)
+
+	root := alice.Say("", "lunch?")
+	time.Sleep(50 * time.Millisecond)
+	drainMessages(alice)
+	drainMessages(bob)
+
+	reply := bob.Reply(root.ID, "pizza")
+	time.Sleep(50 * time.Millisecond)
+
+	var got, notice *Message
+	for _, msg := range drainMessages(alice) {
+		switch {
+		case msg.ID == reply.ID:
+			got = msg
+		case msg.Type == MsgTypeThread && msg.Ref == root.ID:
+			notice = msg
+		}
+	}
+	if got == nil || got.Room != "thread_room" || got.ParentID != root.ID {
+		t.Errorf("alice should have got the reply in the room, but got %v", got)
+	}
+	if notice == nil || notice.Thread.Replies != 1 || !notice.Thread.LastReplyAt.Equal(reply.CreatedAt) {
+		t.Fatalf("alice should have been told about the reply count, but got %+v", notice)
+	}
+	if notice.Thread.Followers != 2 {
+		t.Errorf("wanted the count of 2 followers, but got %+v", notice.Thread)
+	}
+	if f := ThreadFollowers.Get(root.ID); len(f) != 2 || f[0] != alice.Name || f[1] != bob.Name {
+		t.Errorf("the author and the replier should follow the thread, but got %v", f)
+	}
+
+	// a reply to a reply goes to the first thread
+	nested := alice.Reply(reply.ID, "again?")
+	time.Sleep(50 * time.Millisecond)
+	if nested.ParentID != root.ID {
+		t.Errorf("wanted the reply attached to %v, but got %v", root.ID, nested.ParentID)
+	}
+	stored, _ := UserMessageProcessor.Get(root.ID)
+	if stored.Thread == nil || stored.Thread.Replies != 2 {
+		t.Errorf("wanted 2 replies stored on the thread, but got %+v", stored.Thread)
+	}
+	replies, _ := UserMessageProcessor.Range(Query{Parent: root.ID})
+	if len(replies) != 2 {
+		t.Errorf("wanted the 2 replies of the thread, but got %v", contents(replies))
+	}
+
+	bob.Reply("missing", "hello?")
+	time.Sleep(50 * time.Millisecond)
+	if got := lastOfType(drainMessages(bob), MsgTypeError); got != ErrMessageNotFound.Error() {
+		t.Errorf("wanted %q for a reply to nothing, but got %q", ErrMessageNotFound, got)
+	}
+}
+
+func TestFollowThread(t *testing.T) {
+	defer clearUserListForTesting()
+	defer ClearUserMsgProcessorForTesting()
+	ClearUserMsgProcessorForTesting()
+
+	alice := &User{ID: 7003, Name: "testing_alice", Room: "thread_room", MessageChannel: make(chan *Message, 32)}
+	bob := &User{ID: 7004, Name: "testing_bob", Room: "thread_room", MessageChannel: make(chan *Message, 32)}
+	carol := &User{ID: 7005, Name: "testing_carol", Room: "thread_room", MessageChannel: make(chan *Message, 32)}
+	outsider := &User{ID: 7006, Name: "testing_dave", Room: "other_room", MessageChannel: make(chan *Message, 32)}
+	for _, user := range []*User{alice, bob, carol, outsider} {
+		Broadcaster.UserLogin(user)
+	}
+
+	root := alice.Say("", "release plan")
+	time.Sleep(50 * time.Millisecond)
+
+	if err := Broadcaster.Follow(outsider, root.ID); err != ErrNotInRoom {
+		t.Errorf("wanted %v for a user outside the room, but got %v", ErrNotInRoom, err)
+	}
+	if err := Broadcaster.Follow(carol, root.ID); err != nil {
+		t.Fatalf("failed to follow the thread: %v", err)
+	}
+
+	// carol is offline and still gets the reply, alice left the room and does not
+	Broadcaster.UserLogout(carol)
+	Broadcaster.LeaveRoom(alice, "thread_room")
+	time.Sleep(50 * time.Millisecond)
+	drainMessages(alice)
+
+	reply := bob.Reply(root.ID, "friday")
+	time.Sleep(50 * time.Millisecond)
+
+	if msgs := drainMessages(alice); len(msgs) != 0 {
+		t.Errorf("alice should not get the replies of a room she left, but got %v", contents(msgs))
+	}
+
+	carol = &User{ID: 7005, Name: "testing_carol", Room: "other_room", MessageChannel: make(chan *Message, 32)}
+	Broadcaster.UserLogin(carol)
+	var inboxed bool
+	for _, msg := range drainMessages(carol) {
+		inboxed = inboxed || msg.ID == reply.ID
+	}
+	if !inboxed {
+		t.Error("carol should have got the reply from her inbox")
+	}
+
+	// bob replied so bob follows, until he stops
+	if err := Broadcaster.Unfollow(bob, root.ID); err != nil {
+		t.Fatalf("failed to unfollow the thread: %v", err)
+	}
+	if f := ThreadFollowers.Get(root.ID); slices.Contains(f, bob.Name) {
+		t.Errorf("bob should not follow the thread anymore, but got %v", f)
+	}
+	stored, _ := UserMessageProcessor.Get(root.ID)
+	if stored.Thread.Followers != 2 {
+		t.Errorf("wanted alice and carol counted, but got %+v", stored.Thread)
+	}
+}
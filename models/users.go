@@ -1,14 +1,17 @@
 package models
 
 import (
+	"encoding/json"
 	"errors"
 	"io"
+	"log"
 	"regexp"
-	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/fyerfyer/chatroom/pkg/ratelimit"
 	"github.com/fyerfyer/chatroom/pkg/setting"
+	"github.com/fyerfyer/chatroom/pkg/utils"
 	"github.com/gin-gonic/gin"
 	"nhooyr.io/websocket"
 	"nhooyr.io/websocket/wsjson"
@@ -17,25 +20,89 @@ import (
 var globalUserID uint32 = 0
 var System = &User{}
 
+var mentionRegexp = regexp.MustCompile(`@[^\s@]{2,20}`)
+
+var (
+	ErrMalformedFrame = errors.New("malformed frame")
+	ErrMissingContent = errors.New("missing content")
+	ErrRateLimited    = errors.New("too many messages, slow down")
+)
+
+// violations older than this are forgiven
+const violationWindow = time.Minute
+
 type User struct {
 	ID             int           `json:"id"`
 	Name           string        `json:"name"`
+	Account        string        `json:"account,omitempty"`
 	CreatedAt      time.Time     `json:"created_at"`
 	Addr           string        `json:"address"`
+	Room           string        `json:"room"`
+	Role           string        `json:"role,omitempty"`
+	IsBot          bool          `json:"bot,omitempty"`
 	MessageChannel chan *Message `json:"-"`
 
+	// Presence is the one the user chose or went into, the others see shownPresence of it
+	Presence string `json:"-"`
+
+	// OverflowPolicy overrides the configured policy for a full message queue
+	OverflowPolicy string `json:"-"`
+
 	conn     *websocket.Conn `json:"-"`
 	IsOnline bool            `json:"-"`
+
+	// set by the broadcaster once the user is being disconnected
+	evicted bool
+
+	// session resume, the token and the detached flag belong to the broadcaster
+	resume       *resumeRequest
+	resumeToken  string
+	detached     bool
+	leftNormally bool
+
+	// unix nano time until which the user's frames are rejected
+	mutedUntil atomic.Int64
+
+	// the message rate limit, only FetchMessage touches the violations
+	limiter       *ratelimit.Bucket
+	violations    int
+	lastViolation time.Time
+
+	frameHandler FrameHandler
+
+	// closed once SendMessage has written everything queued
+	sendDone chan struct{}
+
+	// the longest the message queue has been, only SendMessage writes it
+	queueHighWater atomic.Int64
+
+	// the presence the user chose, the broadcaster derives Presence from it
+	chosenPresence string
+	// unix nano time of the last frame, and whether the user went away for it
+	lastActive atomic.Int64
+	idle       atomic.Bool
+
+	// when the user last told each room or user it was typing, only FetchMessage touches it
+	typingSent map[string]time.Time
 }
 
+// FrameHandler handles a frame read from the user's connection,
+// an error ends the session
+type FrameHandler func(u *User, frame json.RawMessage) error
+
 func NewUser(conn *websocket.Conn, name, addr string) *User {
 	user := &User{
 		Name:           name,
+		Account:        name,
 		CreatedAt:      time.Now(),
 		MessageChannel: make(chan *Message, setting.UserMessageQueueLength),
 		Addr:           addr,
+		Room:           setting.DefaultRoom,
 		conn:           conn,
+		sendDone:       make(chan struct{}),
+		limiter:        ratelimit.NewBucket(setting.MessageRate, setting.MessageBurst),
 	}
+	user.lastActive.Store(user.CreatedAt.UnixNano())
 
 	if user.ID == 0 {
 		// set user id if it haven't been set
@@ -45,9 +112,57 @@ func NewUser(conn *websocket.Conn, name, addr string) *User {
 	return user
 }
 
+// account returns the name the user logged in with,
+// the users made up without one are known by their name
+func (u *User) account() string {
+	if u.Account != "" {
+		return u.Account
+	}
+	return u.Name
+}
+
+// snapshot copies what the messages show of the user. The user goes on changing
+// on the broadcaster while every recipient marshals the messages it sent, so
+// they never point to it. The presence is left for the broadcaster to fill in.
+func (u *User) snapshot() *User {
+	if u == nil || u == System {
+		return u
+	}
+
+	return &User{
+		ID:        u.ID,
+		Name:      u.Name,
+		Account:   u.Account,
+		CreatedAt: u.CreatedAt,
+		Addr:      u.Addr,
+		Room:      u.Room,
+		Role:      u.Role,
+		IsBot:     u.IsBot,
+	}
+}
+
+// withPresence is the snapshot of the user with its presence, only the broadcaster reads it
+func (u *User) withPresence() *User {
+	snapshot := u.snapshot()
+	snapshot.Presence = u.Presence
+	return snapshot
+}
+
 func (u *User) SendMessage(c *gin.Context) {
+	if u.sendDone != nil {
+		defer close(u.sendDone)
+	}
+	defer func() {
+		connectionDuration.Observe(time.Since(u.CreatedAt).Seconds())
+	}()
+
 	// log.Println("start sending message...")
 	for msg := range u.MessageChannel {
+		// the message just taken counts as well
+		if n := int64(len(u.MessageChannel) + 1); n > u.queueHighWater.Load() {
+			u.queueHighWater.Store(n)
+		}
+
 		// log.Printf("sending message:%v", msg)
 		wsjson.Write(c, u.conn, msg)
 	}
@@ -57,16 +172,21 @@ func (u *User) CloseChannel() {
 	close(u.MessageChannel)
 }
 
-func (u *User) FetchMessage(c *gin.Context) error {
-	var msg map[string]interface{}
-	var wg sync.WaitGroup
+// SetFrameHandler replaces the handling of the frames read in FetchMessage
+func (u *User) SetFrameHandler(handler FrameHandler) {
+	u.frameHandler = handler
+}
 
+func (u *User) FetchMessage(c *gin.Context) error {
 	for {
-		err := wsjson.Read(c, u.conn, &msg)
+		var frame json.RawMessage
+		err := wsjson.Read(c, u.conn, &frame)
 		if err != nil {
 			var closeErr websocket.CloseError
 			switch {
 			case errors.As(err, &closeErr):
+				u.leftNormally = closeErr.Code == websocket.StatusNormalClosure ||
+					closeErr.Code == websocket.StatusGoingAway
 				return nil
 			case errors.Is(err, io.EOF):
 				return nil
@@ -75,19 +195,114 @@ func (u *User) FetchMessage(c *gin.Context) error {
 			}
 		}
 
-		// send the message to the chatroom
-		sendMsg := NewMessage(u, MsgTypeNormal, msg["content"].(string))
-		reg := regexp.MustCompile(`@[^\s@]{2,20}`)
-		sendMsg.Ats = reg.FindAllString(sendMsg.Content, -1)
-
-		// broadcast the message
-		// since the broadcast uses goroutine
-		// we use sync to make it fully executed
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			Broadcaster.Broadcast(sendMsg)
-		}()
-		wg.Wait()
+		u.touch()
+
+		if u.Muted() {
+			u.Notify(NewErrorMsg(ErrMuted.Error()))
+			continue
+		}
+
+		if !u.limiter.Allow() {
+			if u.violate() {
+				log.Printf("disconnecting %s for flooding", u.Name)
+
+				// the session is over for good, it must not be resumed
+				u.leftNormally = true
+				u.conn.Close(websocket.StatusPolicyViolation, ErrRateLimited.Error())
+				return nil
+			}
+
+			u.Notify(NewErrorMsg(ErrRateLimited.Error()))
+			continue
+		}
+
+		handle := u.frameHandler
+		if handle == nil {
+			handle = handlePlainFrame
+		}
+
+		if err := handle(u, frame); err != nil {
+			return err
+		}
+	}
+}
+
+// violate counts a frame over the rate limit,
+// it reports whether the user should be disconnected
+func (u *User) violate() bool {
+	now := time.Now()
+	if now.Sub(u.lastViolation) > violationWindow {
+		u.violations = 0
+	}
+
+	u.violations++
+	u.lastViolation = now
+	return setting.MaxViolations > 0 && u.violations >= setting.MaxViolations
+}
+
+// handlePlainFrame treats the frame as an object carrying the content,
+// with an optional room or recipient
+func handlePlainFrame(u *User, frame json.RawMessage) error {
+	var msg map[string]interface{}
+	if err := json.Unmarshal(frame, &msg); err != nil {
+		u.Notify(NewErrorMsg(ErrMalformedFrame.Error()))
+		return nil
+	}
+
+	content, ok := msg["content"].(string)
+	if !ok {
+		u.Notify(NewErrorMsg(ErrMissingContent.Error()))
+		return nil
+	}
+
+	// a message with a parent is a reply in its thread
+	if parentID, ok := msg["parent_id"].(string); ok && parentID != "" {
+		u.Reply(parentID, content)
+		return nil
+	}
+
+	// a message with a recipient only goes to that user
+	if to, ok := msg["to"].(string); ok && to != "" {
+		if err := u.Whisper(to, content); err != nil {
+			u.Notify(NewErrorMsg(err.Error()))
+		}
+		return nil
+	}
+
+	// send the message to the room it is addressed to,
+	// or to the user's own room by default
+	room, _ := msg["room"].(string)
+	u.Send(room, content)
+	return nil
+}
+
+// Say sends a chat message to the room, or to the user's own room if it is empty,
+// with the uploads of the user named by attachments
+func (u *User) Say(room, content string, attachments ...string) *Message {
+	if room == "" {
+		room = u.Room
 	}
+
+	msg := withAttachments(NewMessage(u, MsgTypeNormal, content), attachments)
+	msg.Room = room
+	msg.Ats = mentionRegexp.FindAllString(content, -1)
+
+	Broadcaster.Broadcast(msg)
+	return msg
+}
+
+// Whisper sends a private message to the named user
+func (u *User) Whisper(to, content string, attachments ...string) error {
+	if err := utils.ValidateName(to); err != nil {
+		return err
+	}
+
+	Broadcaster.Broadcast(withAttachments(NewPrivateMsg(u, to, content), attachments))
+	return nil
+}
+
+// Notify sends the message to this user only
+func (u *User) Notify(msg *Message) {
+	msg.To = u.Name
+	Broadcaster.Broadcast(msg)
 }
@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/fyerfyer/chatroom/pkg/setting"
 	"github.com/gin-gonic/gin"
 	"nhooyr.io/websocket"
 	"nhooyr.io/websocket/wsjson"
@@ -251,3 +252,118 @@ func TestUserConcurrency(t *testing.T) {
 		}
 	}()
 }
+
+func TestFetchMessageWithoutContent(t *testing.T) {
+	user := &User{}
+	fetched := make(chan error, 1)
+
+	r := gin.Default()
+	r.GET("/ws", func(c *gin.Context) {
+		conn, err := websocket.Accept(c.Writer, c.Request, nil)
+		if err != nil {
+			t.Fatalf("failed to accept websocket connection: %v", err)
+		}
+		defer conn.Close(websocket.StatusInternalError, "connection closed")
+		user = NewUser(conn, "testing_user", "127.0.0.1")
+		loginUserWithoutSendingMessage(user)
+		fetched <- user.FetchMessage(c)
+	})
+
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	url := "ws://" + server.Listener.Addr().String() + "/ws"
+	conn, _, err := websocket.Dial(ctx, url, nil)
+	if err != nil {
+		t.Fatalf("failed to dial websocket: %v", err)
+	}
+
+	// a frame without content is answered instead of crashing the handler
+	wsjson.Write(ctx, conn, map[string]string{"room": "lobby"})
+	conn.Close(websocket.StatusNormalClosure, "test completed")
+
+	select {
+	case err := <-fetched:
+		if err != nil {
+			t.Errorf("fetching should end without error, but got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("timeout waiting for fetching to end")
+	}
+}
+
+func TestFetchMessageRateLimited(t *testing.T) {
+	defer clearUserListForTesting()
+	defer func(rate float64, burst, violations int) {
+		setting.MessageRate, setting.MessageBurst, setting.MaxViolations = rate, burst, violations
+	}(setting.MessageRate, setting.MessageBurst, setting.MaxViolations)
+	setting.MessageRate, setting.MessageBurst, setting.MaxViolations = 0.001, 1, 3
+
+	user := &User{}
+	fetched := make(chan error, 1)
+
+	r := gin.Default()
+	r.GET("/ws", func(c *gin.Context) {
+		conn, err := websocket.Accept(c.Writer, c.Request, nil)
+		if err != nil {
+			t.Fatalf("failed to accept websocket connection: %v", err)
+		}
+		defer conn.Close(websocket.StatusInternalError, "connection closed")
+		user = NewUser(conn, "testing_user", "127.0.0.1")
+		loginUserWithoutSendingMessage(user)
+		fetched <- user.FetchMessage(c)
+	})
+
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	url := "ws://" + server.Listener.Addr().String() + "/ws"
+	conn, _, err := websocket.Dial(ctx, url, nil)
+	if err != nil {
+		t.Fatalf("failed to dial websocket: %v", err)
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "test completed")
+
+	// the first frame uses the burst, the next ones are violations
+	for i := 0; i < 4; i++ {
+		wsjson.Write(ctx, conn, map[string]string{"content": "flood" + strconv.Itoa(i)})
+	}
+
+	_, _, err = conn.Read(ctx)
+	if status := websocket.CloseStatus(err); status != websocket.StatusPolicyViolation {
+		t.Errorf("a flooding client should be closed with %v, but got %v", websocket.StatusPolicyViolation, err)
+	}
+
+	select {
+	case err := <-fetched:
+		if err != nil {
+			t.Errorf("fetching should end without error, but got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for fetching to end")
+	}
+
+	if !user.LeftNormally() {
+		t.Error("a disconnected flooder should not be able to resume the session")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	var normal, limited int
+	for _, msg := range drainMessages(user) {
+		switch {
+		case msg.Type == MsgTypeNormal:
+			normal++
+		case msg.Type == MsgTypeError && msg.Content == ErrRateLimited.Error():
+			limited++
+		}
+	}
+	if normal != 1 || limited != 2 {
+		t.Errorf("wanted 1 message and 2 rate limit errors, but got %v and %v", normal, limited)
+	}
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fyerfyer/chatroom/pkg/setting"
+)
+
+func TestPasswordHash(t *testing.T) {
+	hash, err := HashPassword("secret_password")
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+
+	if err := CheckPassword(hash, "secret_password"); err != nil {
+		t.Errorf("the right password should pass: %v", err)
+	}
+	if err := CheckPassword(hash, "wrong_password"); err == nil {
+		t.Error("a wrong password should not pass")
+	}
+
+	// the hash is salted
+	if other, _ := HashPassword("secret_password"); other == hash {
+		t.Error("hashing the same password twice should give different hashes")
+	}
+}
+
+func TestToken(t *testing.T) {
+	token, expiresAt, err := IssueToken("testing_user")
+	if err != nil {
+		t.Fatalf("failed to issue token: %v", err)
+	}
+	if !expiresAt.After(time.Now()) {
+		t.Errorf("token should expire in the future, but expires at %v", expiresAt)
+	}
+
+	claims, err := ParseToken(token)
+	if err != nil {
+		t.Fatalf("failed to parse token: %v", err)
+	}
+	if claims.Name != "testing_user" {
+		t.Errorf("wanted name testing_user, but got %v", claims.Name)
+	}
+
+	// change a character of the payload
+	forged := []byte(token)
+	forged[0] ^= 1
+	if _, err := ParseToken(string(forged)); err != ErrInvalidToken {
+		t.Errorf("wanted error %v for a forged token, but got %v", ErrInvalidToken, err)
+	}
+}
+
+func TestExpiredToken(t *testing.T) {
+	expire := setting.TokenExpire
+	setting.TokenExpire = -time.Minute
+	defer func() { setting.TokenExpire = expire }()
+
+	token, _, err := IssueToken("testing_user")
+	if err != nil {
+		t.Fatalf("failed to issue token: %v", err)
+	}
+
+	if _, err := ParseToken(token); err != ErrTokenExpired {
+		t.Errorf("wanted error %v, but got %v", ErrTokenExpired, err)
+	}
+}
@@ -0,0 +1,96 @@
+// Package blob keeps the content of uploaded files
+package blob
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrNotFound  = errors.New("blob not found")
+	ErrInvalidID = errors.New("invalid blob id")
+)
+
+// Store keeps blobs by ID, a blob is never changed once it is put
+type Store interface {
+	Put(id string, r io.Reader) (int64, error)
+	Open(id string) (io.ReadSeekCloser, error)
+	Delete(id string) error
+}
+
+// Disk keeps every blob in a file of its directory
+type Disk struct {
+	dir string
+}
+
+// NewDisk returns a store of the blobs in dir, which is created if needed
+func NewDisk(dir string) (*Disk, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Disk{dir: dir}, nil
+}
+
+// path returns the file of the blob, the ID must not leave the directory
+func (d *Disk) path(id string) (string, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return "", ErrInvalidID
+	}
+	return filepath.Join(d.dir, id), nil
+}
+
+// Put writes the blob and returns its size,
+// nothing is kept if the reader fails
+func (d *Disk) Put(id string, r io.Reader) (int64, error) {
+	path, err := d.path(id)
+	if err != nil {
+		return 0, err
+	}
+
+	// write to a temporary file first so a failed upload never leaves half a blob
+	tmp, err := os.CreateTemp(d.dir, "upload-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+
+	return n, os.Rename(tmp.Name(), path)
+}
+
+func (d *Disk) Open(id string) (io.ReadSeekCloser, error) {
+	path, err := d.path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// Delete removes the blob, a missing one is not an error
+func (d *Disk) Delete(id string) error {
+	path, err := d.path(id)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package blob
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDisk(t *testing.T) {
+	dir := t.TempDir()
+	d, err := NewDisk(dir)
+	if err != nil {
+		t.Fatalf("failed to open the store: %v", err)
+	}
+
+	n, err := d.Put("abc", strings.NewReader("hello"))
+	if err != nil || n != 5 {
+		t.Fatalf("wanted 5 bytes put, but got %v, %v", n, err)
+	}
+
+	f, err := d.Open("abc")
+	if err != nil {
+		t.Fatalf("failed to open the blob: %v", err)
+	}
+	data, _ := io.ReadAll(f)
+	f.Close()
+	if string(data) != "hello" {
+		t.Errorf("wanted %q, but got %q", "hello", data)
+	}
+
+	if err := d.Delete("abc"); err != nil {
+		t.Fatalf("failed to delete the blob: %v", err)
+	}
+	if _, err := d.Open("abc"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("wanted %v for a deleted blob, but got %v", ErrNotFound, err)
+	}
+	if err := d.Delete("abc"); err != nil {
+		t.Errorf("deleting a missing blob should not fail, but got %v", err)
+	}
+
+	for _, id := range []string{"", "../abc", "a/b", ".."} {
+		if _, err := d.Put(id, strings.NewReader("x")); !errors.Is(err, ErrInvalidID) {
+			t.Errorf("wanted %v for id %q, but got %v", ErrInvalidID, id, err)
+		}
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestDiskFailedPut(t *testing.T) {
+	dir := t.TempDir()
+	d, _ := NewDisk(dir)
+
+	if _, err := d.Put("abc", io.MultiReader(strings.NewReader("half"), failingReader{})); err == nil {
+		t.Fatal("a failing reader should fail the put")
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("nothing should be left of a failed put, but got %v", entries)
+	}
+}
@@ -0,0 +1,31 @@
+// Package bots holds the bots that ship with the server
+package bots
+
+import (
+	"fmt"
+
+	"github.com/fyerfyer/chatroom/models"
+)
+
+// New returns the sample bot of that name living in the given rooms
+func New(name string, rooms []string) (models.Bot, error) {
+	switch name {
+	case "dice":
+		return NewDice(rooms), nil
+	case "greeter":
+		return NewGreeter(rooms), nil
+	}
+
+	return nil, fmt.Errorf("unknown bot %q", name)
+}
+
+// replyRoom is the room a reply to msg goes to, empty for a private message
+func replyRoom(self *models.User, msg *models.Message) string {
+	if msg.Type == models.MsgTypePrivate {
+		return ""
+	}
+	if msg.Room == "" {
+		return self.Room
+	}
+	return msg.Room
+}
@@ -0,0 +1,99 @@
+package bots
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fyerfyer/chatroom/models"
+)
+
+func init() {
+	go models.Broadcaster.Start()
+	time.Sleep(50 * time.Millisecond)
+}
+
+// waitContent waits for a chat message of the named user containing the text
+func waitContent(user *models.User, from, text string) *models.Message {
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case msg := <-user.MessageChannel:
+			if msg.User != nil && msg.User.Name == from && strings.Contains(msg.Content, text) {
+				return msg
+			}
+		case <-timeout:
+			return nil
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, name := range []string{"dice", "greeter"} {
+		if bot, err := New(name, nil); err != nil || bot.Name() != name {
+			t.Errorf("wanted the %v bot, but got %v, %v", name, bot, err)
+		}
+	}
+
+	if _, err := New("butler", nil); err == nil {
+		t.Error("an unknown bot should not be created")
+	}
+}
+
+func TestDice(t *testing.T) {
+	dice := NewDice([]string{"dice_room"})
+	dice.roll = func(sides int) int { return sides }
+
+	self, err := models.Broadcaster.RegisterBot(dice)
+	if err != nil {
+		t.Fatalf("failed to register the bot: %v", err)
+	}
+	defer models.Broadcaster.UserLogout(self)
+
+	user := &models.User{ID: 2001, Name: "dice_user", Room: "dice_room", MessageChannel: make(chan *models.Message, 32)}
+	models.Broadcaster.UserLogin(user)
+	defer models.Broadcaster.UserLogout(user)
+
+	tests := []struct {
+		ask, want string
+	}{
+		{"!roll", "@dice_user rolled 6"},
+		{"!roll 3d4", "@dice_user rolled 4 + 4 + 4 = 12"},
+		{"!roll d20", "@dice_user rolled 20"},
+		{"!roll 100d6", "usage"},
+		{"!roll two", "usage"},
+	}
+
+	for _, test := range tests {
+		user.Say("", test.ask)
+		if msg := waitContent(user, "dice", test.want); msg == nil || msg.Room != "dice_room" {
+			t.Errorf("wanted %q for %q in dice_room, but got %v", test.want, test.ask, msg)
+		}
+	}
+
+	user.Whisper("dice", "!roll 2d6")
+	if msg := waitContent(user, "dice", "rolled 6 + 6 = 12"); msg == nil || msg.Type != models.MsgTypePrivate {
+		t.Errorf("a private roll should be answered privately, but got %v", msg)
+	}
+
+	user.Say("", "!rolling along")
+	if msg := waitContent(user, "dice", ""); msg != nil {
+		t.Errorf("the dice should only answer !roll, but got %v", msg)
+	}
+}
+
+func TestGreeter(t *testing.T) {
+	self, err := models.Broadcaster.RegisterBot(NewGreeter([]string{"greeter_room"}))
+	if err != nil {
+		t.Fatalf("failed to register the bot: %v", err)
+	}
+	defer models.Broadcaster.UserLogout(self)
+
+	user := &models.User{ID: 2002, Name: "greeter_user", Room: "greeter_room", MessageChannel: make(chan *models.Message, 32)}
+	models.Broadcaster.UserLogin(user)
+	defer models.Broadcaster.UserLogout(user)
+
+	if msg := waitContent(user, "greeter", "welcome to greeter_room, @greeter_user!"); msg == nil {
+		t.Error("the greeter should welcome the user")
+	}
+}
@@ -0,0 +1,98 @@
+package bots
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+
+	"github.com/fyerfyer/chatroom/models"
+)
+
+const (
+	maxDice  = 20
+	maxSides = 1000
+)
+
+// Dice rolls dice for whoever asks with "!roll 2d6", a single d6 by default
+type Dice struct {
+	rooms []string
+	roll  func(sides int) int
+}
+
+func NewDice(rooms []string) *Dice {
+	return &Dice{
+		rooms: rooms,
+		roll:  func(sides int) int { return rand.IntN(sides) + 1 },
+	}
+}
+
+func (d *Dice) Name() string { return "dice" }
+
+func (d *Dice) Rooms() []string { return d.rooms }
+
+func (d *Dice) Handle(self *models.User, msg *models.Message) {
+	if msg.Type != models.MsgTypeNormal && msg.Type != models.MsgTypePrivate {
+		return
+	}
+
+	arg, ok := strings.CutPrefix(msg.Content, "!roll")
+	if !ok || (arg != "" && arg[0] != ' ') {
+		return
+	}
+
+	reply := d.rollAll(strings.TrimSpace(arg))
+	if room := replyRoom(self, msg); room != "" {
+		self.Say(room, fmt.Sprintf("@%s %s", msg.User.Name, reply))
+	} else {
+		self.Whisper(msg.User.Name, reply)
+	}
+}
+
+// rollAll rolls the dice of a "2d6" spec and describes the result
+func (d *Dice) rollAll(spec string) string {
+	count, sides, err := parseDice(spec)
+	if err != nil {
+		return err.Error()
+	}
+
+	rolls := make([]string, count)
+	total := 0
+	for i := range rolls {
+		n := d.roll(sides)
+		rolls[i] = strconv.Itoa(n)
+		total += n
+	}
+
+	if count == 1 {
+		return fmt.Sprintf("rolled %d", total)
+	}
+	return fmt.Sprintf("rolled %s = %d", strings.Join(rolls, " + "), total)
+}
+
+func parseDice(spec string) (count, sides int, err error) {
+	if spec == "" {
+		return 1, 6, nil
+	}
+
+	usage := fmt.Errorf("usage: !roll <count>d<sides>, up to %dd%d", maxDice, maxSides)
+	c, s, ok := strings.Cut(strings.ToLower(spec), "d")
+	if !ok {
+		return 0, 0, usage
+	}
+
+	count = 1
+	if c != "" {
+		if count, err = strconv.Atoi(c); err != nil {
+			return 0, 0, usage
+		}
+	}
+	if sides, err = strconv.Atoi(s); err != nil {
+		return 0, 0, usage
+	}
+
+	if count < 1 || count > maxDice || sides < 2 || sides > maxSides {
+		return 0, 0, usage
+	}
+	return count, sides, nil
+}
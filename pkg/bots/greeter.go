@@ -0,0 +1,30 @@
+package bots
+
+import (
+	"fmt"
+
+	"github.com/fyerfyer/chatroom/models"
+)
+
+// Greeter welcomes the users entering its rooms
+type Greeter struct {
+	rooms []string
+}
+
+func NewGreeter(rooms []string) *Greeter {
+	return &Greeter{rooms: rooms}
+}
+
+func (g *Greeter) Name() string { return "greeter" }
+
+func (g *Greeter) Rooms() []string { return g.rooms }
+
+func (g *Greeter) Handle(self *models.User, msg *models.Message) {
+	// other bots come and go without a word
+	if msg.Type != models.MsgTypeUserLogin || msg.User == nil || msg.User.IsBot {
+		return
+	}
+
+	room := replyRoom(self, msg)
+	self.Say(room, fmt.Sprintf("welcome to %s, @%s!", room, msg.User.Name))
+}
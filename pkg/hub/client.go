@@ -0,0 +1,165 @@
+package hub
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// how long the client waits before dialing the hub again
+const redialInterval = time.Second
+
+var (
+	ErrClientClosed = errors.New("hub: client closed")
+	ErrQueueFull    = errors.New("hub: send queue is full")
+)
+
+// Client is the connection of a node to the hub, it dials again
+// whenever the connection is lost until it is closed
+type Client struct {
+	addr      string
+	onConnect func()
+
+	out    chan []byte
+	frames chan []byte
+
+	closeOnce sync.Once
+	done      chan struct{}
+	stopped   chan struct{}
+}
+
+// Dial connects to the hub at addr, onConnect is called
+// every time the connection is made, including the first one
+func Dial(addr string, onConnect func()) (*Client, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		addr:      addr,
+		onConnect: onConnect,
+		out:       make(chan []byte, peerQueueLength),
+		frames:    make(chan []byte, peerQueueLength),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+
+	go c.run(conn)
+	return c, nil
+}
+
+// Send queues the frame for the hub without blocking,
+// frames sent while the hub is unreachable are sent once it is back
+func (c *Client) Send(frame []byte) error {
+	select {
+	case <-c.done:
+		return ErrClientClosed
+	default:
+	}
+
+	select {
+	case c.out <- frame:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Frames returns the frames the other nodes sent, it is closed with the client
+func (c *Client) Frames() <-chan []byte {
+	return c.frames
+}
+
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+	<-c.stopped
+	return nil
+}
+
+func (c *Client) run(conn net.Conn) {
+	defer close(c.stopped)
+	defer close(c.frames)
+
+	for {
+		if c.onConnect != nil {
+			c.onConnect()
+		}
+
+		if !c.serve(conn) {
+			return
+		}
+
+		log.Printf("hub: lost the connection to %s", c.addr)
+		if conn = c.redial(); conn == nil {
+			return
+		}
+	}
+}
+
+// serve exchanges frames over conn until it breaks or the client is closed,
+// it reports whether the client should dial again
+func (c *Client) serve(conn net.Conn) bool {
+	readErr := make(chan struct{})
+	go func() {
+		defer close(readErr)
+
+		scanner := bufio.NewScanner(conn)
+		scanner.Buffer(make([]byte, 4096), maxFrameSize)
+		for scanner.Scan() {
+			frame := append([]byte(nil), scanner.Bytes()...)
+			select {
+			case c.frames <- frame:
+			case <-c.done:
+				return
+			}
+		}
+	}()
+	defer func() {
+		conn.Close()
+		<-readErr
+	}()
+
+	w := bufio.NewWriter(conn)
+	for {
+		select {
+		case frame := <-c.out:
+			w.Write(frame)
+			w.WriteByte('\n')
+			if len(c.out) > 0 {
+				continue
+			}
+			if err := w.Flush(); err != nil {
+				return true
+			}
+
+		case <-readErr:
+			return true
+
+		case <-c.done:
+			w.Flush()
+			return false
+		}
+	}
+}
+
+func (c *Client) redial() net.Conn {
+	for {
+		select {
+		case <-time.After(redialInterval):
+		case <-c.done:
+			return nil
+		}
+
+		conn, err := net.Dial("tcp", c.addr)
+		if err == nil {
+			log.Printf("hub: connected to %s again", c.addr)
+			return conn
+		}
+	}
+}
@@ -0,0 +1,187 @@
+// Package hub relays frames between the nodes of a chatroom cluster over TCP.
+// A frame is a JSON object on a single line, the hub forwards every frame
+// a node sends to all the other nodes without looking into it.
+package hub
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"sync"
+)
+
+// TypeNodeGone is the type of the frame the hub sends once a node disconnects
+const TypeNodeGone = "node_gone"
+
+// the largest frame a node may send
+const maxFrameSize = 1 << 20
+
+// how many frames wait for a slow node before new ones are dropped
+const peerQueueLength = 1024
+
+var ErrServerClosed = errors.New("hub: server closed")
+
+// header is the part of a frame the hub reads to learn the node behind a connection
+type header struct {
+	Type string `json:"type"`
+	Node string `json:"node"`
+}
+
+type Server struct {
+	mu     sync.Mutex
+	peers  map[*peer]struct{}
+	ln     net.Listener
+	closed bool
+}
+
+type peer struct {
+	conn net.Conn
+	node string
+	out  chan []byte
+}
+
+func NewServer() *Server {
+	return &Server{
+		peers: make(map[*peer]struct{}),
+	}
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(ln)
+}
+
+// Serve accepts node connections on ln until the server is closed
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	}
+	s.ln = ln
+	s.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		go s.handle(conn)
+	}
+}
+
+// Close stops accepting nodes and disconnects the connected ones
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for p := range s.peers {
+		p.conn.Close()
+	}
+
+	if s.ln != nil {
+		return s.ln.Close()
+	}
+	return nil
+}
+
+func (s *Server) handle(conn net.Conn) {
+	p := &peer{
+		conn: conn,
+		out:  make(chan []byte, peerQueueLength),
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.peers[p] = struct{}{}
+	s.mu.Unlock()
+
+	go p.writeLoop()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), maxFrameSize)
+	for scanner.Scan() {
+		frame := append([]byte(nil), scanner.Bytes()...)
+
+		// the first frame that names a node tells who is on this connection
+		if p.node == "" {
+			var h header
+			if err := json.Unmarshal(frame, &h); err != nil {
+				log.Printf("hub: malformed frame from %v: %v", conn.RemoteAddr(), err)
+				continue
+			}
+			p.node = h.Node
+		}
+
+		s.relay(p, frame)
+	}
+
+	s.mu.Lock()
+	delete(s.peers, p)
+	s.mu.Unlock()
+	close(p.out)
+	conn.Close()
+
+	// the other nodes forget the users of a node that went away
+	if p.node != "" {
+		gone, _ := json.Marshal(header{Type: TypeNodeGone, Node: p.node})
+		s.relay(p, gone)
+	}
+}
+
+// relay queues the frame for every node but the one it came from
+func (s *Server) relay(from *peer, frame []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for p := range s.peers {
+		if p == from {
+			continue
+		}
+
+		select {
+		case p.out <- frame:
+		default:
+			log.Printf("hub: dropping frame for slow node %s", p.node)
+		}
+	}
+}
+
+func (p *peer) writeLoop() {
+	w := bufio.NewWriter(p.conn)
+	for frame := range p.out {
+		w.Write(frame)
+		w.WriteByte('\n')
+
+		// write the frames in batches while more are queued
+		if len(p.out) == 0 {
+			if err := w.Flush(); err != nil {
+				p.conn.Close()
+				break
+			}
+		}
+	}
+
+	// keep draining so relay never blocks on a dead node
+	for range p.out {
+	}
+}
@@ -0,0 +1,120 @@
+package hub
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+func startServerForTesting(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewServer()
+	go server.Serve(ln)
+	t.Cleanup(func() { server.Close() })
+	return ln.Addr().String()
+}
+
+func dialForTesting(t *testing.T, addr string) *Client {
+	client, err := Dial(addr, nil)
+	if err != nil {
+		t.Fatalf("failed to dial the hub: %v", err)
+	}
+
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func readFrame(t *testing.T, client *Client) header {
+	t.Helper()
+
+	select {
+	case frame := <-client.Frames():
+		var h header
+		if err := json.Unmarshal(frame, &h); err != nil {
+			t.Fatalf("malformed frame %s: %v", frame, err)
+		}
+		return h
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for frame")
+	}
+	return header{}
+}
+
+func TestRelay(t *testing.T) {
+	addr := startServerForTesting(t)
+	a := dialForTesting(t, addr)
+	b := dialForTesting(t, addr)
+	c := dialForTesting(t, addr)
+
+	// let the hub accept every node before relaying
+	time.Sleep(50 * time.Millisecond)
+
+	a.Send([]byte(`{"type":"hello","node":"a"}`))
+
+	for _, client := range []*Client{b, c} {
+		if h := readFrame(t, client); h.Type != "hello" || h.Node != "a" {
+			t.Errorf("expected the hello of a, but got %+v", h)
+		}
+	}
+
+	select {
+	case frame := <-a.Frames():
+		t.Errorf("a frame should not be sent back to its node, but got %s", frame)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestNodeGone(t *testing.T) {
+	addr := startServerForTesting(t)
+	a := dialForTesting(t, addr)
+	b := dialForTesting(t, addr)
+	time.Sleep(50 * time.Millisecond)
+
+	a.Send([]byte(`{"type":"hello","node":"a"}`))
+	readFrame(t, b)
+
+	a.Close()
+	if h := readFrame(t, b); h.Type != TypeNodeGone || h.Node != "a" {
+		t.Errorf("expected a to be gone, but got %+v", h)
+	}
+}
+
+func TestClientRedials(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+
+	server := NewServer()
+	go server.Serve(ln)
+
+	connected := make(chan struct{}, 4)
+	client, err := Dial(addr, func() { connected <- struct{}{} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	<-connected
+
+	server.Close()
+
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("cannot listen on %s again: %v", addr, err)
+	}
+	server = NewServer()
+	go server.Serve(ln)
+	defer server.Close()
+
+	select {
+	case <-connected:
+	case <-time.After(3 * redialInterval):
+		t.Fatal("the client should have connected again")
+	}
+}
@@ -0,0 +1,338 @@
+// Package metrics exposes counters, gauges and histograms
+// in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// metric writes its HELP and TYPE lines followed by its samples
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		metrics: make(map[string]metric),
+	}
+}
+
+// Default is the registry the New functions register to
+var Default = NewRegistry()
+
+// register adds the metric, a name can only be registered once
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.metrics[m.name()]; ok {
+		panic("metrics: duplicate metric " + m.name())
+	}
+	r.metrics[m.name()] = m
+}
+
+// Write writes every metric sorted by name
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	metrics := make([]metric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		metrics = append(metrics, m)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].name() < metrics[j].name()
+	})
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+type desc struct {
+	Name, Help, Type string
+}
+
+func (d *desc) name() string {
+	return d.Name
+}
+
+func (d *desc) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.Name, d.Help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.Name, d.Type)
+}
+
+// Counter only goes up
+type Counter struct {
+	desc
+	bits atomic.Uint64
+}
+
+func NewCounter(name, help string) *Counter {
+	c := &Counter{desc: desc{name, help, "counter"}}
+	Default.register(c)
+	return c
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) Add(v float64) {
+	addFloat(&c.bits, v)
+}
+
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(c.bits.Load())
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.writeHeader(w)
+	writeSample(w, c.Name, "", c.Value())
+}
+
+// Gauge goes up and down
+type Gauge struct {
+	desc
+	bits atomic.Uint64
+}
+
+func NewGauge(name, help string) *Gauge {
+	g := &Gauge{desc: desc{name, help, "gauge"}}
+	Default.register(g)
+	return g
+}
+
+func (g *Gauge) Set(v float64) {
+	g.bits.Store(math.Float64bits(v))
+}
+
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+func (g *Gauge) write(w io.Writer) {
+	g.writeHeader(w)
+	writeSample(w, g.Name, "", g.Value())
+}
+
+// CounterVec is a counter for every value of its label
+type CounterVec struct {
+	desc
+	label    string
+	mu       sync.Mutex
+	counters map[string]*atomic.Uint64
+}
+
+func NewCounterVec(name, help, label string) *CounterVec {
+	c := &CounterVec{
+		desc:     desc{name, help, "counter"},
+		label:    label,
+		counters: make(map[string]*atomic.Uint64),
+	}
+	Default.register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(value string) {
+	c.Add(value, 1)
+}
+
+func (c *CounterVec) Add(value string, v float64) {
+	c.mu.Lock()
+	bits, ok := c.counters[value]
+	if !ok {
+		bits = new(atomic.Uint64)
+		c.counters[value] = bits
+	}
+	c.mu.Unlock()
+
+	addFloat(bits, v)
+}
+
+func (c *CounterVec) Value(value string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if bits, ok := c.counters[value]; ok {
+		return math.Float64frombits(bits.Load())
+	}
+	return 0
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	values := make(map[string]float64, len(c.counters))
+	for value, bits := range c.counters {
+		values[value] = math.Float64frombits(bits.Load())
+	}
+	c.mu.Unlock()
+
+	c.writeHeader(w)
+	writeLabeled(w, c.Name, c.label, values)
+}
+
+// VecFunc reads the value of every label when the metrics are written,
+// it suits values kept somewhere else
+type VecFunc struct {
+	desc
+	label string
+	fn    func() map[string]float64
+}
+
+func NewCounterVecFunc(name, help, label string, fn func() map[string]float64) *VecFunc {
+	v := &VecFunc{desc: desc{name, help, "counter"}, label: label, fn: fn}
+	Default.register(v)
+	return v
+}
+
+func NewGaugeVecFunc(name, help, label string, fn func() map[string]float64) *VecFunc {
+	v := &VecFunc{desc: desc{name, help, "gauge"}, label: label, fn: fn}
+	Default.register(v)
+	return v
+}
+
+func (v *VecFunc) write(w io.Writer) {
+	v.writeHeader(w)
+	writeLabeled(w, v.Name, v.label, v.fn())
+}
+
+// GaugeFunc reads its value when the metrics are written
+type GaugeFunc struct {
+	desc
+	fn func() float64
+}
+
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{name, help, "gauge"}, fn: fn}
+	Default.register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	g.writeHeader(w)
+	writeSample(w, g.Name, "", g.fn())
+}
+
+// Histogram counts the observations in buckets of upper bounds
+type Histogram struct {
+	desc
+	mu      sync.Mutex
+	bounds  []float64
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func NewHistogram(name, help string, bounds []float64) *Histogram {
+	h := &Histogram{
+		desc:    desc{name, help, "histogram"},
+		bounds:  bounds,
+		buckets: make([]uint64, len(bounds)),
+	}
+	Default.register(h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.count
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.writeHeader(w)
+	for i, bound := range h.bounds {
+		writeSample(w, h.Name+"_bucket", labelPair("le", formatFloat(bound)), float64(h.buckets[i]))
+	}
+	writeSample(w, h.Name+"_bucket", labelPair("le", "+Inf"), float64(h.count))
+	writeSample(w, h.Name+"_sum", "", h.sum)
+	writeSample(w, h.Name+"_count", "", float64(h.count))
+}
+
+// ExponentialBuckets returns count bounds starting at start, each factor times the last
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	bounds := make([]float64, count)
+	for i := range bounds {
+		bounds[i] = start
+		start *= factor
+	}
+
+	return bounds
+}
+
+func addFloat(bits *atomic.Uint64, v float64) {
+	for {
+		old := bits.Load()
+		if bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func writeLabeled(w io.Writer, name, label string, values map[string]float64) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		writeSample(w, name, labelPair(label, key), values[key])
+	}
+}
+
+func writeSample(w io.Writer, name, labels string, v float64) {
+	if labels != "" {
+		fmt.Fprintf(w, "%s{%s} %s\n", name, labels, formatFloat(v))
+		return
+	}
+
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(v))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func labelPair(label, value string) string {
+	return label + `="` + labelEscaper.Replace(value) + `"`
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
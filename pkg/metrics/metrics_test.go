@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	old := Default
+	Default = NewRegistry()
+	defer func() { Default = old }()
+
+	counter := NewCounter("test_events_total", "Events seen.")
+	counter.Inc()
+	counter.Add(2)
+
+	byType := NewCounterVec("test_messages_total", "Messages by type.", "type")
+	byType.Inc("normal")
+	byType.Inc(`quo"te`)
+
+	NewGauge("test_queue_length", "Queue length.").Set(7)
+	NewGaugeVecFunc("test_high_water", "High water.", "user", func() map[string]float64 {
+		return map[string]float64{"bob": 2, "alice": 1}
+	})
+
+	h := NewHistogram("test_duration_seconds", "Durations.", []float64{1, 10})
+	h.Observe(0.5)
+	h.Observe(5)
+	h.Observe(50)
+
+	var buf bytes.Buffer
+	Default.Write(&buf)
+
+	want := `# HELP test_duration_seconds Durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{le="1"} 1
+test_duration_seconds_bucket{le="10"} 2
+test_duration_seconds_bucket{le="+Inf"} 3
+test_duration_seconds_sum 55.5
+test_duration_seconds_count 3
+# HELP test_events_total Events seen.
+# TYPE test_events_total counter
+test_events_total 3
+# HELP test_high_water High water.
+# TYPE test_high_water gauge
+test_high_water{user="alice"} 1
+test_high_water{user="bob"} 2
+# HELP test_messages_total Messages by type.
+# TYPE test_messages_total counter
+test_messages_total{type="normal"} 1
+test_messages_total{type="quo\"te"} 1
+# HELP test_queue_length Queue length.
+# TYPE test_queue_length gauge
+test_queue_length 7
+`
+	if got := buf.String(); got != want {
+		t.Errorf("unexpected output:\n%s\nwanted:\n%s", got, want)
+	}
+}
+
+func TestDuplicateMetric(t *testing.T) {
+	old := Default
+	Default = NewRegistry()
+	defer func() { Default = old }()
+
+	NewCounter("test_total", "")
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(r.(string), "test_total") {
+			t.Errorf("registering a name twice should panic, but got %v", r)
+		}
+	}()
+	NewCounter("test_total", "")
+}
@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	now := time.Now()
+	b := NewBucket(2, 3)
+
+	for i := 0; i < 3; i++ {
+		if !b.AllowAt(now) {
+			t.Fatalf("the burst should allow event %v", i)
+		}
+	}
+	if b.AllowAt(now) {
+		t.Error("an empty bucket should not allow more events")
+	}
+
+	// 2 tokens per second, one comes back every 500ms
+	if !b.AllowAt(now.Add(500 * time.Millisecond)) {
+		t.Error("a refilled token should be allowed")
+	}
+	if b.AllowAt(now.Add(600 * time.Millisecond)) {
+		t.Error("only one token should have been refilled")
+	}
+
+	// never more than the burst
+	later := now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		b.AllowAt(later)
+	}
+	if b.AllowAt(later) {
+		t.Error("the bucket should not hold more than the burst")
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	var b *Bucket = NewBucket(0, 10)
+	var l *Limiter = NewLimiter(0, 10)
+
+	for i := 0; i < 100; i++ {
+		if !b.Allow() || !l.Allow("key") {
+			t.Fatal("a rate of 0 should allow everything")
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Now()
+	l := NewLimiter(1, 1)
+
+	if !l.AllowAt("a", now) || !l.AllowAt("b", now) {
+		t.Fatal("every key should have a bucket of its own")
+	}
+	if l.AllowAt("a", now) {
+		t.Error("the bucket of a should be empty")
+	}
+
+	l.AllowAt("a", now.Add(2*sweepInterval))
+	if _, ok := l.buckets["b"]; ok {
+		t.Error("the quiet key should have been swept")
+	}
+}
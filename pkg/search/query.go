@@ -0,0 +1,167 @@
+package search
+
+import (
+	"errors"
+	"html"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var (
+	ErrEmptyQuery  = errors.New("empty query")
+	ErrInvalidDate = errors.New("invalid date, use 2006-01-02 or RFC 3339")
+)
+
+// Query is a parsed search, every word and phrase must be found
+type Query struct {
+	Words   []string
+	Phrases [][]string
+
+	From   string
+	In     string
+	Before time.Time
+	After  time.Time
+
+	// Rooms keeps the results to these rooms, it is set by the caller, not parsed
+	Rooms []string
+}
+
+// ParseQuery parses words, "quoted phrases" and the operators from:name, in:room,
+// before:date and after:date, the results are before the date or from it on.
+// A word of several terms, like a link, is searched as a phrase.
+func ParseQuery(s string) (*Query, error) {
+	q := &Query{}
+	for _, field := range splitFields(s) {
+		if field.quoted {
+			q.addText(field.text)
+			continue
+		}
+
+		key, value, ok := strings.Cut(field.text, ":")
+		var err error
+		switch {
+		case ok && key == "from" && value != "":
+			q.From = value
+		case ok && key == "in" && value != "":
+			q.In = value
+		case ok && key == "before" && value != "":
+			q.Before, err = parseDate(value)
+		case ok && key == "after" && value != "":
+			q.After, err = parseDate(value)
+		default:
+			q.addText(field.text)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(q.Words) == 0 && len(q.Phrases) == 0 && q.From == "" && q.In == "" &&
+		q.Before.IsZero() && q.After.IsZero() {
+		return nil, ErrEmptyQuery
+	}
+	return q, nil
+}
+
+func (q *Query) addText(text string) {
+	var terms []string
+	for _, t := range tokenize(text) {
+		terms = append(terms, t.term)
+	}
+
+	switch len(terms) {
+	case 0:
+	case 1:
+		q.Words = append(q.Words, terms[0])
+	default:
+		q.Phrases = append(q.Phrases, terms)
+	}
+}
+
+type field struct {
+	text   string
+	quoted bool
+}
+
+// splitFields splits on spaces, a quoted part is a single field
+func splitFields(s string) []field {
+	var fields []field
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		if s[0] == '"' {
+			text, rest, _ := strings.Cut(s[1:], `"`)
+			fields = append(fields, field{text: text, quoted: true})
+			s = rest
+			continue
+		}
+
+		end := strings.IndexFunc(s, unicode.IsSpace)
+		if end < 0 {
+			end = len(s)
+		}
+		fields = append(fields, field{text: s[:end]})
+		s = s[end:]
+	}
+	return fields
+}
+
+// parseDate takes a day, which starts at midnight UTC, or an RFC 3339 time
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, ErrInvalidDate
+}
+
+// terms returns every distinct term of the words and phrases
+func (q *Query) terms() []string {
+	terms := slices.Clone(q.Words)
+	for _, phrase := range q.Phrases {
+		terms = append(terms, phrase...)
+	}
+	slices.Sort(terms)
+	return slices.Compact(terms)
+}
+
+func (q *Query) filter(d *Doc) bool {
+	if q.From != "" && !strings.EqualFold(d.From, q.From) {
+		return false
+	}
+	if q.In != "" && d.Room != q.In {
+		return false
+	}
+	if q.Rooms != nil && !slices.Contains(q.Rooms, d.Room) {
+		return false
+	}
+	if !q.Before.IsZero() && !d.Time.Before(q.Before) {
+		return false
+	}
+	if !q.After.IsZero() && d.Time.Before(q.After) {
+		return false
+	}
+	return true
+}
+
+// Highlight escapes the text for HTML and wraps the terms of the query in <mark>
+func (q *Query) Highlight(text string) string {
+	terms := q.terms()
+
+	var sb strings.Builder
+	last := 0
+	for _, t := range tokenize(text) {
+		if _, found := slices.BinarySearch(terms, t.term); !found {
+			continue
+		}
+		sb.WriteString(html.EscapeString(text[last:t.start]))
+		sb.WriteString("<mark>")
+		sb.WriteString(html.EscapeString(text[t.start:t.end]))
+		sb.WriteString("</mark>")
+		last = t.end
+	}
+	sb.WriteString(html.EscapeString(text[last:]))
+	return sb.String()
+}
@@ -0,0 +1,251 @@
+// Package search keeps an inverted index of short texts, such as chat messages,
+// and answers queries of words, quoted phrases and from:, in:, before: and after:
+// operators, ranked by relevance or time.
+package search
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// the BM25 parameters
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+// the orders of the results
+const (
+	OrderRelevance = "relevance"
+	OrderTime      = "time"
+)
+
+// Doc is a text and the fields a query can filter it by,
+// a larger ID is a later document
+type Doc struct {
+	ID   uint64
+	Text string
+	From string
+	Room string
+	Time time.Time
+}
+
+// Hit is a document matching a query
+type Hit struct {
+	ID    uint64
+	Score float64
+}
+
+type indexedDoc struct {
+	Doc
+	length int
+	terms  []string
+}
+
+// Index maps every term to the positions it has in the documents, it is safe for concurrent use
+type Index struct {
+	mu       sync.RWMutex
+	postings map[string]map[uint64][]int
+	docs     map[uint64]*indexedDoc
+	totalLen int
+}
+
+func New() *Index {
+	return &Index{
+		postings: make(map[string]map[uint64][]int),
+		docs:     make(map[uint64]*indexedDoc),
+	}
+}
+
+type token struct {
+	term       string
+	start, end int
+}
+
+// tokenize splits the text into lowercase runs of letters and digits
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if word && start < 0 {
+			start = i
+		} else if !word && start >= 0 {
+			tokens = append(tokens, token{strings.ToLower(text[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{strings.ToLower(text[start:]), start, len(text)})
+	}
+	return tokens
+}
+
+// Add indexes the document, replacing the one with the same ID
+func (ix *Index) Add(doc Doc) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.remove(doc.ID)
+
+	tokens := tokenize(doc.Text)
+	d := &indexedDoc{Doc: doc, length: len(tokens)}
+	for i, t := range tokens {
+		positions, ok := ix.postings[t.term]
+		if !ok {
+			positions = make(map[uint64][]int)
+			ix.postings[t.term] = positions
+		}
+		if len(positions[doc.ID]) == 0 {
+			d.terms = append(d.terms, t.term)
+		}
+		positions[doc.ID] = append(positions[doc.ID], i)
+	}
+
+	ix.docs[doc.ID] = d
+	ix.totalLen += d.length
+}
+
+func (ix *Index) Remove(id uint64) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.remove(id)
+}
+
+func (ix *Index) remove(id uint64) {
+	d, ok := ix.docs[id]
+	if !ok {
+		return
+	}
+
+	for _, term := range d.terms {
+		delete(ix.postings[term], id)
+		if len(ix.postings[term]) == 0 {
+			delete(ix.postings, term)
+		}
+	}
+	delete(ix.docs, id)
+	ix.totalLen -= d.length
+}
+
+// Len returns the number of indexed documents
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	return len(ix.docs)
+}
+
+// Search returns the documents matching every word and phrase of the query
+// and its filters, in the order, with the total number of matches
+func (ix *Index) Search(q *Query, order string, offset, limit int) ([]Hit, int) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	terms := q.terms()
+	var hits []Hit
+	for id := range ix.candidates(terms) {
+		d := ix.docs[id]
+		if !q.filter(&d.Doc) || !ix.hasPhrases(id, q.Phrases) {
+			continue
+		}
+		hits = append(hits, Hit{ID: id, Score: ix.score(id, d, terms)})
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if order != OrderTime && hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID > hits[j].ID
+	})
+
+	total := len(hits)
+	if offset >= total {
+		return nil, total
+	}
+	hits = hits[offset:]
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, total
+}
+
+// candidates returns the documents having every term, all of them without terms
+func (ix *Index) candidates(terms []string) map[uint64]struct{} {
+	ids := make(map[uint64]struct{})
+	if len(terms) == 0 {
+		for id := range ix.docs {
+			ids[id] = struct{}{}
+		}
+		return ids
+	}
+
+	// start from the rarest term, the others only narrow it down
+	rarest := ix.postings[terms[0]]
+	for _, term := range terms[1:] {
+		if len(ix.postings[term]) < len(rarest) {
+			rarest = ix.postings[term]
+		}
+	}
+
+outer:
+	for id := range rarest {
+		for _, term := range terms {
+			if _, ok := ix.postings[term][id]; !ok {
+				continue outer
+			}
+		}
+		ids[id] = struct{}{}
+	}
+	return ids
+}
+
+// hasPhrases reports whether the terms of every phrase follow each other in the document
+func (ix *Index) hasPhrases(id uint64, phrases [][]string) bool {
+	for _, phrase := range phrases {
+		found := false
+		for _, start := range ix.postings[phrase[0]][id] {
+			if ix.phraseAt(id, phrase, start) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (ix *Index) phraseAt(id uint64, phrase []string, start int) bool {
+outer:
+	for i, term := range phrase[1:] {
+		for _, pos := range ix.postings[term][id] {
+			if pos == start+i+1 {
+				continue outer
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// score ranks the document for the terms with BM25
+func (ix *Index) score(id uint64, d *indexedDoc, terms []string) float64 {
+	n := float64(len(ix.docs))
+	avgLen := float64(ix.totalLen) / n
+
+	score := 0.0
+	for _, term := range terms {
+		df := float64(len(ix.postings[term]))
+		tf := float64(len(ix.postings[term][id]))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		score += idf * tf * (k1 + 1) / (tf + k1*(1-b+b*float64(d.length)/avgLen))
+	}
+	return score
+}
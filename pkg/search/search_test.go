@@ -0,0 +1,140 @@
+package search
+
+import (
+	"testing"
+	"time"
+)
+
+var day = time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+func indexForTesting() *Index {
+	ix := New()
+	docs := []Doc{
+		{ID: 1, Text: "the release is on friday", From: "alice", Room: "lobby", Time: day},
+		{ID: 2, Text: "see https://example.com/release-notes", From: "bob", Room: "lobby", Time: day.Add(24 * time.Hour)},
+		{ID: 3, Text: "friday friday friday", From: "bob", Room: "random", Time: day.Add(48 * time.Hour)},
+		{ID: 4, Text: "Is Friday the release day?", From: "carol", Room: "lobby", Time: day.Add(72 * time.Hour)},
+	}
+	for _, doc := range docs {
+		ix.Add(doc)
+	}
+	return ix
+}
+
+func search(t *testing.T, ix *Index, s, order string) []uint64 {
+	q, err := ParseQuery(s)
+	if err != nil {
+		t.Fatalf("failed to parse %q: %v", s, err)
+	}
+
+	hits, _ := ix.Search(q, order, 0, 0)
+	var ids []uint64
+	for _, hit := range hits {
+		ids = append(ids, hit.ID)
+	}
+	return ids
+}
+
+func equal(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSearch(t *testing.T) {
+	ix := indexForTesting()
+
+	tests := []struct {
+		query, order string
+		want         []uint64
+	}{
+		// the document saying it most is first, the later one wins a tie
+		{"friday", OrderRelevance, []uint64{3, 4, 1}},
+		{"friday", OrderTime, []uint64{4, 3, 1}},
+		{"release friday", OrderTime, []uint64{4, 1}},
+		{`"release is"`, OrderTime, []uint64{1}},
+		{`"is release"`, OrderTime, nil},
+		{"example.com/release-notes", OrderTime, []uint64{2}},
+		{"FRIDAY from:BOB", OrderTime, []uint64{3}},
+		{"release in:lobby", OrderTime, []uint64{4, 2, 1}},
+		{"friday after:2026-10-02 before:2026-10-04", OrderTime, []uint64{3}},
+		{"from:alice", OrderTime, []uint64{1}},
+		{"nothing", OrderTime, nil},
+	}
+	for _, tt := range tests {
+		if got := search(t, ix, tt.query, tt.order); !equal(got, tt.want) {
+			t.Errorf("%q by %v: wanted %v, but got %v", tt.query, tt.order, tt.want, got)
+		}
+	}
+
+	q, _ := ParseQuery("friday")
+	q.Rooms = []string{"random"}
+	if hits, total := ix.Search(q, OrderTime, 0, 0); total != 1 || hits[0].ID != 3 {
+		t.Errorf("wanted only the message of random, but got %v of %v", hits, total)
+	}
+}
+
+func TestPaging(t *testing.T) {
+	ix := indexForTesting()
+	q, _ := ParseQuery("friday")
+
+	hits, total := ix.Search(q, OrderTime, 1, 1)
+	if total != 3 || len(hits) != 1 || hits[0].ID != 3 {
+		t.Errorf("wanted the second of 3 hits, but got %v of %v", hits, total)
+	}
+	if hits, _ := ix.Search(q, OrderTime, 3, 1); len(hits) != 0 {
+		t.Errorf("wanted no hit past the end, but got %v", hits)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	ix := indexForTesting()
+
+	ix.Add(Doc{ID: 1, Text: "the release moved to monday", From: "alice", Room: "lobby", Time: day})
+	if got := search(t, ix, "friday", OrderTime); !equal(got, []uint64{4, 3}) {
+		t.Errorf("the old text should be forgotten, but got %v", got)
+	}
+	if got := search(t, ix, "monday", OrderTime); !equal(got, []uint64{1}) {
+		t.Errorf("the new text should be found, but got %v", got)
+	}
+
+	ix.Remove(3)
+	if got := search(t, ix, "friday", OrderTime); !equal(got, []uint64{4}) {
+		t.Errorf("a removed document should not be found, but got %v", got)
+	}
+	if ix.Len() != 3 {
+		t.Errorf("wanted 3 documents left, but got %v", ix.Len())
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	for _, s := range []string{"", "   ", `""`, "!!"} {
+		if _, err := ParseQuery(s); err != ErrEmptyQuery {
+			t.Errorf("wanted %v for %q, but got %v", ErrEmptyQuery, s, err)
+		}
+	}
+	if _, err := ParseQuery("before:yesterday"); err != ErrInvalidDate {
+		t.Errorf("wanted %v, but got %v", ErrInvalidDate, err)
+	}
+
+	// an operator inside quotes is just text
+	q, _ := ParseQuery(`"from:alice"`)
+	if q.From != "" || len(q.Phrases) != 1 {
+		t.Errorf("wanted a phrase, but got %+v", q)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	q, _ := ParseQuery(`friday "release day"`)
+	got := q.Highlight("Is <b>Friday</b> the release day?")
+	want := "Is &lt;b&gt;<mark>Friday</mark>&lt;/b&gt; the <mark>release</mark> <mark>day</mark>?"
+	if got != want {
+		t.Errorf("wanted %q, but got %q", want, got)
+	}
+}
@@ -2,6 +2,8 @@ package setting
 
 import (
 	"log"
+	"strings"
+	"time"
 
 	"github.com/fyerfyer/chatroom/pkg/utils"
 	"github.com/go-ini/ini"
@@ -11,11 +13,65 @@ var (
 	Cfg *ini.File
 
 	HTTPPort               string
+	PingInterval           time.Duration
+	IdleTimeout            time.Duration
+	ShutdownTimeout        time.Duration
 	MessageQueueLength     int
 	OfflineMsgNum          int
 	UserMessageQueueLength int
+	DefaultRoom            string
+	OverflowPolicy         string
+	ResumeGrace            time.Duration
+	AwayAfter              time.Duration
+
+	StorageType     string
+	StoragePath     string
+	MemoryStoreSize int
+
+	TokenSecret  string
+	TokenExpire  time.Duration
+	AccountsPath string
+
+	MessageRate     float64
+	MessageBurst    int
+	ConnectionRate  float64
+	ConnectionBurst int
+	MaxViolations   int
+
+	BansPath string
+	Admins   []string
+
+	Backplane string
+	HubAddr   string
+	NodeID    string
+
+	Webhooks []Webhook
+
+	Bots     []string
+	BotRooms []string
+
+	AttachmentsPath   string
+	MaxAttachmentSize int64
+	AttachmentTypes   []string
+
+	// Filters is the chain of the [filters] section, FilterConfigs the keys
+	// of each [filter.<name>] section
+	Filters       []string
+	FilterConfigs map[string]map[string]string
 )
 
+// Webhook is a [webhook.<name>] section
+type Webhook struct {
+	Name        string
+	URL         string
+	Secret      string
+	Events      []string
+	QueueLength int
+	MaxRetries  int
+	Timeout     time.Duration
+	Backoff     time.Duration
+}
+
 func init() {
 	var err error
 	filepath := utils.InferRootDir() + "/conf/chatroom.ini"
@@ -26,9 +82,29 @@ func init() {
 
 	var chatroom = Cfg.Section("chatroom")
 	var server = Cfg.Section("server")
+	var storage = Cfg.Section("storage")
+	var auth = Cfg.Section("auth")
+	var cluster = Cfg.Section("cluster")
+	var moderation = Cfg.Section("moderation")
+	var limits = Cfg.Section("limits")
+	var bots = Cfg.Section("bots")
+	var attachments = Cfg.Section("attachments")
+	var filters = Cfg.Section("filters")
 	HTTPPort = server.
 		Key("HTTP_PORT").String()
 
+	PingInterval = server.
+		Key("Ping_Interval").
+		MustDuration(30 * time.Second)
+
+	IdleTimeout = server.
+		Key("Idle_Timeout").
+		MustDuration(60 * time.Second)
+
+	ShutdownTimeout = server.
+		Key("Shutdown_Timeout").
+		MustDuration(10 * time.Second)
+
 	MessageQueueLength = chatroom.
 		Key("Message_Queue_Length").
 		MustInt(1024)
@@ -40,6 +116,135 @@ func init() {
 	UserMessageQueueLength = chatroom.
 		Key("UserMessageQueueLength").
 		MustInt(32)
+
+	DefaultRoom = chatroom.
+		Key("Default_Room").
+		MustString("lobby")
+
+	ResumeGrace = chatroom.
+		Key("Resume_Grace").
+		MustDuration(30 * time.Second)
+
+	AwayAfter = chatroom.
+		Key("Away_After").
+		MustDuration(5 * time.Minute)
+
+	OverflowPolicy = chatroom.
+		Key("Overflow_Policy").
+		In("drop_oldest", []string{"drop_oldest", "drop_newest", "disconnect"})
+
+	StorageType = storage.
+		Key("Type").
+		MustString("memory")
+
+	StoragePath = storage.
+		Key("Path").
+		MustString("data")
+
+	MemoryStoreSize = storage.
+		Key("Memory_Size").
+		MustInt(1000)
+
+	TokenSecret = auth.
+		Key("Token_Secret").
+		String()
+
+	TokenExpire = auth.
+		Key("Token_Expire").
+		MustDuration(24 * time.Hour)
+
+	AccountsPath = auth.
+		Key("Accounts_Path").
+		MustString("data/accounts.json")
+
+	MessageRate = limits.
+		Key("Message_Rate").
+		MustFloat64(5)
+
+	MessageBurst = limits.
+		Key("Message_Burst").
+		MustInt(20)
+
+	ConnectionRate = limits.
+		Key("Connection_Rate").
+		MustFloat64(1)
+
+	ConnectionBurst = limits.
+		Key("Connection_Burst").
+		MustInt(10)
+
+	MaxViolations = limits.
+		Key("Max_Violations").
+		MustInt(10)
+
+	BansPath = moderation.
+		Key("Bans_Path").
+		MustString("data/bans.json")
+
+	Admins = moderation.
+		Key("Admins").
+		Strings(",")
+
+	Backplane = cluster.
+		Key("Backplane").
+		In("none", []string{"none", "tcp"})
+
+	HubAddr = cluster.
+		Key("Hub_Addr").
+		MustString("127.0.0.1:9000")
+
+	NodeID = cluster.
+		Key("Node_ID").
+		String()
+
+	Bots = bots.
+		Key("Enabled").
+		Strings(",")
+
+	BotRooms = bots.
+		Key("Rooms").
+		Strings(",")
+
+	AttachmentsPath = attachments.
+		Key("Path").
+		MustString("data/attachments")
+
+	MaxAttachmentSize = attachments.
+		Key("Max_Size").
+		MustInt64(10 << 20)
+
+	AttachmentTypes = attachments.
+		Key("Types").
+		Strings(",")
+
+	Filters = filters.
+		Key("Chain").
+		Strings(",")
+
+	FilterConfigs = make(map[string]map[string]string)
+	for _, section := range Cfg.Sections() {
+		if name, ok := strings.CutPrefix(section.Name(), "filter."); ok {
+			FilterConfigs[name] = section.KeysHash()
+		}
+	}
+
+	for _, section := range Cfg.Sections() {
+		name, ok := strings.CutPrefix(section.Name(), "webhook.")
+		if !ok {
+			continue
+		}
+
+		Webhooks = append(Webhooks, Webhook{
+			Name:        name,
+			URL:         section.Key("URL").String(),
+			Secret:      section.Key("Secret").String(),
+			Events:      section.Key("Events").Strings(","),
+			QueueLength: section.Key("Queue_Length").MustInt(256),
+			MaxRetries:  section.Key("Max_Retries").MustInt(5),
+			Timeout:     section.Key("Timeout").MustDuration(5 * time.Second),
+			Backoff:     section.Key("Backoff").MustDuration(time.Second),
+		})
+	}
 	// log.Println(HTTPPort)
 	// log.Println(MessageQueueLength)
 	// log.Println(OfflineMsgNum)
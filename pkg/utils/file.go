@@ -23,6 +23,42 @@ func InferRootDir() string {
 	return infer(cwd)
 }
 
+// RootPath resolves a relative path against the project root
+func RootPath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(InferRootDir(), path)
+}
+
+// WriteFileAtomic writes data to the file at path, creating its directory.
+// The data goes to a temporary file first and is synced before it takes the
+// place of the file, so a crash leaves either the old file or the new one.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
 func exists(filename string) bool {
 	_, err := os.Stat(filename)
 	return err == nil || os.IsExist(err)
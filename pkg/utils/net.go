@@ -0,0 +1,13 @@
+package utils
+
+import "net"
+
+// HostOf returns the host of a "host:port" address, or the address itself without a port
+func HostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	return host
+}
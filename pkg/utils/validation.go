@@ -10,3 +10,12 @@ func ValidateName(name string) error {
 
 	return nil
 }
+
+func ValidateRoomName(name string) error {
+	var length = len(name)
+	if length < 2 || length > 20 {
+		return errors.New("invalid room name")
+	}
+
+	return nil
+}
@@ -0,0 +1,256 @@
+// Package webhook posts signed JSON payloads to the configured receivers.
+// Deliveries go through a bounded queue and are retried with backoff,
+// so a slow receiver never holds up the sender.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// the headers of every delivery
+const (
+	HeaderEvent     = "X-Chatroom-Event"
+	HeaderDelivery  = "X-Chatroom-Delivery"
+	HeaderSignature = "X-Chatroom-Signature"
+	HeaderTimestamp = "X-Chatroom-Timestamp"
+)
+
+var (
+	ErrClosed    = errors.New("webhook: dispatcher closed")
+	ErrQueueFull = errors.New("webhook: queue is full")
+	ErrNoSecret  = errors.New("webhook: no secret to sign with")
+)
+
+type Config struct {
+	Name   string
+	URL    string
+	Secret string
+
+	// the event types to deliver, all of them if empty
+	Events []string
+
+	QueueLength int
+	MaxRetries  int
+	Timeout     time.Duration
+	Backoff     time.Duration
+}
+
+// Payload is the body of a delivery
+type Payload struct {
+	ID        string      `json:"id"`
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+type delivery struct {
+	payload *Payload
+	body    []byte
+}
+
+type Dispatcher struct {
+	cfg    Config
+	events map[string]bool
+	client *http.Client
+
+	queue chan *delivery
+
+	closeOnce sync.Once
+	done      chan struct{}
+	stopped   chan struct{}
+}
+
+// New starts a dispatcher for the receiver, every delivery is signed so
+// a config without a secret is refused
+func New(cfg Config) (*Dispatcher, error) {
+	if cfg.Secret == "" {
+		return nil, ErrNoSecret
+	}
+	if cfg.QueueLength <= 0 {
+		cfg.QueueLength = 256
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = time.Second
+	}
+
+	d := &Dispatcher{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: cfg.Timeout},
+		queue:   make(chan *delivery, cfg.QueueLength),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	if len(cfg.Events) > 0 {
+		d.events = make(map[string]bool, len(cfg.Events))
+		for _, event := range cfg.Events {
+			d.events[event] = true
+		}
+	}
+
+	go d.run()
+	return d, nil
+}
+
+// Send queues the event for delivery without blocking,
+// it is dropped if the queue is full
+func (d *Dispatcher) Send(event string, data interface{}) error {
+	if d.events != nil && !d.events[event] {
+		return nil
+	}
+
+	select {
+	case <-d.done:
+		return ErrClosed
+	default:
+	}
+
+	payload := &Payload{
+		ID:        newDeliveryID(),
+		Event:     event,
+		CreatedAt: time.Now(),
+		Data:      data,
+	}
+
+	// encode now, the data may change once the caller moves on
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case d.queue <- &delivery{payload: payload, body: body}:
+		return nil
+	default:
+		log.Printf("webhook %s: queue is full, dropping %s event", d.cfg.Name, event)
+		return ErrQueueFull
+	}
+}
+
+// Close stops taking events and delivers the queued ones until ctx is done
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.closeOnce.Do(func() {
+		close(d.done)
+	})
+
+	select {
+	case <-d.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *Dispatcher) run() {
+	defer close(d.stopped)
+
+	for {
+		select {
+		case dl := <-d.queue:
+			d.deliver(dl)
+		case <-d.done:
+			// flush what was queued before the close
+			for len(d.queue) > 0 {
+				d.deliver(<-d.queue)
+			}
+			return
+		}
+	}
+}
+
+// deliver posts the payload, retrying with an exponential backoff
+// as long as the receiver may accept it later
+func (d *Dispatcher) deliver(dl *delivery) {
+	backoff := d.cfg.Backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := d.post(dl)
+		if err == nil {
+			return
+		}
+
+		if !retry || attempt >= d.cfg.MaxRetries {
+			log.Printf("webhook %s: giving up on %s %s: %v",
+				d.cfg.Name, dl.payload.Event, dl.payload.ID, err)
+			return
+		}
+
+		// a closing dispatcher still retries, but without waiting
+		select {
+		case <-time.After(backoff):
+		case <-d.done:
+		}
+		backoff *= 2
+	}
+}
+
+// post reports whether a failed delivery is worth retrying
+func (d *Dispatcher) post(dl *delivery) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, d.cfg.URL, bytes.NewReader(dl.body))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, dl.payload.Event)
+	req.Header.Set(HeaderDelivery, dl.payload.ID)
+
+	// every attempt is signed anew, a receiver may refuse old timestamps
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(d.cfg.Secret, timestamp, dl.body))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	res.Body.Close()
+
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return false, nil
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		return true, fmt.Errorf("receiver answered %s", res.Status)
+	default:
+		return false, fmt.Errorf("receiver answered %s", res.Status)
+	}
+}
+
+// Sign returns the signature header of the body sent at the timestamp header:
+// "sha256=" and the hex HMAC of the timestamp, a dot and the body
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature header of a body, for receivers written in Go.
+// It does not look at how old the timestamp is, that is up to the receiver.
+func Verify(secret, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+func newDeliveryID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		log.Fatalf("Failed to generate delivery ID: %v", err)
+	}
+
+	return hex.EncodeToString(buf)
+}
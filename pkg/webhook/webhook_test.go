@@ -0,0 +1,166 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type received struct {
+	payload   Payload
+	timestamp string
+	signature string
+	valid     bool
+}
+
+// receiverForTesting answers the first fails requests with 500
+func receiverForTesting(t *testing.T, secret string, fails int32) (*httptest.Server, chan received) {
+	ch := make(chan received, 16)
+	var calls atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= fails {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		var rec received
+		json.Unmarshal(body, &rec.payload)
+		rec.timestamp = r.Header.Get(HeaderTimestamp)
+		rec.signature = r.Header.Get(HeaderSignature)
+		rec.valid = Verify(secret, rec.timestamp, body, rec.signature)
+		ch <- rec
+	}))
+	t.Cleanup(server.Close)
+	return server, ch
+}
+
+// newForTesting starts a dispatcher signing with "secret"
+func newForTesting(t *testing.T, cfg Config) *Dispatcher {
+	cfg.Secret = "secret"
+	d, err := New(cfg)
+	if err != nil {
+		t.Fatalf("failed to start the dispatcher: %v", err)
+	}
+	return d
+}
+
+func waitReceived(t *testing.T, ch chan received) received {
+	t.Helper()
+
+	select {
+	case rec := <-ch:
+		return rec
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for delivery")
+	}
+	return received{}
+}
+
+func TestDeliverSigned(t *testing.T) {
+	server, ch := receiverForTesting(t, "secret", 0)
+	d := newForTesting(t, Config{Name: "test", URL: server.URL, Events: []string{"message"}})
+	defer d.Close(context.Background())
+
+	d.Send("login", map[string]string{"user": "skipped"})
+	d.Send("message", map[string]string{"content": "hi"})
+
+	rec := waitReceived(t, ch)
+	if rec.payload.Event != "message" {
+		t.Errorf("only message events should be delivered, but got %v", rec.payload.Event)
+	}
+	if !rec.valid || rec.timestamp == "" {
+		t.Errorf("the signature %v of %q should be valid", rec.signature, rec.timestamp)
+	}
+	if data, _ := rec.payload.Data.(map[string]interface{}); data["content"] != "hi" {
+		t.Errorf("unexpected data %v", rec.payload.Data)
+	}
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	server, ch := receiverForTesting(t, "secret", 2)
+	d := newForTesting(t, Config{Name: "test", URL: server.URL, MaxRetries: 3, Backoff: 10 * time.Millisecond})
+	defer d.Close(context.Background())
+
+	start := time.Now()
+	d.Send("logout", nil)
+
+	rec := waitReceived(t, ch)
+	if rec.payload.Event != "logout" {
+		t.Errorf("unexpected event %v", rec.payload.Event)
+	}
+	// 10ms then 20ms
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("the retries should back off, but took only %v", elapsed)
+	}
+}
+
+func TestQueueBounded(t *testing.T) {
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer server.Close()
+
+	d := newForTesting(t, Config{Name: "test", URL: server.URL, QueueLength: 1})
+	defer d.Close(context.Background())
+	defer close(block)
+
+	// the first is being delivered, the second waits in the queue
+	d.Send("message", nil)
+	time.Sleep(50 * time.Millisecond)
+	d.Send("message", nil)
+
+	done := make(chan error, 1)
+	go func() { done <- d.Send("message", nil) }()
+
+	select {
+	case err := <-done:
+		if err != ErrQueueFull {
+			t.Errorf("a full queue should drop the event, but got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("sending should never block on a slow receiver")
+	}
+}
+
+func TestCloseFlushes(t *testing.T) {
+	server, ch := receiverForTesting(t, "secret", 0)
+	d := newForTesting(t, Config{Name: "test", URL: server.URL})
+
+	d.Send("kick", nil)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := d.Close(ctx); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	if len(ch) != 1 {
+		t.Error("the queued event should have been delivered before closing")
+	}
+	if err := d.Send("kick", nil); err != ErrClosed {
+		t.Errorf("a closed dispatcher should refuse events, but got %v", err)
+	}
+}
+
+func TestSignature(t *testing.T) {
+	if _, err := New(Config{Name: "test", URL: "http://127.0.0.1:9090"}); err != ErrNoSecret {
+		t.Errorf("wanted %v without a secret, but got %v", ErrNoSecret, err)
+	}
+
+	body := []byte(`{"event":"message"}`)
+	signature := Sign("secret", "1700000000", body)
+	if !Verify("secret", "1700000000", body, signature) {
+		t.Error("the signature should be valid")
+	}
+	// the same body sent at another time is not signed the same
+	if Verify("secret", "1700000001", body, signature) {
+		t.Error("the signature should not be valid with another timestamp")
+	}
+}
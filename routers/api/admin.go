@@ -0,0 +1,131 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/fyerfyer/chatroom/models"
+	"github.com/fyerfyer/chatroom/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// the key of the name of the authenticated user in the gin context
+const userKey = "user"
+
+type banRequest struct {
+	Name     string `json:"name"`
+	Addr     string `json:"address"`
+	Duration string `json:"duration"`
+	Reason   string `json:"reason"`
+}
+
+type roleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// RequireRole lets a request through only if its token belongs to a user
+// with at least the role
+func RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name, err := verifyRequest(c.Request)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		if !models.HasRole(models.Accounts.Role(name), role) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": models.ErrPermissionDenied.Error()})
+			return
+		}
+
+		c.Set(userKey, name)
+		c.Next()
+	}
+}
+
+func BanListHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, models.Bans.List())
+}
+
+func BanHandler(c *gin.Context) {
+	var req banRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	d, err := parseDuration(req.Duration)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// a user is banned by account, whatever it goes by now
+	by := c.GetString(userKey)
+	if req.Name != "" {
+		if err := utils.ValidateName(req.Name); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		req.Name = models.Broadcaster.AccountOf(req.Name)
+		if !models.CanModerate(models.Accounts.Role(by), models.Accounts.Role(req.Name)) {
+			c.JSON(http.StatusForbidden, gin.H{"error": models.ErrPermissionDenied.Error()})
+			return
+		}
+	}
+
+	ban, err := models.Broadcaster.Ban(&models.Ban{
+		Name:   req.Name,
+		Addr:   req.Addr,
+		Reason: req.Reason,
+		By:     by,
+	}, d)
+	switch {
+	case errors.Is(err, models.ErrInvalidBan), errors.Is(err, models.ErrInvalidAddress):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, ban)
+}
+
+func RevokeBanHandler(c *gin.Context) {
+	err := models.Bans.Revoke(c.Param("id"))
+	switch {
+	case errors.Is(err, models.ErrBanNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// RoleHandler sets the role of an account, it takes effect on the next connection
+func RoleHandler(c *gin.Context) {
+	var req roleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := models.Accounts.SetRole(c.Param("name"), req.Role)
+	switch {
+	case errors.Is(err, models.ErrInvalidRole):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, models.ErrAccountNotExists):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"name": c.Param("name"), "role": req.Role})
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/fyerfyer/chatroom/models"
+	"github.com/fyerfyer/chatroom/pkg/auth"
+	"github.com/gin-gonic/gin"
+)
+
+func adminRequestForTesting(t *testing.T, r *gin.Engine, method, path, name string,
+	body interface{}) *httptest.ResponseRecorder {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	if name != "" {
+		token, _, err := auth.IssueToken(name)
+		if err != nil {
+			t.Fatalf("failed to issue token: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func registerForTesting(t *testing.T, name, role string) {
+	if _, err := models.Accounts.Register(name, "secret_password"); err != nil {
+		t.Fatalf("failed to register %v: %v", name, err)
+	}
+	if err := models.Accounts.SetRole(name, role); err != nil {
+		t.Fatalf("failed to set the role of %v: %v", name, err)
+	}
+}
+
+func TestAdminAPI(t *testing.T) {
+	dir := t.TempDir()
+	if err := models.Accounts.Load(filepath.Join(dir, "accounts.json")); err != nil {
+		t.Fatalf("failed to load accounts: %v", err)
+	}
+	defer models.Accounts.Load("")
+	if err := models.Bans.Load(filepath.Join(dir, "bans.json")); err != nil {
+		t.Fatalf("failed to load bans: %v", err)
+	}
+	defer models.Bans.Load("")
+
+	registerForTesting(t, "admin_user", models.RoleAdmin)
+	registerForTesting(t, "mod_user", models.RoleModerator)
+	registerForTesting(t, "member_user", models.RoleMember)
+
+	r := gin.Default()
+	admin := r.Group("/admin", RequireRole(models.RoleModerator))
+	admin.GET("/bans", BanListHandler)
+	admin.POST("/bans", BanHandler)
+	admin.DELETE("/bans/:id", RevokeBanHandler)
+	admin.PUT("/roles/:name", RequireRole(models.RoleAdmin), RoleHandler)
+
+	if w := adminRequestForTesting(t, r, http.MethodGet, "/admin/bans", "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("wanted status %v without token, but got %v", http.StatusUnauthorized, w.Code)
+	}
+	if w := adminRequestForTesting(t, r, http.MethodGet, "/admin/bans", "member_user", nil); w.Code != http.StatusForbidden {
+		t.Errorf("wanted status %v for a member, but got %v", http.StatusForbidden, w.Code)
+	}
+
+	w := adminRequestForTesting(t, r, http.MethodPost, "/admin/bans", "mod_user",
+		banRequest{Name: "admin_user"})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("a moderator should not ban an admin, but got status %v", w.Code)
+	}
+
+	w = adminRequestForTesting(t, r, http.MethodPost, "/admin/bans", "mod_user",
+		banRequest{Addr: "10.0.0.1", Duration: "forever"})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("wanted status %v for a bad duration, but got %v", http.StatusBadRequest, w.Code)
+	}
+
+	w = adminRequestForTesting(t, r, http.MethodPost, "/admin/bans", "mod_user",
+		banRequest{Name: "member_user", Duration: "1h", Reason: "spam"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("wanted status %v on ban, but got %v: %v", http.StatusCreated, w.Code, w.Body)
+	}
+	var ban models.Ban
+	json.Unmarshal(w.Body.Bytes(), &ban)
+	if ban.Name != "member_user" || ban.By != "mod_user" || ban.ExpiresAt.IsZero() {
+		t.Errorf("unexpected ban %+v", ban)
+	}
+
+	var bans []*models.Ban
+	w = adminRequestForTesting(t, r, http.MethodGet, "/admin/bans", "mod_user", nil)
+	json.Unmarshal(w.Body.Bytes(), &bans)
+	if len(bans) != 1 || bans[0].ID != ban.ID {
+		t.Errorf("wanted the ban listed, but got %v", w.Body)
+	}
+
+	if w := adminRequestForTesting(t, r, http.MethodDelete, "/admin/bans/"+ban.ID, "mod_user", nil); w.Code != http.StatusNoContent {
+		t.Errorf("wanted status %v on revoke, but got %v", http.StatusNoContent, w.Code)
+	}
+	if w := adminRequestForTesting(t, r, http.MethodDelete, "/admin/bans/"+ban.ID, "mod_user", nil); w.Code != http.StatusNotFound {
+		t.Errorf("wanted status %v on a revoked ban, but got %v", http.StatusNotFound, w.Code)
+	}
+
+	role := roleRequest{Role: models.RoleModerator}
+	if w := adminRequestForTesting(t, r, http.MethodPut, "/admin/roles/member_user", "mod_user", role); w.Code != http.StatusForbidden {
+		t.Errorf("a moderator should not set roles, but got status %v", w.Code)
+	}
+	if w := adminRequestForTesting(t, r, http.MethodPut, "/admin/roles/member_user", "admin_user", role); w.Code != http.StatusOK {
+		t.Errorf("wanted status %v on set role, but got %v: %v", http.StatusOK, w.Code, w.Body)
+	}
+	if got := models.Accounts.Role("member_user"); got != models.RoleModerator {
+		t.Errorf("wanted role %v, but got %v", models.RoleModerator, got)
+	}
+}
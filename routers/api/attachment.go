@@ -0,0 +1,87 @@
+package api
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/fyerfyer/chatroom/models"
+	"github.com/fyerfyer/chatroom/pkg/setting"
+	"github.com/gin-gonic/gin"
+)
+
+// what a multipart upload may carry on top of the file
+const uploadOverhead = 1 << 20
+
+// UploadHandler stores the "file" of a multipart form for the user of the token,
+// the returned ID is sent in the attachments of a message
+func UploadHandler(c *gin.Context) {
+	name, err := verifyRequest(c.Request)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, setting.MaxAttachmentSize+uploadOverhead)
+	header, err := c.FormFile("file")
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": models.ErrAttachmentTooLarge.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	f, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer f.Close()
+
+	attachment, err := models.Attachments.Upload(name, header.Filename, f)
+	switch {
+	case errors.Is(err, models.ErrAttachmentTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, models.ErrAttachmentType):
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, attachment)
+}
+
+// AttachmentHandler sends an attachment to a user who could see its message,
+// it is not found for anyone else
+func AttachmentHandler(c *gin.Context) {
+	name, err := verifyRequest(c.Request)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	attachment, f, err := models.Attachments.Open(name, c.Param("id"))
+	if errors.Is(err, models.ErrAttachmentNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer f.Close()
+
+	// the sniffed type is trusted, never one the browser guesses
+	c.Header("Content-Type", attachment.Type)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name}))
+	c.Header("ETag", `"`+attachment.Checksum+`"`)
+	http.ServeContent(c.Writer, c.Request, "", time.Time{}, f)
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/fyerfyer/chatroom/models"
+	"github.com/fyerfyer/chatroom/pkg/auth"
+	"github.com/fyerfyer/chatroom/pkg/blob"
+	"github.com/gin-gonic/gin"
+)
+
+func uploadForTesting(t *testing.T, r *gin.Engine, name, filename, content string) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("file", filename)
+	part.Write([]byte(content))
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if name != "" {
+		token, _, _ := auth.IssueToken(name)
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestAttachmentAPI(t *testing.T) {
+	dir := t.TempDir()
+	blobs, err := blob.NewDisk(filepath.Join(dir, "blobs"))
+	if err != nil {
+		t.Fatalf("failed to open blob storage: %v", err)
+	}
+	if err := models.Attachments.Load(blobs, filepath.Join(dir, "attachments.json")); err != nil {
+		t.Fatalf("failed to load attachments: %v", err)
+	}
+
+	r := gin.Default()
+	r.POST("/upload", UploadHandler)
+	r.GET("/attachments/:id", AttachmentHandler)
+
+	if w := uploadForTesting(t, r, "", "notes.txt", "hello"); w.Code != http.StatusUnauthorized {
+		t.Errorf("wanted status %v without a token, but got %v", http.StatusUnauthorized, w.Code)
+	}
+	if w := uploadForTesting(t, r, "alice", "notes.txt", "\x00\x01\x02\x03"); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("wanted status %v for binary data, but got %v", http.StatusUnsupportedMediaType, w.Code)
+	}
+
+	w := uploadForTesting(t, r, "alice", "notes.txt", "hello")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("wanted status %v, but got %v: %v", http.StatusCreated, w.Code, w.Body.String())
+	}
+	var attachment models.Attachment
+	json.Unmarshal(w.Body.Bytes(), &attachment)
+	if attachment.ID == "" || attachment.Name != "notes.txt" || attachment.Size != 5 {
+		t.Fatalf("wrong attachment: %+v", attachment)
+	}
+
+	w = adminRequestForTesting(t, r, http.MethodGet, attachment.URL, "alice", nil)
+	if w.Code != http.StatusOK || w.Body.String() != "hello" ||
+		w.Header().Get("Content-Type") != attachment.Type ||
+		w.Header().Get("Content-Disposition") != `attachment; filename=notes.txt` {
+		t.Errorf("alice should have got her upload, but got %v %v %v", w.Code, w.Header(), w.Body.String())
+	}
+
+	// nobody else could have seen it
+	if w := adminRequestForTesting(t, r, http.MethodGet, attachment.URL, "bob", nil); w.Code != http.StatusNotFound {
+		t.Errorf("wanted status %v for bob, but got %v", http.StatusNotFound, w.Code)
+	}
+	if w := adminRequestForTesting(t, r, http.MethodGet, attachment.URL, "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("wanted status %v without a token, but got %v", http.StatusUnauthorized, w.Code)
+	}
+}
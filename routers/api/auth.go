@@ -0,0 +1,118 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fyerfyer/chatroom/models"
+	"github.com/fyerfyer/chatroom/pkg/auth"
+	"github.com/fyerfyer/chatroom/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// the subprotocol the server speaks, browsers cannot set headers
+	// so they offer the token as a second subprotocol "token.<token>"
+	chatSubprotocol     = "chatroom"
+	tokenSubprotocolTag = "token."
+)
+
+var missingTokenErr = errors.New("missing token")
+
+type credentials struct {
+	Name     string `json:"name" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type tokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func RegisterHandler(c *gin.Context) {
+	var cred credentials
+	if err := c.ShouldBindJSON(&cred); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := utils.ValidateName(cred.Name); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	account, err := models.Accounts.Register(cred.Name, cred.Password)
+	switch {
+	case errors.Is(err, models.ErrAccountExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, models.ErrPasswordTooShort):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"name": account.Name, "created_at": account.CreatedAt})
+}
+
+func LoginHandler(c *gin.Context) {
+	var cred credentials
+	if err := c.ShouldBindJSON(&cred); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	account, err := models.Accounts.Authenticate(cred.Name, cred.Password)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, expiresAt, err := auth.IssueToken(account.Name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokenResponse{Token: token, ExpiresAt: expiresAt})
+}
+
+// requestToken takes the session token from the Authorization header
+// or from the websocket subprotocols offered by the client
+func requestToken(r *http.Request) (string, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			return "", auth.ErrInvalidToken
+		}
+		return token, nil
+	}
+
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(header, ",") {
+			if token, ok := strings.CutPrefix(strings.TrimSpace(protocol), tokenSubprotocolTag); ok {
+				return token, nil
+			}
+		}
+	}
+
+	return "", missingTokenErr
+}
+
+// verifyRequest returns the name of the user the request's token was issued to
+func verifyRequest(r *http.Request) (string, error) {
+	token, err := requestToken(r)
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := auth.ParseToken(token)
+	if err != nil {
+		return "", err
+	}
+	return claims.Name, nil
+}
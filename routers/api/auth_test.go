@@ -0,0 +1,76 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/fyerfyer/chatroom/models"
+	"github.com/fyerfyer/chatroom/pkg/auth"
+	"github.com/gin-gonic/gin"
+)
+
+func postJSONForTesting(r *gin.Engine, path string, body interface{}) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	if err := models.Accounts.Load(filepath.Join(t.TempDir(), "accounts.json")); err != nil {
+		t.Fatalf("failed to load accounts: %v", err)
+	}
+	defer models.Accounts.Load("")
+
+	r := gin.Default()
+	r.POST("/register", RegisterHandler)
+	r.POST("/login", LoginHandler)
+
+	cred := credentials{Name: "testing_user", Password: "secret_password"}
+	if w := postJSONForTesting(r, "/register", cred); w.Code != http.StatusCreated {
+		t.Errorf("wanted status %v on register, but got %v: %v", http.StatusCreated, w.Code, w.Body)
+		return
+	}
+	if w := postJSONForTesting(r, "/register", cred); w.Code != http.StatusConflict {
+		t.Errorf("wanted status %v on duplicate register, but got %v", http.StatusConflict, w.Code)
+		return
+	}
+
+	w := postJSONForTesting(r, "/login", credentials{Name: cred.Name, Password: "wrong_password"})
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("wanted status %v on wrong password, but got %v", http.StatusUnauthorized, w.Code)
+		return
+	}
+
+	// nothing tells a name without an account from a wrong password
+	unknown := postJSONForTesting(r, "/login", credentials{Name: "testing_nobody", Password: "wrong_password"})
+	if unknown.Code != w.Code || unknown.Body.String() != w.Body.String() {
+		t.Errorf("wanted %v %v for an unknown name, but got %v %v", w.Code, w.Body, unknown.Code, unknown.Body)
+	}
+
+	w = postJSONForTesting(r, "/login", cred)
+	if w.Code != http.StatusOK {
+		t.Errorf("wanted status %v on login, but got %v: %v", http.StatusOK, w.Code, w.Body)
+		return
+	}
+
+	var res tokenResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("failed to decode login response: %v", err)
+	}
+
+	claims, err := auth.ParseToken(res.Token)
+	if err != nil {
+		t.Errorf("login should return a valid token, but got: %v", err)
+		return
+	}
+	if claims.Name != cred.Name {
+		t.Errorf("wanted token for %v, but got %v", cred.Name, claims.Name)
+	}
+}
@@ -0,0 +1,278 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/fyerfyer/chatroom/models"
+)
+
+type frameHandler func(user *models.User, frame *Frame) error
+
+var frameHandlers = map[string]frameHandler{
+	FrameSend:      handleSend,
+	FramePrivate:   handlePrivate,
+	FrameJoin:      handleJoin,
+	FrameLeave:     handleLeave,
+	FrameTyping:    handleTyping,
+	FrameListUsers: handleListUsers,
+	FrameEdit:      handleEdit,
+	FrameDelete:    handleDelete,
+	FrameKick:      handleKick,
+	FrameBan:       handleBan,
+	FrameMute:      handleMute,
+	FrameUnmute:    handleUnmute,
+	FramePresence:  handlePresence,
+	FrameReact:     handleReact,
+	FrameUnreact:   handleUnreact,
+	FrameFollow:    handleFollow,
+	FrameUnfollow:  handleUnfollow,
+	FrameAck:       handleAck,
+}
+
+// dispatchFrame validates a frame and runs the handler of its type.
+// A bad frame is answered with an error message and never ends the session.
+func dispatchFrame(user *models.User, data json.RawMessage) error {
+	var frame Frame
+	if err := json.Unmarshal(data, &frame); err != nil {
+		replyError(user, models.ErrMalformedFrame)
+		return nil
+	}
+
+	if frame.Version != ProtocolVersion {
+		replyError(user, unsupportedVersionErr)
+		return nil
+	}
+
+	handler, ok := frameHandlers[frame.Type]
+	if !ok {
+		replyError(user, unknownFrameTypeErr)
+		return nil
+	}
+
+	if err := handler(user, &frame); err != nil {
+		replyError(user, err)
+	}
+	return nil
+}
+
+func replyError(user *models.User, err error) {
+	log.Printf("bad frame from %s: %v", user.Name, err)
+	user.Notify(models.NewErrorMsg(err.Error()))
+}
+
+func handleSend(user *models.User, frame *Frame) error {
+	var p SendPayload
+	if err := decodePayload(frame, &p); err != nil {
+		return err
+	}
+
+	if p.ParentID != "" {
+		user.Reply(p.ParentID, p.Content, p.Attachments...)
+		return nil
+	}
+
+	// commands take no attachments
+	if len(p.Attachments) > 0 {
+		user.Say(p.Room, p.Content, p.Attachments...)
+		return nil
+	}
+
+	user.Send(p.Room, p.Content)
+	return nil
+}
+
+func handlePrivate(user *models.User, frame *Frame) error {
+	var p PrivatePayload
+	if err := decodePayload(frame, &p); err != nil {
+		return err
+	}
+
+	return user.Whisper(p.To, p.Content, p.Attachments...)
+}
+
+func handleJoin(user *models.User, frame *Frame) error {
+	var p RoomPayload
+	if err := decodePayload(frame, &p); err != nil {
+		return err
+	}
+
+	return models.Broadcaster.JoinRoom(user, p.Room)
+}
+
+func handleLeave(user *models.User, frame *Frame) error {
+	var p RoomPayload
+	if err := decodePayload(frame, &p); err != nil {
+		return err
+	}
+
+	return models.Broadcaster.LeaveRoom(user, p.Room)
+}
+
+func handleTyping(user *models.User, frame *Frame) error {
+	var p TypingPayload
+	if err := decodePayload(frame, &p); err != nil {
+		return err
+	}
+
+	return user.Typing(p.Room, p.To)
+}
+
+func handlePresence(user *models.User, frame *Frame) error {
+	var p PresencePayload
+	if err := decodePayload(frame, &p); err != nil {
+		return err
+	}
+
+	return models.Broadcaster.SetPresence(user, p.Presence)
+}
+
+func handleEdit(user *models.User, frame *Frame) error {
+	var p EditPayload
+	if err := decodePayload(frame, &p); err != nil {
+		return err
+	}
+
+	return models.Broadcaster.EditMessage(user, p.ID, p.Content)
+}
+
+func handleDelete(user *models.User, frame *Frame) error {
+	var p DeletePayload
+	if err := decodePayload(frame, &p); err != nil {
+		return err
+	}
+
+	return models.Broadcaster.DeleteMessage(user, p.ID)
+}
+
+func handleReact(user *models.User, frame *Frame) error {
+	var p ReactPayload
+	if err := decodePayload(frame, &p); err != nil {
+		return err
+	}
+
+	return models.Broadcaster.React(user, p.ID, p.Emoji)
+}
+
+func handleUnreact(user *models.User, frame *Frame) error {
+	var p ReactPayload
+	if err := decodePayload(frame, &p); err != nil {
+		return err
+	}
+
+	return models.Broadcaster.Unreact(user, p.ID, p.Emoji)
+}
+
+func handleFollow(user *models.User, frame *Frame) error {
+	var p ThreadPayload
+	if err := decodePayload(frame, &p); err != nil {
+		return err
+	}
+
+	return models.Broadcaster.Follow(user, p.ID)
+}
+
+func handleUnfollow(user *models.User, frame *Frame) error {
+	var p ThreadPayload
+	if err := decodePayload(frame, &p); err != nil {
+		return err
+	}
+
+	return models.Broadcaster.Unfollow(user, p.ID)
+}
+
+func handleAck(user *models.User, frame *Frame) error {
+	var p AckPayload
+	if err := decodePayload(frame, &p); err != nil {
+		return err
+	}
+
+	return models.Broadcaster.MarkRead(user, p.Room, p.Seq)
+}
+
+func handleListUsers(user *models.User, frame *Frame) error {
+	user.Notify(models.NewUserListMessage(models.Broadcaster.GetUserList()))
+	return nil
+}
+
+// checkModerator returns the account of the user going by the target name,
+// or an error unless the user may moderate it
+func checkModerator(user *models.User, target string) (string, error) {
+	account := models.Broadcaster.AccountOf(target)
+	if !models.CanModerate(user.Role, models.Accounts.Role(account)) {
+		return "", models.ErrPermissionDenied
+	}
+	return account, nil
+}
+
+func handleKick(user *models.User, frame *Frame) error {
+	var p KickPayload
+	if err := decodePayload(frame, &p); err != nil {
+		return err
+	}
+
+	account, err := checkModerator(user, p.User)
+	if err != nil {
+		return err
+	}
+	return models.Broadcaster.Kick(account, p.Reason)
+}
+
+func handleBan(user *models.User, frame *Frame) error {
+	var p BanPayload
+	if err := decodePayload(frame, &p); err != nil {
+		return err
+	}
+
+	// an address is not anyone's in particular, any moderator can ban it
+	var account string
+	if p.User != "" {
+		var err error
+		if account, err = checkModerator(user, p.User); err != nil {
+			return err
+		}
+	} else if !models.HasRole(user.Role, models.RoleModerator) {
+		return models.ErrPermissionDenied
+	}
+
+	d, _ := parseDuration(p.Duration)
+	_, err := models.Broadcaster.Ban(&models.Ban{
+		Name:   account,
+		Addr:   p.Addr,
+		Reason: p.Reason,
+		By:     user.Name,
+	}, d)
+	return err
+}
+
+// handleMute mutes the user for the duration, or forever if it is empty
+func handleMute(user *models.User, frame *Frame) error {
+	var p MutePayload
+	if err := decodePayload(frame, &p); err != nil {
+		return err
+	}
+
+	account, err := checkModerator(user, p.User)
+	if err != nil {
+		return err
+	}
+
+	d, _ := parseDuration(p.Duration)
+	models.Broadcaster.Mute(account, d)
+	return nil
+}
+
+func handleUnmute(user *models.User, frame *Frame) error {
+	var p MutePayload
+	if err := decodePayload(frame, &p); err != nil {
+		return err
+	}
+
+	account, err := checkModerator(user, p.User)
+	if err != nil {
+		return err
+	}
+
+	models.Broadcaster.Unmute(account)
+	return nil
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fyerfyer/chatroom/models"
+)
+
+// waitMessage waits for the next message of the given type in the user's queue
+func waitMessage(user *models.User, msgType int) *models.Message {
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case msg := <-user.MessageChannel:
+			if msg.Type == msgType {
+				return msg
+			}
+		case <-timeout:
+			return nil
+		}
+	}
+}
+
+func TestDispatchFrame(t *testing.T) {
+	user := &models.User{
+		ID:             1000,
+		Name:           "dispatch_user",
+		Room:           "dispatch_room",
+		MessageChannel: make(chan *models.Message, 32),
+	}
+	models.Broadcaster.UserLogin(user)
+	defer models.Broadcaster.UserLogout(user)
+
+	t.Run("send", func(t *testing.T) {
+		dispatchFrame(user, []byte(`{"v":1,"type":"send","payload":{"content":"hello @someone"}}`))
+
+		msg := waitMessage(user, models.MsgTypeNormal)
+		if msg == nil || msg.Content != "hello @someone" || msg.Room != "dispatch_room" {
+			t.Errorf("wanted the message in dispatch_room, but got %v", msg)
+			return
+		}
+		if len(msg.Ats) != 1 || msg.Ats[0] != "@someone" {
+			t.Errorf("wanted mention @someone, but got %v", msg.Ats)
+		}
+	})
+
+	t.Run("join and list users", func(t *testing.T) {
+		dispatchFrame(user, []byte(`{"v":1,"type":"join","payload":{"room":"other_room"}}`))
+		dispatchFrame(user, []byte(`{"v":1,"type":"send","payload":{"room":"other_room","content":"hi"}}`))
+
+		if msg := waitMessage(user, models.MsgTypeNormal); msg == nil || msg.Room != "other_room" {
+			t.Errorf("wanted the message in other_room, but got %v", msg)
+			return
+		}
+
+		dispatchFrame(user, []byte(`{"v":1,"type":"list_users"}`))
+		if msg := waitMessage(user, models.MsgTypeUserList); msg == nil {
+			t.Error("wanted a user list message")
+		}
+	})
+
+	t.Run("command", func(t *testing.T) {
+		dispatchFrame(user, []byte(`{"v":1,"type":"send","payload":{"content":"/who"}}`))
+		if msg := waitMessage(user, models.MsgTypeUserList); msg == nil {
+			t.Error("wanted the user list for /who")
+		}
+	})
+
+	t.Run("presence", func(t *testing.T) {
+		dispatchFrame(user, []byte(`{"v":1,"type":"presence","payload":{"presence":"busy"}}`))
+		if user.Presence != models.PresenceBusy {
+			t.Errorf("wanted the user busy, but got %q", user.Presence)
+		}
+	})
+
+	// every bad frame is answered with an error message
+	badFrames := map[string]string{
+		"malformed json":       `{"v":1,"type":`,
+		"legacy frame":         `{"content":"hi"}`,
+		"unknown type":         `{"v":1,"type":"dance"}`,
+		"missing payload":      `{"v":1,"type":"send"}`,
+		"missing content":      `{"v":1,"type":"send","payload":{"room":"dispatch_room"}}`,
+		"wrong content type":   `{"v":1,"type":"send","payload":{"content":42}}`,
+		"invalid recipient":    `{"v":1,"type":"private","payload":{"to":"x","content":"hi"}}`,
+		"leave unjoined room":  `{"v":1,"type":"leave","payload":{"room":"unjoined_room"}}`,
+		"invalid presence":     `{"v":1,"type":"presence","payload":{"presence":"asleep"}}`,
+		"typing to nobody":     `{"v":1,"type":"typing","payload":{"to":"x"}}`,
+		"edit unknown message": `{"v":1,"type":"edit","payload":{"id":"missing","content":"hi"}}`,
+		"delete without id":    `{"v":1,"type":"delete","payload":{}}`,
+		"ack without seq":      `{"v":1,"type":"ack","payload":{"room":"dispatch_room"}}`,
+		"ack unjoined room":    `{"v":1,"type":"ack","payload":{"room":"unjoined_room","seq":1}}`,
+	}
+
+	for name, frame := range badFrames {
+		t.Run(name, func(t *testing.T) {
+			if err := dispatchFrame(user, []byte(frame)); err != nil {
+				t.Errorf("a bad frame should not end the session, but got %v", err)
+				return
+			}
+
+			if msg := waitMessage(user, models.MsgTypeError); msg == nil {
+				t.Error("wanted an error message")
+			}
+		})
+	}
+}
+
+func TestModerationFrames(t *testing.T) {
+	moderator := &models.User{
+		ID:             1001,
+		Name:           "mod_user",
+		Role:           models.RoleModerator,
+		MessageChannel: make(chan *models.Message, 32),
+	}
+	member := &models.User{
+		ID:             1002,
+		Name:           "member_user",
+		MessageChannel: make(chan *models.Message, 32),
+	}
+	models.Broadcaster.UserLogin(moderator)
+	models.Broadcaster.UserLogin(member)
+	defer models.Broadcaster.UserLogout(moderator)
+	defer models.Broadcaster.UserLogout(member)
+
+	dispatchFrame(member, []byte(`{"v":1,"type":"mute","payload":{"user":"mod_user"}}`))
+	if msg := waitMessage(member, models.MsgTypeError); msg == nil || msg.Content != models.ErrPermissionDenied.Error() {
+		t.Errorf("a member should not mute anyone, but got %v", msg)
+	}
+
+	dispatchFrame(moderator, []byte(`{"v":1,"type":"mute","payload":{"user":"member_user","duration":"1h"}}`))
+	if !member.Muted() {
+		t.Fatalf("%v should be muted", member.Name)
+	}
+
+	dispatchFrame(moderator, []byte(`{"v":1,"type":"unmute","payload":{"user":"member_user"}}`))
+	if member.Muted() {
+		t.Errorf("%v should not be muted anymore", member.Name)
+	}
+
+	dispatchFrame(moderator, []byte(`{"v":1,"type":"kick","payload":{"user":"member_user","reason":"spam"}}`))
+	if models.Broadcaster.CheckUserCanLogout(member.Name) {
+		t.Errorf("%v should have been kicked", member.Name)
+	}
+}
+
+func TestModerationAfterRename(t *testing.T) {
+	if err := models.Accounts.Load(filepath.Join(t.TempDir(), "accounts.json")); err != nil {
+		t.Fatalf("failed to load accounts: %v", err)
+	}
+	defer models.Accounts.Load("")
+	defer models.Bans.Load("")
+	registerForTesting(t, "renamed_admin", models.RoleAdmin)
+	registerForTesting(t, "renamed_member", models.RoleMember)
+
+	moderator := &models.User{ID: 1003, Name: "rename_mod", Role: models.RoleModerator,
+		MessageChannel: make(chan *models.Message, 32)}
+	admin := models.NewUser(nil, "renamed_admin", "")
+	member := models.NewUser(nil, "renamed_member", "")
+	for _, user := range []*models.User{moderator, admin, member} {
+		models.Broadcaster.UserLogin(user)
+		defer models.Broadcaster.UserLogout(user)
+	}
+	if err := models.Broadcaster.Rename(admin, "just_a_member"); err != nil {
+		t.Fatalf("failed to rename: %v", err)
+	}
+	if err := models.Broadcaster.Rename(member, "someone_else"); err != nil {
+		t.Fatalf("failed to rename: %v", err)
+	}
+
+	// the nick is not the account, the admin stays an admin
+	dispatchFrame(moderator, []byte(`{"v":1,"type":"kick","payload":{"user":"just_a_member"}}`))
+	if msg := waitMessage(moderator, models.MsgTypeError); msg == nil || msg.Content != models.ErrPermissionDenied.Error() {
+		t.Errorf("a moderator should not kick an admin by a new name, but got %v", msg)
+	}
+
+	dispatchFrame(moderator, []byte(`{"v":1,"type":"ban","payload":{"user":"someone_else","duration":"1h"}}`))
+	if err := models.Bans.Check("renamed_member", ""); err != models.ErrBannedName {
+		t.Errorf("the account should be banned, but got %v", err)
+	}
+	if models.Broadcaster.CheckUserCanLogout("someone_else") {
+		t.Error("the banned user should have been kicked")
+	}
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fyerfyer/chatroom/models"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 200
+)
+
+var (
+	invalidCursorErr = errors.New("invalid cursor")
+	invalidLimitErr  = errors.New("invalid limit")
+	invalidTimeErr   = errors.New("invalid time, use RFC 3339")
+)
+
+// HistoryHandler pages through the stored messages of the rooms the user of the token is in.
+// Without cursor or with `before` it returns the newest messages before
+// the cursor, with `after` the oldest ones after it, always in sequence order.
+func HistoryHandler(c *gin.Context) {
+	name, err := verifyRequest(c.Request)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	q, err := parseHistoryQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// the history of a room is only shown to its members
+	if q.Room != "" {
+		if !models.Broadcaster.InRoom(name, q.Room) {
+			c.JSON(http.StatusForbidden, gin.H{"error": models.ErrNotInRoom.Error()})
+			return
+		}
+	} else if q.Rooms = models.Broadcaster.RoomsOf(name); len(q.Rooms) == 0 {
+		c.JSON(http.StatusOK, []*models.Message{})
+		return
+	}
+
+	msgs, err := models.Broadcaster.Store().Range(q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if msgs == nil {
+		msgs = []*models.Message{}
+	}
+	c.JSON(http.StatusOK, msgs)
+}
+
+func parseHistoryQuery(c *gin.Context) (models.Query, error) {
+	q := models.Query{
+		Room:  c.Query("room"),
+		From:  c.Query("from"),
+		Limit: defaultHistoryLimit,
+	}
+
+	var err error
+	if q.BeforeSeq, err = parseCursor(c.Query("before")); err != nil {
+		return q, err
+	}
+	if q.AfterSeq, err = parseCursor(c.Query("after")); err != nil {
+		return q, err
+	}
+	q.Latest = q.AfterSeq == 0
+
+	if limit := c.Query("limit"); limit != "" {
+		q.Limit, err = strconv.Atoi(limit)
+		if err != nil || q.Limit <= 0 {
+			return q, invalidLimitErr
+		}
+		q.Limit = min(q.Limit, maxHistoryLimit)
+	}
+
+	if q.Since, err = parseTime(c.Query("since")); err != nil {
+		return q, err
+	}
+	if q.Until, err = parseTime(c.Query("until")); err != nil {
+		return q, err
+	}
+
+	return q, nil
+}
+
+func parseCursor(s string) (uint64, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	seq, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, invalidCursorErr
+	}
+	return seq, nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, invalidTimeErr
+	}
+	return t, nil
+}
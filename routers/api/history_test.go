@@ -0,0 +1,135 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/fyerfyer/chatroom/models"
+	"github.com/gin-gonic/gin"
+)
+
+func getHistoryForTesting(t *testing.T, r *gin.Engine, query string) (int, []*models.Message) {
+	w := adminRequestForTesting(t, r, http.MethodGet, "/history"+query, "history_reader", nil)
+
+	var msgs []*models.Message
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &msgs); err != nil {
+			t.Fatalf("failed to decode history: %v", err)
+		}
+	}
+
+	return w.Code, msgs
+}
+
+func TestHistoryHandler(t *testing.T) {
+	store, err := models.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open file store: %v", err)
+	}
+	defer store.Close()
+
+	models.Broadcaster.SetStore(store)
+	defer models.Broadcaster.SetStore(models.UserMessageProcessor)
+
+	alice := &models.User{Name: "alice"}
+	bob := &models.User{Name: "bob"}
+	for i := 0; i < 10; i++ {
+		sender, room := alice, "room_a"
+		if i%2 == 1 {
+			sender, room = bob, "room_b"
+		}
+		msg := models.NewMessage(sender, models.MsgTypeNormal, "message:"+strconv.Itoa(i))
+		msg.Room = room
+		store.Append(msg)
+	}
+
+	// only the members of a room see its history
+	reader := &models.User{Name: "history_reader", Room: "room_a", MessageChannel: make(chan *models.Message, 64)}
+	models.Broadcaster.UserLogin(reader)
+	defer models.Broadcaster.UserLogout(reader)
+	models.Broadcaster.JoinRoom(reader, "room_b")
+
+	r := gin.Default()
+	r.GET("/history", HistoryHandler)
+
+	t.Run("latest page", func(t *testing.T) {
+		_, msgs := getHistoryForTesting(t, r, "?limit=3")
+		if len(msgs) != 3 || msgs[0].Seq != 8 || msgs[2].Seq != 10 {
+			t.Errorf("wanted messages 8 to 10, but got %v", msgs)
+		}
+	})
+
+	t.Run("before cursor", func(t *testing.T) {
+		_, msgs := getHistoryForTesting(t, r, "?before=8&limit=3")
+		if len(msgs) != 3 || msgs[0].Seq != 5 || msgs[2].Seq != 7 {
+			t.Errorf("wanted messages 5 to 7, but got %v", msgs)
+		}
+	})
+
+	t.Run("after cursor", func(t *testing.T) {
+		_, msgs := getHistoryForTesting(t, r, "?after=2&limit=2")
+		if len(msgs) != 2 || msgs[0].Seq != 3 || msgs[1].Seq != 4 {
+			t.Errorf("wanted messages 3 and 4, but got %v", msgs)
+		}
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		_, msgs := getHistoryForTesting(t, r, "?from=bob&room=room_b")
+		if len(msgs) != 5 {
+			t.Errorf("wanted 5 messages from bob, but got %v", len(msgs))
+			return
+		}
+		for _, msg := range msgs {
+			if msg.User.Name != "bob" || msg.Room != "room_b" {
+				t.Errorf("wanted only bob's messages in room_b, but got %v", msg)
+			}
+		}
+
+		if _, msgs = getHistoryForTesting(t, r, "?from=alice&room=room_b"); len(msgs) != 0 {
+			t.Errorf("alice has no message in room_b, but got %v", msgs)
+		}
+	})
+
+	t.Run("reactions", func(t *testing.T) {
+		reacted, _ := store.Range(models.Query{AfterSeq: 9})
+		msg := *reacted[0]
+		msg.Reactions = []models.Reaction{{Emoji: "+1", Count: 1, Users: []string{"alice"}}}
+		store.Update(&msg)
+
+		_, msgs := getHistoryForTesting(t, r, "?after=9")
+		if len(msgs) != 1 || len(msgs[0].Reactions) != 1 || msgs[0].Reactions[0].Count != 1 {
+			t.Errorf("wanted the reactions of message 10, but got %v", msgs)
+		}
+	})
+
+	t.Run("access", func(t *testing.T) {
+		if w := adminRequestForTesting(t, r, http.MethodGet, "/history", "", nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("wanted status %v without a token, but got %v", http.StatusUnauthorized, w.Code)
+		}
+		if w := adminRequestForTesting(t, r, http.MethodGet, "/history?room=room_a", "outsider", nil); w.Code != http.StatusForbidden {
+			t.Errorf("wanted status %v out of the room, but got %v", http.StatusForbidden, w.Code)
+		}
+
+		models.Broadcaster.LeaveRoom(reader, "room_b")
+		defer models.Broadcaster.JoinRoom(reader, "room_b")
+		_, msgs := getHistoryForTesting(t, r, "?limit=10")
+		for _, msg := range msgs {
+			if msg.Room != "room_a" {
+				t.Errorf("wanted only the messages of room_a, but got %v", msg)
+			}
+		}
+		if len(msgs) != 5 {
+			t.Errorf("wanted the 5 messages of room_a, but got %v", len(msgs))
+		}
+	})
+
+	t.Run("bad request", func(t *testing.T) {
+		for _, query := range []string{"?before=x", "?limit=-1", "?since=yesterday"} {
+			if code, _ := getHistoryForTesting(t, r, query); code != http.StatusBadRequest {
+				t.Errorf("wanted status %v for %v, but got %v", http.StatusBadRequest, query, code)
+			}
+		}
+	})
+}
@@ -0,0 +1,28 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/fyerfyer/chatroom/pkg/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// the reasons a websocket login is refused
+const (
+	failureRateLimited  = "rate_limited"
+	failureInvalidToken = "invalid_token"
+	failureInvalidName  = "invalid_name"
+	failureBanned       = "banned"
+	failureDuplicate    = "duplicate"
+	failureInvalidRoom  = "invalid_room"
+)
+
+var loginFailures = metrics.NewCounterVec("chatroom_login_failures_total",
+	"Websocket logins refused, by reason.", "reason")
+
+// MetricsHandler serves the metrics in the Prometheus text format
+func MetricsHandler(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	metrics.Default.Write(c.Writer)
+}
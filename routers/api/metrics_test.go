@@ -0,0 +1,65 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"nhooyr.io/websocket"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	r := gin.Default()
+	r.GET("/ws", WebSocketHandler)
+	r.GET("/metrics", MetricsHandler)
+
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	before := loginFailures.Value(failureInvalidToken)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	url := "ws://" + server.Listener.Addr().String() + "/ws"
+	conn, _, err := websocket.Dial(ctx, url, &websocket.DialOptions{
+		HTTPHeader: http.Header{"Authorization": []string{"Bearer forged.token"}},
+	})
+	if err != nil {
+		t.Fatalf("failed to establish websocket connection: %v", err)
+	}
+	conn.Read(ctx)
+	conn.Close(websocket.StatusNormalClosure, "")
+
+	if got := loginFailures.Value(failureInvalidToken) - before; got != 1 {
+		t.Errorf("wanted 1 more login failure, but got %v", got)
+	}
+
+	res, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("failed to get metrics: %v", err)
+	}
+	defer res.Body.Close()
+
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %v", res.Header.Get("Content-Type"))
+	}
+
+	body, _ := io.ReadAll(res.Body)
+	for _, want := range []string{
+		`chatroom_login_failures_total{reason="invalid_token"}`,
+		"# TYPE chatroom_online_users gauge",
+		"# TYPE chatroom_broadcast_queue_length gauge",
+		"# TYPE chatroom_connection_duration_seconds histogram",
+		"# TYPE chatroom_dropped_messages_total counter",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics should contain %q", want)
+		}
+	}
+}
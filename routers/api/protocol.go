@@ -0,0 +1,260 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/fyerfyer/chatroom/models"
+	"github.com/fyerfyer/chatroom/pkg/utils"
+)
+
+// ProtocolVersion is the version of the frames the client sends
+const ProtocolVersion = 1
+
+// the types of the client frames
+const (
+	FrameSend      = "send"
+	FramePrivate   = "private"
+	FrameJoin      = "join"
+	FrameLeave     = "leave"
+	FrameTyping    = "typing"
+	FrameListUsers = "list_users"
+	FrameEdit      = "edit"
+	FrameDelete    = "delete"
+	FrameKick      = "kick"
+	FrameBan       = "ban"
+	FrameMute      = "mute"
+	FrameUnmute    = "unmute"
+	FramePresence  = "presence"
+	FrameReact     = "react"
+	FrameUnreact   = "unreact"
+	FrameFollow    = "follow"
+	FrameUnfollow  = "unfollow"
+	FrameAck       = "ack"
+)
+
+var (
+	unsupportedVersionErr = errors.New("unsupported protocol version")
+	unknownFrameTypeErr   = errors.New("unknown frame type")
+	missingPayloadErr     = errors.New("missing payload")
+	missingContentErr     = errors.New("missing content")
+	missingMessageIDErr   = errors.New("missing message id")
+	invalidDurationErr    = errors.New("invalid duration")
+)
+
+// Frame is the envelope of every message a client sends:
+//
+//	{"v": 1, "type": "send", "payload": {"room": "lobby", "content": "hi"}}
+type Frame struct {
+	Version int             `json:"v"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// payload is validated after it is decoded from the frame
+type payload interface {
+	validate() error
+}
+
+// SendPayload says the content in the room, or replies in the thread of the parent.
+// Attachments are the IDs of uploads, a message with some may have no content.
+type SendPayload struct {
+	Room        string   `json:"room"`
+	Content     string   `json:"content"`
+	ParentID    string   `json:"parent_id"`
+	Attachments []string `json:"attachments"`
+}
+
+func (p *SendPayload) validate() error {
+	if p.Content == "" && len(p.Attachments) == 0 {
+		return missingContentErr
+	}
+	if p.Room != "" {
+		return utils.ValidateRoomName(p.Room)
+	}
+	return nil
+}
+
+type PrivatePayload struct {
+	To          string   `json:"to"`
+	Content     string   `json:"content"`
+	Attachments []string `json:"attachments"`
+}
+
+func (p *PrivatePayload) validate() error {
+	if p.Content == "" && len(p.Attachments) == 0 {
+		return missingContentErr
+	}
+	return utils.ValidateName(p.To)
+}
+
+type RoomPayload struct {
+	Room string `json:"room"`
+}
+
+func (p *RoomPayload) validate() error {
+	return utils.ValidateRoomName(p.Room)
+}
+
+// TypingPayload names the room the user is typing in, or the user it is typing to
+type TypingPayload struct {
+	Room string `json:"room"`
+	To   string `json:"to"`
+}
+
+func (p *TypingPayload) validate() error {
+	if p.To != "" {
+		return utils.ValidateName(p.To)
+	}
+	if p.Room != "" {
+		return utils.ValidateRoomName(p.Room)
+	}
+	return nil
+}
+
+type PresencePayload struct {
+	Presence string `json:"presence"`
+}
+
+func (p *PresencePayload) validate() error {
+	return nil
+}
+
+type EditPayload struct {
+	ID      string `json:"id"`
+	Content string `json:"content"`
+}
+
+func (p *EditPayload) validate() error {
+	if p.ID == "" {
+		return missingMessageIDErr
+	}
+	if p.Content == "" {
+		return missingContentErr
+	}
+	return nil
+}
+
+type DeletePayload struct {
+	ID string `json:"id"`
+}
+
+func (p *DeletePayload) validate() error {
+	if p.ID == "" {
+		return missingMessageIDErr
+	}
+	return nil
+}
+
+// ReactPayload adds or removes the emoji on the message with the ID
+type ReactPayload struct {
+	ID    string `json:"id"`
+	Emoji string `json:"emoji"`
+}
+
+func (p *ReactPayload) validate() error {
+	if p.ID == "" {
+		return missingMessageIDErr
+	}
+	if p.Emoji == "" {
+		return models.ErrInvalidEmoji
+	}
+	return nil
+}
+
+// ThreadPayload names a message of the thread
+type ThreadPayload struct {
+	ID string `json:"id"`
+}
+
+func (p *ThreadPayload) validate() error {
+	if p.ID == "" {
+		return missingMessageIDErr
+	}
+	return nil
+}
+
+// AckPayload marks the room read up to the message with the sequence number
+type AckPayload struct {
+	Room string `json:"room"`
+	Seq  uint64 `json:"seq"`
+}
+
+func (p *AckPayload) validate() error {
+	if p.Seq == 0 {
+		return models.ErrInvalidSeq
+	}
+	return utils.ValidateRoomName(p.Room)
+}
+
+type KickPayload struct {
+	User   string `json:"user"`
+	Reason string `json:"reason"`
+}
+
+func (p *KickPayload) validate() error {
+	return utils.ValidateName(p.User)
+}
+
+// BanPayload bans either a user or an address,
+// for a duration like "1h" or forever if it is empty
+type BanPayload struct {
+	User     string `json:"user"`
+	Addr     string `json:"address"`
+	Duration string `json:"duration"`
+	Reason   string `json:"reason"`
+}
+
+func (p *BanPayload) validate() error {
+	if (p.User == "") == (p.Addr == "") {
+		return models.ErrInvalidBan
+	}
+	if p.User != "" {
+		if err := utils.ValidateName(p.User); err != nil {
+			return err
+		}
+	}
+
+	_, err := parseDuration(p.Duration)
+	return err
+}
+
+type MutePayload struct {
+	User     string `json:"user"`
+	Duration string `json:"duration"`
+}
+
+func (p *MutePayload) validate() error {
+	if err := utils.ValidateName(p.User); err != nil {
+		return err
+	}
+
+	_, err := parseDuration(p.Duration)
+	return err
+}
+
+// parseDuration parses an optional duration, an empty one is 0
+func parseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, invalidDurationErr
+	}
+	return d, nil
+}
+
+// decodePayload decodes the frame's payload into p and validates it
+func decodePayload(frame *Frame, p payload) error {
+	if len(frame.Payload) == 0 {
+		return missingPayloadErr
+	}
+
+	if err := json.Unmarshal(frame.Payload, p); err != nil {
+		return err
+	}
+	return p.validate()
+}
@@ -0,0 +1,13 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/fyerfyer/chatroom/models"
+	"github.com/gin-gonic/gin"
+)
+
+func RoomListHandler(c *gin.Context) {
+	roomList := models.Broadcaster.GetRoomList()
+	c.JSON(http.StatusOK, roomList)
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/fyerfyer/chatroom/models"
+	"github.com/fyerfyer/chatroom/pkg/search"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+var (
+	invalidOffsetErr = errors.New("invalid offset")
+	invalidOrderErr  = errors.New("invalid order, use relevance or time")
+)
+
+// SearchHandler finds the messages matching `q`, like
+// `"release notes" from:alice in:lobby after:2026-10-01`.
+// The results are ranked by relevance, or newest first with `order=time`,
+// and paged with `offset` and `limit`. Only the rooms the user of the token is in are searched.
+func SearchHandler(c *gin.Context) {
+	name, err := verifyRequest(c.Request)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	q, err := search.ParseQuery(c.Query("q"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	order := c.DefaultQuery("order", search.OrderRelevance)
+	if order != search.OrderRelevance && order != search.OrderTime {
+		c.JSON(http.StatusBadRequest, gin.H{"error": invalidOrderErr.Error()})
+		return
+	}
+
+	offset, limit, err := parsePage(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if q.Rooms = models.Broadcaster.RoomsOf(name); len(q.Rooms) == 0 {
+		c.JSON(http.StatusOK, gin.H{"total": 0, "results": []*models.SearchResult{}})
+		return
+	}
+
+	results, total, err := models.Broadcaster.Search(q, order, offset, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"total": total, "results": results})
+}
+
+func parsePage(c *gin.Context) (int, int, error) {
+	offset, limit := 0, defaultSearchLimit
+
+	var err error
+	if s := c.Query("offset"); s != "" {
+		offset, err = strconv.Atoi(s)
+		if err != nil || offset < 0 {
+			return 0, 0, invalidOffsetErr
+		}
+	}
+	if s := c.Query("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit <= 0 {
+			return 0, 0, invalidLimitErr
+		}
+		limit = min(limit, maxSearchLimit)
+	}
+	return offset, limit, nil
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/fyerfyer/chatroom/models"
+	"github.com/gin-gonic/gin"
+)
+
+type searchResponse struct {
+	Total   int                    `json:"total"`
+	Results []*models.SearchResult `json:"results"`
+}
+
+func searchForTesting(t *testing.T, r *gin.Engine, query string) (int, searchResponse) {
+	return searchAsForTesting(t, r, "search_reader", query)
+}
+
+func searchAsForTesting(t *testing.T, r *gin.Engine, name, query string) (int, searchResponse) {
+	w := adminRequestForTesting(t, r, http.MethodGet, "/search?"+query, name, nil)
+
+	var resp searchResponse
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to decode the results: %v", err)
+		}
+	}
+	return w.Code, resp
+}
+
+func TestSearchHandler(t *testing.T) {
+	store, err := models.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open file store: %v", err)
+	}
+	defer store.Close()
+
+	for _, content := range []string{"the link is https://example.com/a", "no link here", "another link"} {
+		msg := models.NewMessage(&models.User{Name: "alice"}, models.MsgTypeNormal, content)
+		msg.Room = "lobby"
+		store.Append(msg)
+	}
+	secret := models.NewMessage(&models.User{Name: "bob"}, models.MsgTypeNormal, "a secret link")
+	secret.Room = "staff"
+	store.Append(secret)
+
+	// the index is built from what the store has
+	models.Broadcaster.SetStore(store)
+	defer models.Broadcaster.SetStore(models.UserMessageProcessor)
+	if err := models.Broadcaster.RebuildIndex(); err != nil {
+		t.Fatalf("failed to index the store: %v", err)
+	}
+
+	reader := &models.User{Name: "search_reader", Room: "lobby", MessageChannel: make(chan *models.Message, 64)}
+	models.Broadcaster.UserLogin(reader)
+	defer models.Broadcaster.UserLogout(reader)
+
+	r := gin.Default()
+	r.GET("/search", SearchHandler)
+
+	_, resp := searchForTesting(t, r, "q="+url.QueryEscape("example.com in:lobby"))
+	if resp.Total != 1 || resp.Results[0].Message.Seq != 1 ||
+		resp.Results[0].Highlight != "the link is https://<mark>example</mark>.<mark>com</mark>/a" {
+		t.Errorf("wanted the message with the link highlighted, but got %+v", resp)
+	}
+
+	_, resp = searchForTesting(t, r, "q=link&order=time&offset=1&limit=1")
+	if resp.Total != 3 || len(resp.Results) != 1 || resp.Results[0].Message.Seq != 2 {
+		t.Errorf("wanted the second newest of 3 messages, but got %+v", resp)
+	}
+
+	for _, query := range []string{"q=", "q=link&order=random", "q=link&offset=-1", "q=link&limit=0",
+		"q=" + url.QueryEscape("before:someday")} {
+		if code, _ := searchForTesting(t, r, query); code != http.StatusBadRequest {
+			t.Errorf("wanted status %v for %v, but got %v", http.StatusBadRequest, query, code)
+		}
+	}
+
+	// only the rooms of the caller are searched
+	if _, resp = searchForTesting(t, r, "q="+url.QueryEscape("secret in:staff")); resp.Total != 0 {
+		t.Errorf("wanted nothing from a room the reader is not in, but got %+v", resp)
+	}
+	if _, resp = searchAsForTesting(t, r, "outsider", "q=link"); resp.Total != 0 || resp.Results == nil {
+		t.Errorf("wanted no results for a user in no room, but got %+v", resp)
+	}
+	if code, _ := searchAsForTesting(t, r, "", "q=link"); code != http.StatusUnauthorized {
+		t.Errorf("wanted status %v without a token, but got %v", http.StatusUnauthorized, code)
+	}
+}
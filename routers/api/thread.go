@@ -0,0 +1,48 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/fyerfyer/chatroom/models"
+	"github.com/gin-gonic/gin"
+)
+
+// ThreadHandler returns the message a thread hangs off and all its replies,
+// the id of any message of the thread will do. A thread of a room the user of
+// the token is not in is not found.
+func ThreadHandler(c *gin.Context) {
+	name, err := verifyRequest(c.Request)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	store := models.Broadcaster.Store()
+	root, err := store.Get(c.Param("id"))
+	if err == nil && root.ParentID != "" {
+		root, err = store.Get(root.ParentID)
+	}
+	if err == nil && !models.Broadcaster.InRoom(name, root.Room) {
+		err = models.ErrMessageNotFound
+	}
+	if errors.Is(err, models.ErrMessageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	replies, err := store.Range(models.Query{Parent: root.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if replies == nil {
+		replies = []*models.Message{}
+	}
+	c.JSON(http.StatusOK, gin.H{"parent": root, "replies": replies})
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/fyerfyer/chatroom/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestThreadHandler(t *testing.T) {
+	store, err := models.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open file store: %v", err)
+	}
+	defer store.Close()
+
+	models.Broadcaster.SetStore(store)
+	defer models.Broadcaster.SetStore(models.UserMessageProcessor)
+
+	alice := &models.User{Name: "alice", Room: "thread_room"}
+	root := models.NewMessage(alice, models.MsgTypeNormal, "lunch?")
+	root.Room = alice.Room
+	store.Append(root)
+	store.Append(models.NewMessage(alice, models.MsgTypeNormal, "unrelated"))
+
+	var replies []*models.Message
+	for _, content := range []string{"pizza", "sushi"} {
+		reply := models.NewMessage(&models.User{Name: "bob"}, models.MsgTypeNormal, content)
+		reply.ParentID = root.ID
+		reply.Room = root.Room
+		store.Append(reply)
+		replies = append(replies, reply)
+	}
+
+	reader := &models.User{Name: "thread_reader", Room: "thread_room", MessageChannel: make(chan *models.Message, 64)}
+	models.Broadcaster.UserLogin(reader)
+	defer models.Broadcaster.UserLogout(reader)
+
+	r := gin.Default()
+	r.GET("/thread/:id", ThreadHandler)
+
+	// any message of the thread brings the whole thread
+	for _, id := range []string{root.ID, replies[1].ID} {
+		w := adminRequestForTesting(t, r, http.MethodGet, "/thread/"+id, reader.Name, nil)
+
+		var thread struct {
+			Parent  *models.Message   `json:"parent"`
+			Replies []*models.Message `json:"replies"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &thread); err != nil {
+			t.Fatalf("failed to decode the thread: %v", err)
+		}
+		if thread.Parent == nil || thread.Parent.ID != root.ID || len(thread.Replies) != 2 ||
+			thread.Replies[0].Content != "pizza" {
+			t.Errorf("wanted the thread of %v, but got %v", root.ID, w.Body.String())
+		}
+	}
+
+	w := adminRequestForTesting(t, r, http.MethodGet, "/thread/missing", reader.Name, nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("wanted status %v for a missing thread, but got %v", http.StatusNotFound, w.Code)
+	}
+
+	// the threads of other rooms are none of the outsider's business
+	if w := adminRequestForTesting(t, r, http.MethodGet, "/thread/"+root.ID, "outsider", nil); w.Code != http.StatusNotFound {
+		t.Errorf("wanted status %v out of the room, but got %v", http.StatusNotFound, w.Code)
+	}
+	if w := adminRequestForTesting(t, r, http.MethodGet, "/thread/"+root.ID, "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("wanted status %v without a token, but got %v", http.StatusUnauthorized, w.Code)
+	}
+}
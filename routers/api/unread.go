@@ -0,0 +1,40 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/fyerfyer/chatroom/models"
+	"github.com/fyerfyer/chatroom/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// UnreadHandler counts the unread messages of the user of the token in every
+// room it has read in, and in the rooms named by the `room` parameters it is in
+func UnreadHandler(c *gin.Context) {
+	name, err := verifyRequest(c.Request)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	var rooms []string
+	joined := models.Broadcaster.RoomsOf(name)
+	for _, room := range c.QueryArray("room") {
+		if err := utils.ValidateRoomName(room); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// nobody counts the messages of a room it is not in
+		if slices.Contains(joined, room) {
+			rooms = append(rooms, room)
+		}
+	}
+
+	counts, err := models.UnreadCounts(models.Broadcaster.Store(), name, rooms...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, counts)
+}
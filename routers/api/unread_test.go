@@ -0,0 +1,54 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/fyerfyer/chatroom/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestUnreadHandler(t *testing.T) {
+	store, err := models.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open file store: %v", err)
+	}
+	defer store.Close()
+
+	models.Broadcaster.SetStore(store)
+	defer models.Broadcaster.SetStore(models.UserMessageProcessor)
+
+	alice := &models.User{Name: "alice"}
+	for _, room := range []string{"room_a", "room_a", "room_b", "room_c"} {
+		msg := models.NewMessage(alice, models.MsgTypeNormal, "hi")
+		msg.Room = room
+		store.Append(msg)
+	}
+
+	// bob said one of them under another name, it is still his own
+	bob := &models.User{ID: 1201, Name: "bob", Room: "room_a", MessageChannel: make(chan *models.Message, 32)}
+	models.Broadcaster.UserLogin(bob)
+	defer models.Broadcaster.UserLogout(bob)
+	models.Broadcaster.JoinRoom(bob, "room_b")
+	renamed := models.NewMessage(&models.User{Name: "robert", Account: "bob"}, models.MsgTypeNormal, "mine")
+	renamed.Room = "room_b"
+	store.Append(renamed)
+
+	r := gin.Default()
+	r.GET("/unread", UnreadHandler)
+
+	w := adminRequestForTesting(t, r, http.MethodGet, "/unread?room=room_a&room=room_b&room=room_c", "bob", nil)
+	var counts map[string]int
+	json.Unmarshal(w.Body.Bytes(), &counts)
+	if w.Code != http.StatusOK || len(counts) != 2 || counts["room_a"] != 2 || counts["room_b"] != 1 {
+		t.Errorf("wanted 2 unread in room_a, 1 in room_b and nothing of room_c, but got %v %v", w.Code, w.Body.String())
+	}
+
+	if w := adminRequestForTesting(t, r, http.MethodGet, "/unread", "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("wanted status %v without a token, but got %v", http.StatusUnauthorized, w.Code)
+	}
+	if w := adminRequestForTesting(t, r, http.MethodGet, "/unread?room=", "bob", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("wanted status %v for a bad room, but got %v", http.StatusBadRequest, w.Code)
+	}
+}
@@ -3,8 +3,12 @@ package api
 import (
 	"errors"
 	"log"
+	"net/http"
+	"strconv"
 
 	"github.com/fyerfyer/chatroom/models"
+	"github.com/fyerfyer/chatroom/pkg/ratelimit"
+	"github.com/fyerfyer/chatroom/pkg/setting"
 	"github.com/fyerfyer/chatroom/pkg/utils"
 	"github.com/gin-gonic/gin"
 	"nhooyr.io/websocket"
@@ -14,8 +18,13 @@ import (
 var (
 	duplicateLoginErr  = errors.New("duplicate login")
 	duplicateLogoutErr = errors.New("duplicate logout")
+	shuttingDownErr    = errors.New("server is shutting down")
+	tooManyConnsErr    = errors.New("too many connection attempts, try again later")
 )
 
+// connection attempts per IP address
+var connectionLimiter = ratelimit.NewLimiter(setting.ConnectionRate, setting.ConnectionBurst)
+
 func handleError(c *gin.Context, conn *websocket.Conn,
 	msg string, status websocket.StatusCode, statusMsg string) {
 	if msg != "" {
@@ -26,6 +35,19 @@ func handleError(c *gin.Context, conn *websocket.Conn,
 }
 
 func WebSocketHandler(c *gin.Context) {
+	// no new connections while the server drains the old ones
+	if models.Broadcaster.ShuttingDown() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": shuttingDownErr.Error()})
+		return
+	}
+
+	if !connectionLimiter.Allow(utils.HostOf(c.Request.RemoteAddr)) {
+		log.Printf("too many connection attempts from %v", c.Request.RemoteAddr)
+		loginFailures.Inc(failureRateLimited)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": tooManyConnsErr.Error()})
+		return
+	}
+
 	conn, err := initWebSocketConnection(c)
 	if err != nil {
 		log.Printf("websocket accept error: %v", err)
@@ -41,9 +63,12 @@ func WebSocketHandler(c *gin.Context) {
 
 	setupUserSession(c, user)
 
-	if err := handleUserMessaging(c, user); err != nil {
-		handleError(c, conn, err.Error(),
-			websocket.StatusInternalError, "message handling error")
+	// the user is logged out however the messaging ends,
+	// including a dead connection closed by the heartbeat
+	msgErr := handleUserMessaging(c, user)
+
+	// the connection was closed by the shutdown, there is nobody to log out
+	if models.Broadcaster.ShuttingDown() {
 		return
 	}
 
@@ -53,11 +78,19 @@ func WebSocketHandler(c *gin.Context) {
 		return
 	}
 
+	if msgErr != nil {
+		handleError(c, conn, msgErr.Error(),
+			websocket.StatusInternalError, "message handling error")
+		return
+	}
+
 	conn.Close(websocket.StatusNormalClosure, "")
 }
 
 func initWebSocketConnection(c *gin.Context) (*websocket.Conn, error) {
-	conn, err := websocket.Accept(c.Writer, c.Request, nil)
+	conn, err := websocket.Accept(c.Writer, c.Request, &websocket.AcceptOptions{
+		Subprotocols: []string{chatSubprotocol},
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -65,33 +98,87 @@ func initWebSocketConnection(c *gin.Context) (*websocket.Conn, error) {
 }
 
 func authenticateUser(c *gin.Context, conn *websocket.Conn) (*models.User, error) {
-	username := c.DefaultQuery("name", "")
+	// the username comes from the verified session token
+	username, err := verifyRequest(c.Request)
+	if err != nil {
+		log.Printf("token verification failed: %v", err)
+		loginFailures.Inc(failureInvalidToken)
+		return nil, err
+	}
+
 	// log.Println("start authenticate user...")
 	if err := utils.ValidateName(username); err != nil {
 		log.Printf("illegal username: %v", username)
+		loginFailures.Inc(failureInvalidName)
+		return nil, err
+	}
+
+	// banned names and addresses are turned away before anything else
+	if err := models.Bans.Check(username, utils.HostOf(c.Request.RemoteAddr)); err != nil {
+		log.Printf("banned user refused: %v from %v", username, c.Request.RemoteAddr)
+		loginFailures.Inc(failureBanned)
 		return nil, err
 	}
 
-	if !models.Broadcaster.CheckUserCanLogin(username) {
+	// a client coming back within the grace window resumes its session
+	// with the token it got at login and the last sequence number it saw
+	resumeToken := c.Query("resume")
+	resuming := resumeToken != "" &&
+		models.Broadcaster.CheckUserCanResume(username, resumeToken)
+
+	if !resuming && !models.Broadcaster.CheckUserCanLogin(username) {
 		log.Printf("user already existed: %v", username)
+		loginFailures.Inc(failureDuplicate)
 		return nil, duplicateLoginErr
 	}
 
+	// the first room in the query is the one the user talks in by default
+	rooms := c.QueryArray("room")
+	for _, room := range rooms {
+		if err := utils.ValidateRoomName(room); err != nil {
+			log.Printf("illegal room name: %v", room)
+			loginFailures.Inc(failureInvalidRoom)
+			return nil, err
+		}
+	}
+
 	user := models.NewUser(conn, username, c.Request.RemoteAddr)
+	user.Role = models.Accounts.Role(username)
+	if len(rooms) > 0 {
+		user.Room = rooms[0]
+	}
+
+	if resuming {
+		lastSeq, _ := strconv.ParseUint(c.Query("last_seq"), 10, 64)
+		user.RequestResume(resumeToken, lastSeq)
+	}
 	log.Printf("user authenticated: %s", username)
 	return user, nil
 }
 
 func setupUserSession(c *gin.Context, user *models.User) {
+	// Read the client frames through the dispatcher.
+	user.SetFrameHandler(dispatchFrame)
+
 	// Start the message-sending goroutine.
 	go user.SendMessage(c)
 
+	// Ping the client until the request ends.
+	go user.Heartbeat(c.Request.Context())
+
 	// Send welcome message to the user.
 	user.MessageChannel <- models.NewWelcomeMsg(user)
 
 	// Add user to broadcaster's active user list.
 	models.Broadcaster.UserLogin(user)
 
+	// Join the rest of the requested rooms.
+	for _, room := range c.QueryArray("room") {
+		if room != user.Room {
+			models.Broadcaster.JoinRoom(user, room)
+		}
+	}
+
 	log.Printf("%s has entered the chatroom", user.Name)
 }
 
@@ -111,8 +198,14 @@ func teardownUserSession(user *models.User) error {
 		return duplicateLogoutErr
 	}
 
-	models.Broadcaster.UserLogout(user)
-	log.Printf("%s has exited the chatroom", user.Name)
-	user.CloseChannel()
+	// a lost connection keeps the session for a while so the client can resume it,
+	// the broadcaster closes the user's message channel either way
+	if user.LeftNormally() {
+		models.Broadcaster.UserLogout(user)
+		log.Printf("%s has exited the chatroom", user.Name)
+	} else {
+		models.Broadcaster.UserDetach(user)
+		log.Printf("%s has lost the connection", user.Name)
+	}
 	return nil
 }
@@ -3,11 +3,15 @@ package api
 import (
 	"context"
 	"errors"
+	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 
 	"github.com/fyerfyer/chatroom/models"
+	"github.com/fyerfyer/chatroom/pkg/auth"
+	"github.com/fyerfyer/chatroom/pkg/ratelimit"
+	"github.com/fyerfyer/chatroom/pkg/setting"
 	"github.com/gin-gonic/gin"
 	"nhooyr.io/websocket"
 	"nhooyr.io/websocket/wsjson"
@@ -23,7 +27,7 @@ func TestHandleAuthenticateUser(t *testing.T) {
 
 	r := gin.Default()
 	r.GET("/ws", func(c *gin.Context) {
-		conn, err := websocket.Accept(c.Writer, c.Request, nil)
+		conn, err := initWebSocketConnection(c)
 		if err != nil {
 			t.Errorf("failed to accepted websocket connection: %v", err)
 			return
@@ -51,8 +55,15 @@ func TestHandleAuthenticateUser(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		url := "ws://" + server.Listener.Addr().String() + "/ws?name=valid_user"
-		conn, _, err := websocket.Dial(ctx, url, nil)
+		token, _, err := auth.IssueToken("valid_user")
+		if err != nil {
+			t.Fatalf("failed to issue token: %v", err)
+		}
+
+		url := "ws://" + server.Listener.Addr().String() + "/ws"
+		conn, _, err := websocket.Dial(ctx, url, &websocket.DialOptions{
+			HTTPHeader: http.Header{"Authorization": []string{"Bearer " + token}},
+		})
 		if err != nil {
 			t.Errorf("failed to establish websocket connection: %v", err)
 			return
@@ -72,12 +83,80 @@ func TestHandleAuthenticateUser(t *testing.T) {
 		conn.Close(websocket.StatusNormalClosure, "")
 	})
 
-	t.Run("invalid username", func(t *testing.T) {
+	t.Run("token in subprotocol", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		token, _, err := auth.IssueToken("browser_user")
+		if err != nil {
+			t.Fatalf("failed to issue token: %v", err)
+		}
+
+		url := "ws://" + server.Listener.Addr().String() + "/ws"
+		conn, _, err := websocket.Dial(ctx, url, &websocket.DialOptions{
+			Subprotocols: []string{chatSubprotocol, tokenSubprotocolTag + token},
+		})
+		if err != nil {
+			t.Errorf("failed to establish websocket connection: %v", err)
+			return
+		}
+		defer conn.Close(websocket.StatusNormalClosure, "")
+
+		var res map[string]string
+		if err := wsjson.Read(ctx, conn, &res); err != nil {
+			t.Errorf("failed to get normal login response: %v", err)
+			return
+		}
+		if res["message"] != welcomeMsg+"browser_user" {
+			t.Errorf("should get login message %v, but got %v",
+				welcomeMsg+"browser_user", res["message"])
+		}
+	})
+
+	t.Run("banned user", func(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		url := "ws://" + server.Listener.Addr().String() + "/ws?name=u"
-		conn, _, err := websocket.Dial(ctx, url, nil)
+		ban, err := models.Bans.Add(&models.Ban{Name: "banned_user"}, 0)
+		if err != nil {
+			t.Fatalf("failed to ban: %v", err)
+		}
+		defer models.Bans.Revoke(ban.ID)
+
+		token, _, err := auth.IssueToken("banned_user")
+		if err != nil {
+			t.Fatalf("failed to issue token: %v", err)
+		}
+
+		url := "ws://" + server.Listener.Addr().String() + "/ws"
+		conn, _, err := websocket.Dial(ctx, url, &websocket.DialOptions{
+			HTTPHeader: http.Header{"Authorization": []string{"Bearer " + token}},
+		})
+		if err != nil {
+			t.Errorf("failed to establish websocket connection: %v", err)
+			return
+		}
+		defer conn.Close(websocket.StatusNormalClosure, "test completed")
+
+		var res interface{}
+		if err := wsjson.Read(ctx, conn, &res); err != nil {
+			t.Errorf("failed to get error response: %v", err)
+			return
+		}
+		if res != "invalid user input" {
+			t.Errorf("a banned user should be refused, but got: %v", res)
+		}
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		// the name in the query is not trusted anymore
+		url := "ws://" + server.Listener.Addr().String() + "/ws?name=valid_user"
+		conn, _, err := websocket.Dial(ctx, url, &websocket.DialOptions{
+			HTTPHeader: http.Header{"Authorization": []string{"Bearer forged.token"}},
+		})
 		if err != nil {
 			t.Errorf("failed to establish websocket connection: %v", err)
 			return
@@ -197,3 +276,82 @@ func TestTearDownUserSession(t *testing.T) {
 		return
 	}
 }
+
+func TestHeartbeatEvictsDeadConnection(t *testing.T) {
+	interval, timeout, grace := setting.PingInterval, setting.IdleTimeout, setting.ResumeGrace
+	setting.PingInterval, setting.IdleTimeout = 50*time.Millisecond, 100*time.Millisecond
+	// the lost session is kept for the grace window before the logout
+	setting.ResumeGrace = 100 * time.Millisecond
+	defer func() {
+		setting.PingInterval, setting.IdleTimeout, setting.ResumeGrace = interval, timeout, grace
+	}()
+
+	watcher := &models.User{
+		ID:             2000,
+		Name:           "watcher_user",
+		MessageChannel: make(chan *models.Message, 32),
+	}
+	models.Broadcaster.UserLogin(watcher)
+	defer models.Broadcaster.UserLogout(watcher)
+
+	r := gin.Default()
+	r.GET("/ws", WebSocketHandler)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	token, _, _ := auth.IssueToken("silent_user")
+	url := "ws://" + server.Listener.Addr().String() + "/ws"
+	conn, _, err := websocket.Dial(ctx, url, &websocket.DialOptions{
+		HTTPHeader: http.Header{"Authorization": []string{"Bearer " + token}},
+	})
+	if err != nil {
+		t.Fatalf("failed to establish websocket connection: %v", err)
+	}
+	// the client never reads, so it never answers a ping
+	defer conn.CloseNow()
+
+	if msg := waitMessage(watcher, models.MsgTypeUserLogin); msg == nil || msg.User.Name != "silent_user" {
+		t.Fatalf("wanted the login of silent_user, but got %v", msg)
+	}
+
+	// the dead connection is logged out and the logout is broadcast
+	if msg := waitMessage(watcher, models.MsgTypeUserLogout); msg == nil || msg.User.Name != "silent_user" {
+		t.Errorf("wanted the logout of silent_user, but got %v", msg)
+		return
+	}
+
+	if !models.Broadcaster.CheckUserCanLogin("silent_user") {
+		t.Error("the name of an evicted user should be free again")
+	}
+}
+
+func TestConnectionRateLimit(t *testing.T) {
+	defer func(limiter *ratelimit.Limiter) { connectionLimiter = limiter }(connectionLimiter)
+	connectionLimiter = ratelimit.NewLimiter(0.001, 1)
+
+	r := gin.Default()
+	r.GET("/ws", WebSocketHandler)
+
+	for i, want := range []bool{false, true} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		r.ServeHTTP(w, req)
+
+		if limited := w.Code == http.StatusTooManyRequests; limited != want {
+			t.Errorf("attempt %v: wanted rate limited %v, but got status %v", i, want, w.Code)
+		}
+	}
+
+	// another address has a bucket of its own
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	req.RemoteAddr = "10.0.0.2:1234"
+	r.ServeHTTP(w, req)
+	if w.Code == http.StatusTooManyRequests {
+		t.Error("another address should not be rate limited")
+	}
+}
@@ -1,19 +1,137 @@
 package routers
 
 import (
+	"context"
+	"log"
+	"path/filepath"
+
 	"github.com/fyerfyer/chatroom/models"
+	"github.com/fyerfyer/chatroom/pkg/blob"
+	"github.com/fyerfyer/chatroom/pkg/bots"
+	"github.com/fyerfyer/chatroom/pkg/setting"
+	"github.com/fyerfyer/chatroom/pkg/utils"
+	"github.com/fyerfyer/chatroom/pkg/webhook"
 	"github.com/fyerfyer/chatroom/routers/api"
 	"github.com/gin-gonic/gin"
 )
 
+var webhooks []*webhook.Dispatcher
+
 func InitRouter() *gin.Engine {
 	r := gin.New()
 	r.Use(gin.Logger())
 	r.Use(gin.Recovery())
 
+	store, err := models.NewMessageStore(setting.StorageType, setting.StoragePath)
+	if err != nil {
+		log.Fatalf("Failed to open message store: %v", err)
+	}
+	models.Broadcaster.SetStore(store)
+	if err := models.Broadcaster.RebuildIndex(); err != nil {
+		log.Fatalf("Failed to index messages: %v", err)
+	}
+
+	// the markers are sequence numbers of the store, they only outlive the process with it
+	if setting.StorageType == models.StoreFile {
+		if err := models.ReadMarkers.Load(filepath.Join(utils.RootPath(setting.StoragePath), "read_markers.json")); err != nil {
+			log.Fatalf("Failed to load read markers: %v", err)
+		}
+		if err := models.ThreadFollowers.Load(filepath.Join(utils.RootPath(setting.StoragePath), "thread_followers.json")); err != nil {
+			log.Fatalf("Failed to load thread followers: %v", err)
+		}
+	}
+
+	if setting.Backplane == "tcp" {
+		backplane, err := models.NewTCPBackplane(setting.HubAddr)
+		if err != nil {
+			log.Fatalf("Failed to connect to the hub: %v", err)
+		}
+		models.Broadcaster.SetBackplane(backplane, setting.NodeID)
+	}
+
+	if err := models.Accounts.Load(utils.RootPath(setting.AccountsPath)); err != nil {
+		log.Fatalf("Failed to load accounts: %v", err)
+	}
+
+	if err := models.Bans.Load(utils.RootPath(setting.BansPath)); err != nil {
+		log.Fatalf("Failed to load bans: %v", err)
+	}
+
+	blobs, err := blob.NewDisk(utils.RootPath(setting.AttachmentsPath))
+	if err != nil {
+		log.Fatalf("Failed to open attachment storage: %v", err)
+	}
+	if err := models.Attachments.Load(blobs, utils.RootPath(setting.AttachmentsPath+".json")); err != nil {
+		log.Fatalf("Failed to load attachments: %v", err)
+	}
+
+	for _, name := range setting.Filters {
+		f, err := models.FilterTypes.New(name, setting.FilterConfigs[name])
+		if err != nil {
+			log.Fatalf("Failed to create filter: %v", err)
+		}
+		models.Broadcaster.UseFilter(name, f)
+	}
+
+	for _, hook := range setting.Webhooks {
+		d, err := webhook.New(webhook.Config{
+			Name:        hook.Name,
+			URL:         hook.URL,
+			Secret:      hook.Secret,
+			Events:      hook.Events,
+			QueueLength: hook.QueueLength,
+			MaxRetries:  hook.MaxRetries,
+			Timeout:     hook.Timeout,
+			Backoff:     hook.Backoff,
+		})
+		if err != nil {
+			log.Fatalf("Failed to create webhook %s: %v", hook.Name, err)
+		}
+		models.Broadcaster.AddListener(func(event *models.Event) {
+			d.Send(event.Type, event)
+		})
+		webhooks = append(webhooks, d)
+	}
+
 	go models.Broadcaster.Start()
+
+	for _, name := range setting.Bots {
+		bot, err := bots.New(name, setting.BotRooms)
+		if err != nil {
+			log.Fatalf("Failed to create bot: %v", err)
+		}
+		if _, err := models.Broadcaster.RegisterBot(bot); err != nil {
+			log.Fatalf("Failed to register bot %v: %v", name, err)
+		}
+	}
+
+	r.POST("/register", api.RegisterHandler)
+	r.POST("/login", api.LoginHandler)
 	r.GET("/user_list", api.UserListHandler)
+	r.GET("/room_list", api.RoomListHandler)
+	r.GET("/history", api.HistoryHandler)
+	r.GET("/thread/:id", api.ThreadHandler)
+	r.GET("/unread", api.UnreadHandler)
+	r.GET("/search", api.SearchHandler)
+	r.POST("/upload", api.UploadHandler)
+	r.GET("/attachments/:id", api.AttachmentHandler)
 	r.GET("/ws", api.WebSocketHandler)
+	r.GET("/metrics", api.MetricsHandler)
+
+	admin := r.Group("/admin", api.RequireRole(models.RoleModerator))
+	admin.GET("/bans", api.BanListHandler)
+	admin.POST("/bans", api.BanHandler)
+	admin.DELETE("/bans/:id", api.RevokeBanHandler)
+	admin.PUT("/roles/:name", api.RequireRole(models.RoleAdmin), api.RoleHandler)
 
 	return r
 }
+
+// Close delivers the webhook events still queued until ctx is done
+func Close(ctx context.Context) {
+	for _, d := range webhooks {
+		if err := d.Close(ctx); err != nil {
+			log.Printf("webhook shutdown: %v", err)
+		}
+	}
+}